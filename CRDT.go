@@ -5,7 +5,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"sort"
 	"time"
 )
@@ -26,24 +28,36 @@ or manual conflict resolution.	-----
 6 update the head properly [DONE]
 7 sync metadata efficiently during handshake (during /hello protocol)[DONE]
 8 add an appropriate file version
+9 order versions with hybrid logical clocks instead of the local wall clock [DONE]
+10 CID is the SHA256 of the file content, so old versions can be fetched and compared [DONE]
+11 folder snapshots: versions pointing at a tree of file versions [DONE]
+12 tags and branches merged alongside the versions [DONE]
+13 checkpoints squash old history once every peer has acked the horizon [DONE]
+14 deletions are versions too (Deleted, no CID), so they merge and fork like edits (folderSync.go) [DONE]
 
 
 */
 
 type FileVersion struct {
-	VersionID string    `json:"version_id"` // SHA256 hash of this version (computed from all fields)
-	ParentIDs []string  `json:"parent_ids"` // One or more parent versions (for merge support)
-	Author    string    `json:"author"`     // Peer ID of who made this version
-	Timestamp time.Time `json:"timestamp"`
-	Message   string    `json:"message"` // Optional log
-	CID       string    `json:"cid"`     // IPFS CID or file hash
+	VersionID  string       `json:"version_id"` // SHA256 hash of this version (computed from all fields)
+	ParentIDs  []string     `json:"parent_ids"` // One or more parent versions (for merge support)
+	Author     string       `json:"author"`     // Peer ID of who made this version
+	Timestamp  time.Time    `json:"timestamp"`
+	HLC        HLCTimestamp `json:"hlc"`                  // hybrid logical clock, used for ordering (see hybridLogicalClock.go)
+	Message    string       `json:"message"`              // Optional log
+	CID        string       `json:"cid"`                  // IPFS CID or file hash
+	Tree       *TreeObject  `json:"tree,omitempty"`       // set on folder snapshots, CID is then the tree ID (see snapshots.go)
+	Checkpoint *Checkpoint  `json:"checkpoint,omitempty"` // set when this version replaces squashed history (see ledgerGC.go)
+	Deleted    bool         `json:"deleted,omitempty"`    // tombstone: the file was removed, CID is empty
 }
 
 // FileMetadata represents metadata for a file with multiple versions
 type FileMetadata struct {
 	FileName string                 `json:"file_name"`
-	Versions map[string]FileVersion `json:"versions"` // versionID → version
-	Heads    []string               `json:"heads"`    // latest versions (can have multiple for forks)
+	Versions map[string]FileVersion `json:"versions"`           // versionID → version
+	Heads    []string               `json:"heads"`              // latest versions (can have multiple for forks)
+	Tags     map[string]Tag         `json:"tags,omitempty"`     // immutable signed names (see refs.go)
+	Branches map[string]Branch      `json:"branches,omitempty"` // movable names (see refs.go)
 }
 
 func NewFileVersion(author, message, cid string, parents []string) FileVersion {
@@ -53,6 +67,7 @@ func NewFileVersion(author, message, cid string, parents []string) FileVersion {
 		ParentIDs: parents,
 		Author:    author,
 		Timestamp: timeStamp,
+		HLC:       hlcClock.Now(),
 		Message:   message,
 		CID:       cid,
 	}
@@ -61,6 +76,42 @@ func NewFileVersion(author, message, cid string, parents []string) FileVersion {
 	return version
 }
 
+// NewDeletion creates a tombstone version; it becomes a head like any edit, so a
+// concurrent edit and delete show up as a fork
+func NewDeletion(author, message string, parents []string) FileVersion {
+	version := FileVersion{
+		ParentIDs: parents,
+		Author:    author,
+		Timestamp: time.Now().UTC(),
+		HLC:       hlcClock.Now(),
+		Message:   message,
+		Deleted:   true,
+	}
+	version.VersionID = GenerateHash(version)
+	log.Printf("[crdt][NewDeletion] new tombstone ID: %s", version.VersionID)
+	return version
+}
+
+// computeFileCID hashes file content; the hex SHA-256 is what FileVersion.CID stores
+// and what /file-transfer verifies, so a CID can be checked against received bytes
+func computeFileCID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("[crdt][computeFileCID] cannot open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("[crdt][computeFileCID] read error on %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func contentCID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 func GenerateHash(version FileVersion) string {
 	log.Printf("[crdt][GenerateHash] generating hash for version: %s", version.Message)
 	data := fmt.Sprintf("%v|%s|%s|%s|%s",
@@ -70,14 +121,24 @@ func GenerateHash(version FileVersion) string {
 		version.Message,
 		version.CID,
 	)
+	if version.Deleted {
+		data += "|deleted" // older versions keep their IDs
+	}
+	if !version.HLC.IsZero() {
+		data += "|" + version.HLC.String() // the HLC orders versions, so it can't change under the same ID
+	}
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
 
 func MergeFileMetadata(local, remote FileMetadata) FileMetadata {
 	log.Printf("[crdt][MergeFileMetadata] merging metadata for file: %s", local.FileName)
+	fileName := local.FileName
+	if fileName == "" {
+		fileName = remote.FileName
+	}
 	merged := FileMetadata{
-		FileName: local.FileName,
+		FileName: fileName,
 		Versions: make(map[string]FileVersion),
 		Heads:    []string{},
 	}
@@ -88,25 +149,50 @@ func MergeFileMetadata(local, remote FileMetadata) FileMetadata {
 	}
 	log.Printf("[crdt][MergeFileMetadata] copying remote versions")
 	for k, v := range remote.Versions {
+		if _, known := merged.Versions[k]; known {
+			continue // a resent version can't restamp ours (HLC) and change which head wins
+		}
 		merged.Versions[k] = v
 	}
 
-	log.Printf("[crdt][MergeFileMetadata] combining heads from local and remote")
-	headSet := make(map[string]struct{})
-	for _, h := range append(local.Heads, remote.Heads...) {
-		headSet[h] = struct{}{}
+	log.Printf("[crdt][MergeFileMetadata] recomputing heads from merged versions")
+	merged.Heads = computeHeads(merged.Versions)
+
+	if len(local.Tags)+len(remote.Tags) > 0 {
+		merged.Tags = mergeTags(fileName, local.Tags, remote.Tags)
 	}
-	for h := range headSet {
-		merged.Heads = append(merged.Heads, h)
+	if len(local.Branches)+len(remote.Branches) > 0 {
+		merged.Branches = mergeBranches(merged, local.Branches, remote.Branches)
 	}
+	// drop anything a checkpoint (from either side) replaced; refs first, they protect versions
+	applyCheckpoints(&merged)
 
-	// Optional: sort heads for consistent output
-	sort.Strings(merged.Heads)
 	log.Printf("[crdt][MergeFileMetadata] merge complete with heads: %v", merged.Heads)
 
 	return merged
 }
 
+// computeHeads returns the versions that no other version names as a parent.
+// Unioning both sides' heads would keep a head that the other side already built on,
+// and incremental syncs only carry part of the DAG, so heads are always derived.
+func computeHeads(versions map[string]FileVersion) []string {
+	parentSet := make(map[string]struct{})
+	for _, v := range versions {
+		for _, pid := range v.ParentIDs {
+			parentSet[pid] = struct{}{}
+		}
+	}
+	heads := make([]string, 0)
+	for id := range versions {
+		if _, isParent := parentSet[id]; !isParent {
+			heads = append(heads, id)
+		}
+	}
+	// sort heads for consistent output
+	sort.Strings(heads)
+	return heads
+}
+
 func (f *FileMetadata) AddVersion(version FileVersion) {
 	log.Printf("[crdt][AddVersion] adding version %s to file %s", version.VersionID, f.FileName)
 	if f.Versions == nil {
@@ -137,3 +223,19 @@ func PrintMetadata(meta FileMetadata) {
 	b, _ := json.MarshalIndent(meta, "", "  ")
 	fmt.Println(string(b))
 }
+
+// PrintHistory prints versions oldest first in HLC order, marking heads
+func PrintHistory(meta FileMetadata) {
+	heads := make(map[string]struct{}, len(meta.Heads))
+	for _, h := range meta.Heads {
+		heads[h] = struct{}{}
+	}
+	fmt.Printf("📜 History of %s:\n", meta.FileName)
+	for _, v := range SortedVersions(meta) {
+		marker := " "
+		if _, ok := heads[v.VersionID]; ok {
+			marker = "*"
+		}
+		fmt.Printf(" %s %s  %s  %s  %s\n", marker, shortID(v.VersionID), v.orderKey(), shortID(v.Author), v.Message)
+	}
+}
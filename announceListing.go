@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Rich listings: a name is not enough to tell two offers of a file apart
+
+1. Every announced entry carries type, size, mtime, content CID and the ledger heads [DONE]
+2. The CLI groups identical content across peers and flags copies that are stale
+   compared to our ledger [DONE]
+3. Listings over maxInlineEntries are announced as digest + count only, peers fetch the
+   full listing over /listing/1.0.0 when the digest changed [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- CIDs are cached per path on (size, mtime), re-announcing does not re-hash the folder
+- Inline announcements still carry file_list so nodes from before rich listings see names
+- Staleness is judged against our own ledger: a copy whose heads (or content) we know
+  were superseded is stale, heads we have never seen are "ahead" (sync to get them)
+- The digest is the CID of the sorted listing JSON, a fetched listing must match it
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	listingProtocol   = "/listing/1.0.0"
+	maxInlineEntries  = 200
+	maxListingEntries = 200000
+	maxListingSize    = 64 << 20
+	listingTimeout    = 30 * time.Second
+)
+
+const (
+	entryFile = "file"
+	entryDir  = "dir"
+)
+
+// AnnouncedEntry is one file or folder of a peer's listing
+type AnnouncedEntry struct {
+	Name    string   `json:"name"` // relative, slash separated, folders end with "/"
+	Type    string   `json:"type"`
+	Size    int64    `json:"size,omitempty"`
+	CID     string   `json:"cid,omitempty"`
+	ModTime int64    `json:"mtime,omitempty"` // unix seconds
+	Heads   []string `json:"heads,omitempty"` // ledger heads of the file when announced
+}
+
+type cachedCID struct {
+	size  int64
+	mtime int64
+	cid   string
+}
+
+// listingResponse is what /listing/1.0.0 sends back
+type listingResponse struct {
+	Digest  string           `json:"digest"`
+	Entries []AnnouncedEntry `json:"entries"`
+}
+
+// buildLocalListing walks the workspace's shared roots, remembers the result for /listing
+// and brings the full-text index up to date
+func buildLocalListing(ws *Workspace) ([]AnnouncedEntry, string, error) {
+	var entries []AnnouncedEntry
+	seen := make(map[string]bool)
+
+	err := ws.walkShared(func(_ *SharedRoot, path, name string, info os.FileInfo) error {
+		if info.IsDir() {
+			entries = append(entries, AnnouncedEntry{Name: name + "/", Type: entryDir, ModTime: info.ModTime().Unix()})
+			return nil
+		}
+		cid, err := ws.cachedFileCID(path, info)
+		if err != nil {
+			log.Printf("[Listing][buildLocalListing] Skipping '%s': %v", name, err)
+			return nil
+		}
+		seen[path] = true
+		entry := AnnouncedEntry{Name: name, Type: entryFile, Size: info.Size(), CID: cid, ModTime: info.ModTime().Unix()}
+		if meta, ok := ws.Store.Get(name); ok {
+			entry.Heads = append([]string(nil), meta.Heads...)
+			sort.Strings(entry.Heads)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("[Listing][buildLocalListing] %w", err)
+	}
+
+	ws.listingLock.Lock()
+	for path := range ws.cids {
+		if !seen[path] {
+			delete(ws.cids, path)
+		}
+	}
+	sortEntries(entries)
+	digest := listingDigest(entries)
+	ws.listing, ws.listingDigest = entries, digest
+	ws.listingLock.Unlock()
+
+	ws.Text.Update(ws.sharedPath, entries)
+	return entries, digest, nil
+}
+
+// cachedFileCID hashes path unless its size and mtime are unchanged since the last time
+func (ws *Workspace) cachedFileCID(path string, info os.FileInfo) (string, error) {
+	ws.listingLock.Lock()
+	c, ok := ws.cids[path]
+	ws.listingLock.Unlock()
+	if ok && c.size == info.Size() && c.mtime == info.ModTime().UnixNano() {
+		return c.cid, nil
+	}
+	cid, err := computeFileCID(path)
+	if err != nil {
+		return "", err
+	}
+	ws.listingLock.Lock()
+	ws.cids[path] = cachedCID{size: info.Size(), mtime: info.ModTime().UnixNano(), cid: cid}
+	ws.listingLock.Unlock()
+	return cid, nil
+}
+
+func sortEntries(entries []AnnouncedEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+}
+
+func listingDigest(entries []AnnouncedEntry) string {
+	data, _ := json.Marshal(entries)
+	return contentCID(data)
+}
+
+// checkAnnouncedEntries is the schema check shared by inline and fetched listings
+func checkAnnouncedEntries(entries []AnnouncedEntry, limit int) error {
+	if len(entries) > limit {
+		return fmt.Errorf("%d entries, limit is %d", len(entries), limit)
+	}
+	for _, e := range entries {
+		if err := checkAnnouncedPath(e.Name); err != nil {
+			return err
+		}
+		switch {
+		case e.Type != entryFile && e.Type != entryDir:
+			return fmt.Errorf("entry %q has unknown type %q", e.Name, e.Type)
+		case e.Type == entryDir && !strings.HasSuffix(e.Name, "/"):
+			return fmt.Errorf("folder entry %q does not end with /", e.Name)
+		case e.Size < 0:
+			return fmt.Errorf("entry %q has negative size", e.Name)
+		case len(e.CID) > 128 || len(e.Heads) > 64:
+			return fmt.Errorf("entry %q has oversized hashes", e.Name)
+		}
+	}
+	return nil
+}
+
+// registerListingHandler serves our current listing to peers that saw our digest
+func registerListingHandler(ws *Workspace) {
+	node.SetStreamHandler(ws.Protocol(listingProtocol), ws.guarded(func(s network.Stream) {
+		defer s.Close()
+		ws.listingLock.Lock()
+		resp := listingResponse{Digest: ws.listingDigest, Entries: ws.listing}
+		ws.listingLock.Unlock()
+		if err := json.NewEncoder(s).Encode(resp); err != nil {
+			log.Printf("[Listing][/listing] Failed to send listing to %s: %v", shortID(s.Conn().RemotePeer().String()), err)
+			_ = s.Reset()
+		}
+	}))
+	log.Printf("[Stream] Handler registered for %s", ws.Protocol(listingProtocol))
+}
+
+// fetchListing downloads the full listing of a peer that announced only its digest
+func fetchListing(ws *Workspace, from peer.ID, digest string, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), listingTimeout)
+	defer cancel()
+	s, err := ws.newStream(ctx, from, ws.Protocol(listingProtocol))
+	if err != nil {
+		log.Printf("[Listing][fetchListing] Cannot reach %s for its listing: %v", shortID(from.String()), err)
+		return
+	}
+	defer s.Close()
+	_ = s.SetReadDeadline(time.Now().Add(listingTimeout))
+
+	var resp listingResponse
+	if err := json.NewDecoder(io.LimitReader(s, maxListingSize)).Decode(&resp); err != nil {
+		log.Printf("[Listing][fetchListing] Bad listing from %s: %v", shortID(from.String()), err)
+		return
+	}
+	if err := checkAnnouncedEntries(resp.Entries, maxListingEntries); err != nil {
+		log.Printf("[Listing][fetchListing] Rejected listing from %s: %v", shortID(from.String()), err)
+		return
+	}
+	sortEntries(resp.Entries)
+	if got := listingDigest(resp.Entries); got != resp.Digest {
+		log.Printf("[Listing][fetchListing] Listing from %s does not match its digest", shortID(from.String()))
+		return
+	}
+	if resp.Digest != digest {
+		log.Printf("[Listing][fetchListing] %s changed its listing since announcing it, keeping the newer one", shortID(from.String()))
+	}
+	storeListing(ws, from.String(), resp.Entries, resp.Digest, ttl)
+	log.Printf("[Listing][fetchListing] Fetched %d entries from %s", len(resp.Entries), shortID(from.String()))
+	printLock.Lock()
+	showAvailableFiles(ws)
+	printLock.Unlock()
+}
+
+// storeListing replaces a peer's listing; ttl 0 means the peer does not re-announce (older node)
+func storeListing(ws *Workspace, peerID string, entries []AnnouncedEntry, digest string, ttl time.Duration) {
+	ws.filesLock.Lock()
+	ws.files[peerID] = entries
+	ws.filesDigest[peerID] = digest
+	ws.filesAt[peerID] = time.Now()
+	ws.filesTTL[peerID] = ttl
+	ws.filesLock.Unlock()
+	ws.Subs.Kick()
+	if ws.Sync {
+		ws.Syncer.ListingChanged(peerID)
+	}
+}
+
+// Copy states shown next to an announced file
+const (
+	copyCurrent  = "current"
+	copyStale    = "stale"    // we know newer versions than the ones it holds
+	copyAhead    = "ahead"    // has versions we have not synced yet
+	copyModified = "modified" // content is not any version in our ledger
+)
+
+// copyStatus compares an announced copy with our ledger, "" when we cannot tell
+func copyStatus(meta FileMetadata, tracked bool, e AnnouncedEntry) string {
+	if !tracked || e.Type != entryFile {
+		return ""
+	}
+	heads := make(map[string]bool, len(meta.Heads))
+	headCIDs := make(map[string]bool, len(meta.Heads))
+	for _, h := range meta.Heads {
+		heads[h] = true
+		headCIDs[meta.Versions[h].CID] = true
+	}
+	if len(e.Heads) > 0 {
+		superseded := false
+		for _, h := range e.Heads {
+			if _, known := meta.Versions[h]; !known {
+				return copyAhead
+			}
+			superseded = superseded || !heads[h]
+		}
+		if superseded {
+			return copyStale
+		}
+	}
+	if e.CID == "" {
+		return ""
+	}
+	if headCIDs[e.CID] {
+		return copyCurrent
+	}
+	for _, v := range meta.Versions {
+		if v.CID == e.CID {
+			return copyStale
+		}
+	}
+	return copyModified
+}
+
+// showAvailableFiles prints who is listed, then every entry with identical content grouped
+func showAvailableFiles(ws *Workspace) {
+	ws.filesLock.Lock()
+	defer ws.filesLock.Unlock()
+
+	fmt.Printf("📂 Available Files/Folders in %s:\n", ws.Name)
+	if len(ws.files) == 0 {
+		fmt.Println("   (No announcements yet)")
+		return
+	}
+
+	peerIDs := make([]string, 0, len(ws.files))
+	for peerID := range ws.files {
+		peerIDs = append(peerIDs, peerID)
+	}
+	sort.Strings(peerIDs)
+
+	type offer struct {
+		entry  AnnouncedEntry
+		status string
+		peers  []string
+	}
+	byName := make(map[string][]*offer)
+	for _, peerID := range peerIDs {
+		entries := ws.files[peerID]
+		state := liveness.State(peerID).State
+		fmt.Printf("🧑 %s  %s %s, %d entries, listed %s ago\n", peerID, stateIcon(state), state, len(entries), time.Since(ws.filesAt[peerID]).Round(time.Second))
+		for _, e := range entries {
+			meta, tracked := ws.Store.Get(e.Name)
+			status := copyStatus(meta, tracked, e)
+			var o *offer
+			for _, existing := range byName[e.Name] {
+				if existing.entry.CID == e.CID && existing.status == status {
+					o = existing
+					break
+				}
+			}
+			if o == nil {
+				o = &offer{entry: e, status: status}
+				byName[e.Name] = append(byName[e.Name], o)
+			}
+			o.peers = append(o.peers, shortID(peerID))
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		offers := byName[name]
+		if offers[0].entry.Type == entryDir {
+			fmt.Printf("   📁 %s  ← %s\n", name, strings.Join(offers[0].peers, ", "))
+			continue
+		}
+		fmt.Printf("   📄 %s\n", name)
+		for _, o := range offers {
+			fmt.Printf("      %s\n", describeOffer(o.entry, o.status, o.peers))
+		}
+	}
+}
+
+func describeOffer(e AnnouncedEntry, status string, peers []string) string {
+	var b strings.Builder
+	if e.CID == "" {
+		b.WriteString("(no details, older peer)")
+	} else {
+		fmt.Fprintf(&b, "%-9s %s  %s", humanSize(e.Size), shortID(e.CID), time.Unix(e.ModTime, 0).Format("2006-01-02 15:04"))
+	}
+	switch status {
+	case copyStale:
+		b.WriteString("  ⚠️ stale")
+	case copyAhead:
+		b.WriteString("  ⏩ newer than ours")
+	case copyModified:
+		b.WriteString("  ✏️ unrecorded edit")
+	}
+	fmt.Fprintf(&b, "  ← %s", strings.Join(peers, ", "))
+	return b.String()
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Listings used to go out once, 15s after startup, and then only on Enter
+
+1. Full announcement right after joining the topic, then every reannounceInterval,
+   each carrying a TTL after which receivers drop it [DONE]
+2. shared/ is rescanned every deltaScanInterval, changes go out as add/remove deltas [DONE]
+3. When a peer joins the topic we ask everyone for their state (request flag), the
+   answers are ordinary full announcements [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- A delta names the digest it applies to (base). A receiver that holds another digest
+  for that peer (missed or throttled message) fetches the full listing instead
+- A request is itself a full announcement, nodes that published a full one within
+  requestReplyWindow don't answer, so a join costs one message per node
+- Deltas still carry file_list (names only) for inline sized listings, so nodes from
+  before deltas keep a correct list of names
+- Full listings, deltas and request answers together stay under the validator's
+  per-topic refill rate (announceValidation.go, announceValidation_test.go)
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	reannounceInterval = time.Minute
+	announceTTL        = 3 * reannounceInterval
+	deltaScanInterval  = 15 * time.Second
+	requestCooldown    = 30 * time.Second
+	requestReplyWindow = 3 * time.Second // replies are spread over this and skipped if we just announced
+	maxAnnounceTTL     = 24 * time.Hour
+)
+
+const (
+	announceFull  = "full"
+	announceDelta = "delta"
+)
+
+// startAnnouncer publishes the workspace's listing now, periodically and whenever shared/ changes
+func startAnnouncer(ctx context.Context, ws *Workspace) {
+	events, err := ws.topic.EventHandler()
+	if err != nil {
+		log.Printf("[Announce][startAnnouncer] No topic events for %s, late joiners wait for the next re-announce: %v", ws.Name, err)
+	} else {
+		go func() {
+			defer events.Cancel()
+			for {
+				ev, err := events.NextPeerEvent(ctx)
+				if err != nil {
+					return
+				}
+				if ev.Type == pubsub.PeerJoin {
+					requestAnnouncements(ws, ev.Peer)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		announceLocalFiles(ws)
+		full := time.NewTicker(reannounceInterval)
+		scan := time.NewTicker(deltaScanInterval)
+		defer full.Stop()
+		defer scan.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-full.C:
+				announceLocalFiles(ws)
+			case <-scan.C:
+				announceChanges(ws)
+			}
+		}
+	}()
+}
+
+// requestAnnouncements publishes our listing and asks every member to answer with theirs
+func requestAnnouncements(ws *Workspace, joined peer.ID) {
+	ws.announceLock.Lock()
+	defer ws.announceLock.Unlock()
+	if time.Since(ws.lastRequestAt) < requestCooldown {
+		return
+	}
+	ws.lastRequestAt = time.Now()
+	log.Printf("[Announce][requestAnnouncements] %s joined %s, asking for current listings", shortID(joined.String()), ws.Name)
+	publishFullListing(ws, true)
+}
+
+// answerRequest re-announces after a random delay unless a full announcement went out meanwhile
+func answerRequest(ws *Workspace, from string) {
+	time.Sleep(time.Duration(rand.Int63n(int64(requestReplyWindow))))
+	ws.announceLock.Lock()
+	defer ws.announceLock.Unlock()
+	if time.Since(ws.lastFullAt) < requestReplyWindow {
+		return
+	}
+	log.Printf("[Announce][answerRequest] %s asked for listings in %s", shortID(from), ws.Name)
+	publishFullListing(ws, false)
+}
+
+// announceChanges rescans shared/ and publishes what was added, changed or removed since the last listing
+func announceChanges(ws *Workspace) {
+	ws.announceLock.Lock()
+	defer ws.announceLock.Unlock()
+
+	ws.listingLock.Lock()
+	prev, prevDigest := ws.listing, ws.listingDigest
+	ws.listingLock.Unlock()
+
+	entries, digest, err := buildLocalListing(ws)
+	if err != nil {
+		log.Printf("[Announce][announceChanges] %v", err)
+		return
+	}
+	if digest == prevDigest {
+		return
+	}
+	added, removed := diffListings(prev, entries)
+	if prevDigest == "" || len(added)+len(removed) > maxInlineEntries {
+		sendFullListing(ws, entries, digest, false)
+		return
+	}
+
+	msg := FileAnnouncement{
+		PeerID:  node.ID().String(),
+		Kind:    announceDelta,
+		Base:    prevDigest,
+		Digest:  digest,
+		Total:   len(entries),
+		TTL:     int(announceTTL.Seconds()),
+		Added:   added,
+		Removed: removed,
+	}
+	if len(entries) <= maxInlineEntries {
+		msg.FileList = entryNames(entries)
+	}
+	if publishAnnouncement(ws, msg) {
+		log.Printf("[Announce] Announced changes in %s: %d added/changed, removed %v", ws.Name, len(added), removed)
+	}
+}
+
+// diffListings returns entries that are new or differ in old, and names that disappeared
+func diffListings(old, cur []AnnouncedEntry) ([]AnnouncedEntry, []string) {
+	before := make(map[string]AnnouncedEntry, len(old))
+	for _, e := range old {
+		before[e.Name] = e
+	}
+	var added []AnnouncedEntry
+	for _, e := range cur {
+		prev, ok := before[e.Name]
+		delete(before, e.Name)
+		if !ok || !sameEntry(prev, e) {
+			added = append(added, e)
+		}
+	}
+	var removed []string
+	for name := range before {
+		removed = append(removed, name)
+	}
+	return added, removed
+}
+
+func sameEntry(a, b AnnouncedEntry) bool {
+	if a.Type != b.Type || a.Size != b.Size || a.CID != b.CID || a.ModTime != b.ModTime || len(a.Heads) != len(b.Heads) {
+		return false
+	}
+	for i := range a.Heads {
+		if a.Heads[i] != b.Heads[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyDelta patches the stored listing of a peer, or fetches the full one if we are out of step
+func applyDelta(ws *Workspace, from peer.ID, ann FileAnnouncement) {
+	ttl := time.Duration(ann.TTL) * time.Second
+	ws.filesLock.Lock()
+	current, known := ws.files[ann.PeerID]
+	inStep := known && ws.filesDigest[ann.PeerID] == ann.Base
+	ws.filesLock.Unlock()
+	if !inStep {
+		log.Printf("[Announce] Missed earlier changes of %s in %s, fetching its listing", shortID(ann.PeerID), ws.Name)
+		go fetchListing(ws, from, ann.Digest, ttl)
+		return
+	}
+
+	byName := make(map[string]AnnouncedEntry, len(current)+len(ann.Added))
+	for _, e := range current {
+		byName[e.Name] = e
+	}
+	for _, name := range ann.Removed {
+		delete(byName, name)
+	}
+	for _, e := range ann.Added {
+		byName[e.Name] = e
+	}
+	entries := make([]AnnouncedEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sortEntries(entries)
+	if listingDigest(entries) != ann.Digest {
+		log.Printf("[Announce] Delta from %s in %s does not add up, fetching its listing", shortID(ann.PeerID), ws.Name)
+		go fetchListing(ws, from, ann.Digest, ttl)
+		return
+	}
+	storeListing(ws, ann.PeerID, entries, ann.Digest, ttl)
+	log.Printf("[Announce] Peer %s changed in %s: %d added/changed, removed %v", ann.PeerID, ws.Name, len(ann.Added), ann.Removed)
+}
+
+func entryNames(entries []AnnouncedEntry) []string {
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+	return names
+}
+
+func publishAnnouncement(ws *Workspace, msg FileAnnouncement) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[PubSub][publishAnnouncement] ❌ JSON encode failed: %v", err)
+		return false
+	}
+	if err := ws.topic.Publish(context.Background(), data); err != nil {
+		log.Printf("[PubSub][publishAnnouncement] Failed to publish: %v", err)
+		return false
+	}
+	return true
+}
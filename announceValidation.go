@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Announcements used to be trusted blindly (ann.PeerID came straight from the JSON body)
+
+1. Every message is signed by its origin (StrictSign), the claimed peer_id must be the signer [DONE]
+2. Size limit and schema check (peer ID, relative clean paths, entry count) [DONE]
+3. Per-origin rate limit, one token bucket per topic: every workspace's announce topic
+   and search topic (search.go) has its own allowance [DONE]
+4. Failures feed gossipsub peer scoring: rejected messages count as invalid deliveries
+   of the forwarding peer, and the origin gets an app-specific penalty [DONE]
+5. peers shows origins that are currently penalized [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- The validator stores the decoded FileAnnouncement in msg.ValidatorData, the
+  listener never parses the body again
+- Throttled messages are ignored (not rejected) so honest peers relaying a chatty
+  origin are not punished for it, only the origin's penalty grows
+- The bucket is sized for announceSchedule.go: per topic an honest node sends a full
+  listing every reannounceInterval (1/min), a delta every deltaScanInterval (4/min) and
+  answers at most one request per requestCooldown (2/min), 7/min at worst. The refill
+  (12/min) leaves room for Enter, the burst covers startup and a few joins at once
+- Penalties halve every penaltyHalfLife; score = -penalty, so a peer that behaves
+  again climbs back above the gossip threshold on its own
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	maxAnnouncementSize    = 512 << 10
+	maxAnnouncedEntries    = 5000
+	maxAnnouncedPathLen    = 1024
+	announceBurst          = 10              // messages an origin may send back to back on one topic
+	announceRefillInterval = 5 * time.Second // one more every interval after that
+	invalidAnnouncePenalty = 5.0
+	throttledPenalty       = 1.0
+	penaltyHalfLife        = 5 * time.Minute
+)
+
+// tokenBucket holds one origin's allowance for one kind of message
+type tokenBucket struct {
+	tokens   float64
+	refilled time.Time
+}
+
+type originState struct {
+	buckets   map[string]*tokenBucket // topic name → allowance
+	penalty   float64
+	decayedAt time.Time
+	rejected  int
+	throttled int
+	lastError string
+}
+
+type AnnounceGuard struct {
+	mu      sync.Mutex
+	self    peer.ID
+	origins map[peer.ID]*originState
+}
+
+var announceGuard *AnnounceGuard
+
+func NewAnnounceGuard(self peer.ID) *AnnounceGuard {
+	return &AnnounceGuard{self: self, origins: make(map[peer.ID]*originState)}
+}
+
+// pubsubOptions makes gossipsub sign/verify everything and score peers with our penalties
+func (g *AnnounceGuard) pubsubOptions() []pubsub.Option {
+	params := &pubsub.PeerScoreParams{
+		SkipAtomicValidation: true,
+		Topics:               make(map[string]*pubsub.TopicScoreParams),
+		AppSpecificScore:     g.appScore,
+		AppSpecificWeight:    1,
+		DecayInterval:        pubsub.DefaultDecayInterval,
+		DecayToZero:          pubsub.DefaultDecayToZero,
+	}
+	thresholds := &pubsub.PeerScoreThresholds{
+		GossipThreshold:             -10,
+		PublishThreshold:            -50,
+		GraylistThreshold:           -80,
+		AcceptPXThreshold:           10,
+		OpportunisticGraftThreshold: 3,
+	}
+	return []pubsub.Option{
+		pubsub.WithMessageSignaturePolicy(pubsub.StrictSign),
+		pubsub.WithPeerScore(params, thresholds),
+	}
+}
+
+// announceTopicScoreParams: every rejected announcement a peer forwards costs it
+// InvalidMessageDeliveriesWeight × count², so a few are enough to graylist it
+func announceTopicScoreParams() *pubsub.TopicScoreParams {
+	return &pubsub.TopicScoreParams{
+		SkipAtomicValidation:           true,
+		TopicWeight:                    1,
+		TimeInMeshQuantum:              time.Second, // unused (weight 0), but the scorer divides by it
+		InvalidMessageDeliveriesWeight: -5,
+		InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(10 * time.Minute),
+	}
+}
+
+// register installs the validator and score parameters on a workspace topic (after Join)
+func (g *AnnounceGuard) register(ps *pubsub.PubSub, ws *Workspace) error {
+	if err := ps.RegisterTopicValidator(ws.TopicName(), g.validator(ws)); err != nil {
+		return fmt.Errorf("[announceGuard][register] %w", err)
+	}
+	if err := ws.topic.SetScoreParams(announceTopicScoreParams()); err != nil {
+		return fmt.Errorf("[announceGuard][register] %w", err)
+	}
+	return nil
+}
+
+func (g *AnnounceGuard) validator(ws *Workspace) pubsub.ValidatorEx {
+	return func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		origin := msg.GetFrom()
+		ann, err := parseAnnouncement(msg.Data, origin)
+		if err != nil {
+			g.penalize(origin, invalidAnnouncePenalty, err)
+			log.Printf("[announceGuard][validate] Rejected announcement from %s in %s: %v", shortID(origin.String()), ws.Name, err)
+			return pubsub.ValidationReject
+		}
+		if origin != g.self && !g.allow(origin, ws.TopicName()) {
+			log.Printf("[announceGuard][validate] %s announces too often in %s, dropping", shortID(origin.String()), ws.Name)
+			return pubsub.ValidationIgnore
+		}
+		msg.ValidatorData = ann
+		return pubsub.ValidationAccept
+	}
+}
+
+// parseAnnouncement checks size and schema and that the body names its signer
+func parseAnnouncement(data []byte, origin peer.ID) (FileAnnouncement, error) {
+	var ann FileAnnouncement
+	if len(data) > maxAnnouncementSize {
+		return ann, fmt.Errorf("%d bytes, limit is %d", len(data), maxAnnouncementSize)
+	}
+	if err := json.Unmarshal(data, &ann); err != nil {
+		return ann, fmt.Errorf("bad JSON: %w", err)
+	}
+	claimed, err := peer.Decode(ann.PeerID)
+	if err != nil {
+		return ann, fmt.Errorf("bad peer_id %q", ann.PeerID)
+	}
+	if claimed != origin {
+		return ann, fmt.Errorf("claims to be %s but is signed by %s", shortID(ann.PeerID), shortID(origin.String()))
+	}
+	if len(ann.FileList) > maxAnnouncedEntries {
+		return ann, fmt.Errorf("%d entries, limit is %d", len(ann.FileList), maxAnnouncedEntries)
+	}
+	for _, name := range ann.FileList {
+		if err := checkAnnouncedPath(name); err != nil {
+			return ann, err
+		}
+	}
+	if err := checkAnnouncedEntries(ann.Entries, maxAnnouncedEntries); err != nil {
+		return ann, err
+	}
+	if ann.Total < 0 || ann.Total > maxListingEntries || len(ann.Digest) > 128 {
+		return ann, fmt.Errorf("bad listing digest or total")
+	}
+	if err := checkAnnouncedEntries(ann.Added, maxAnnouncedEntries); err != nil {
+		return ann, err
+	}
+	if len(ann.Removed) > maxAnnouncedEntries {
+		return ann, fmt.Errorf("%d removals, limit is %d", len(ann.Removed), maxAnnouncedEntries)
+	}
+	for _, name := range ann.Removed {
+		if err := checkAnnouncedPath(name); err != nil {
+			return ann, err
+		}
+	}
+	switch {
+	case ann.Kind != "" && ann.Kind != announceFull && ann.Kind != announceDelta:
+		return ann, fmt.Errorf("unknown kind %q", ann.Kind)
+	case ann.TTL < 0 || time.Duration(ann.TTL)*time.Second > maxAnnounceTTL:
+		return ann, fmt.Errorf("ttl %ds out of range", ann.TTL)
+	case ann.Kind == announceDelta && (ann.Base == "" || ann.Digest == ""):
+		return ann, fmt.Errorf("delta without base or digest")
+	}
+	return ann, nil
+}
+
+// checkAnnouncedPath accepts relative paths that stay inside the shared folder ("dir/" for folders)
+func checkAnnouncedPath(name string) error {
+	trimmed := strings.TrimSuffix(strings.ReplaceAll(name, `\`, "/"), "/")
+	switch {
+	case trimmed == "" || len(name) > maxAnnouncedPathLen:
+		return fmt.Errorf("bad entry %q", name)
+	case strings.HasPrefix(trimmed, "/") || path.Clean(trimmed) != trimmed || trimmed == ".." || strings.HasPrefix(trimmed, "../"):
+		return fmt.Errorf("entry %q is not a clean relative path", name)
+	case strings.ContainsRune(name, 0) || strings.ContainsRune(name, '\n'):
+		return fmt.Errorf("entry %q has control characters", name)
+	}
+	return nil
+}
+
+// allow takes one token from the origin's bucket for topic
+func (g *AnnounceGuard) allow(origin peer.ID, topic string) bool {
+	return g.allowAt(origin, topic, time.Now())
+}
+
+func (g *AnnounceGuard) allowAt(origin peer.ID, topic string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := g.stateLocked(origin)
+	b, ok := st.buckets[topic]
+	if !ok {
+		b = &tokenBucket{tokens: announceBurst, refilled: now}
+		st.buckets[topic] = b
+	}
+	b.tokens = math.Min(announceBurst, b.tokens+now.Sub(b.refilled).Seconds()/announceRefillInterval.Seconds())
+	b.refilled = now
+	if b.tokens < 1 {
+		st.throttled++
+		g.addPenaltyLocked(st, throttledPenalty)
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (g *AnnounceGuard) penalize(origin peer.ID, amount float64, reason error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := g.stateLocked(origin)
+	st.rejected++
+	st.lastError = reason.Error()
+	g.addPenaltyLocked(st, amount)
+}
+
+func (g *AnnounceGuard) stateLocked(origin peer.ID) *originState {
+	st, ok := g.origins[origin]
+	if !ok {
+		now := time.Now()
+		st = &originState{buckets: make(map[string]*tokenBucket), decayedAt: now}
+		g.origins[origin] = st
+	}
+	return st
+}
+
+func (g *AnnounceGuard) addPenaltyLocked(st *originState, amount float64) {
+	st.penalty = decayedPenalty(st, time.Now()) + amount
+	st.decayedAt = time.Now()
+}
+
+func decayedPenalty(st *originState, now time.Time) float64 {
+	return st.penalty * math.Pow(0.5, now.Sub(st.decayedAt).Seconds()/penaltyHalfLife.Seconds())
+}
+
+// appScore is gossipsub's app-specific score: minus the origin's current penalty
+func (g *AnnounceGuard) appScore(p peer.ID) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st, ok := g.origins[p]
+	if !ok {
+		return 0
+	}
+	return -decayedPenalty(st, time.Now())
+}
+
+// Print lists origins that sent invalid or too many announcements
+func (g *AnnounceGuard) Print() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ids []peer.ID
+	for id, st := range g.origins {
+		if st.rejected > 0 || st.throttled > 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	fmt.Println("🚫 Misbehaving announcers:")
+	now := time.Now()
+	for _, id := range ids {
+		st := g.origins[id]
+		fmt.Printf("   %s  score %.1f, %d rejected, %d throttled", id, -decayedPenalty(st, now), st.rejected, st.throttled)
+		if st.lastError != "" {
+			fmt.Printf(" (last: %s)", st.lastError)
+		}
+		fmt.Println()
+	}
+}
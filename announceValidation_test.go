@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TestAnnounceRateFitsSchedule replays what an honest node publishes per workspace
+// (announceSchedule.go) on several workspaces at once: none of it may be throttled
+func TestAnnounceRateFitsSchedule(t *testing.T) {
+	const workspacesJoined = 3
+	g := NewAnnounceGuard(peer.ID("self"))
+	origin := peer.ID("origin")
+	start := time.Now()
+
+	type message struct {
+		at    time.Duration
+		topic string
+	}
+	var msgs []message
+	for i := 0; i < workspacesJoined; i++ {
+		ws := &Workspace{WorkspaceConfig: WorkspaceConfig{Name: fmt.Sprintf("ws-%d", i)}, ID: workspaceID(fmt.Sprintf("secret-%d", i))}
+		for at := time.Duration(0); at < 30*time.Minute; at += deltaScanInterval {
+			msgs = append(msgs, message{at, ws.TopicName()}) // delta
+			if at%reannounceInterval == 0 {
+				msgs = append(msgs, message{at, ws.TopicName()}) // full listing
+			}
+			if at%requestCooldown == 0 {
+				msgs = append(msgs, message{at, ws.TopicName()}) // answer to a join
+			}
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].at < msgs[j].at })
+
+	for _, m := range msgs {
+		if !g.allowAt(origin, m.topic, start.Add(m.at)) {
+			t.Fatalf("message at %s on %s throttled", m.at, m.topic)
+		}
+	}
+
+	// a flood on one topic is still cut off, without touching the other topics
+	flood := &Workspace{ID: workspaceID("secret-0")}
+	other := &Workspace{ID: workspaceID("secret-1")}
+	now := start.Add(time.Hour)
+	throttled := 0
+	for i := 0; i < 60; i++ {
+		if !g.allowAt(origin, flood.TopicName(), now.Add(time.Duration(i)*time.Second)) {
+			throttled++
+		}
+	}
+	if throttled < 30 {
+		t.Errorf("only %d of 60 messages in a minute throttled", throttled)
+	}
+	if !g.allowAt(origin, other.TopicName(), now.Add(time.Minute)) {
+		t.Errorf("a flood on one topic throttled another")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Discovery beyond the LAN (mDNS only sees one broadcast domain)
+
+1. Optional Kademlia DHT (-dht) next to mDNS [DONE]
+2. Rendezvous namespace derived from the group, peers advertise and look it up [DONE]
+3. Configurable bootstrap peers (-bootstrap), or the public IPFS DHT (-dht-public) [DONE]
+4. Found peers go through handlePeerFound like mDNS ones (knownPeers + metadata sync) [DONE]
+5. go test: N DHT nodes in one process find each other (dhtDiscovery_test.go) [DONE]
+6. One DHT per node, one rendezvous loop per workspace (workspace.go) [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- Private mode (default) runs its own DHT under the /peerlink protocol prefix, every
+  -dht node is a server so any of them can be another one's bootstrap peer:
+      peerlink -dht -bootstrap /ip4/203.0.113.7/tcp/4001/p2p/12D3KooW...
+- The rendezvous key is a hash of the workspace's group (or secret), the group name
+  itself is never published
+- Advertise and FindPeers are repeated every dhtInterval; peers that are already
+  connected and members of the workspace are not handed to handlePeerFound again.
+  That check is per workspace, a peer found through one workspace is still handed to
+  the others it shares with us
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	dhtProtocolPrefix = "/peerlink"
+	dhtInterval       = time.Minute
+)
+
+// discoveryGroup names the group of the default workspace (-group)
+var discoveryGroup = mdnsServiceTag
+
+type DHTConfig struct {
+	Bootstrap []peer.AddrInfo
+	Public    bool // join the public IPFS DHT instead of a private one
+}
+
+// rendezvousNamespace hides the group name behind a hash
+func rendezvousNamespace(group string) string {
+	sum := sha256.Sum256([]byte("peerlink-rendezvous|" + group))
+	return "peerlink/" + hex.EncodeToString(sum[:16])
+}
+
+// parseBootstrapPeers reads a comma separated list of /p2p multiaddrs
+func parseBootstrapPeers(list string) ([]peer.AddrInfo, error) {
+	var peers []peer.AddrInfo
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		info, err := peer.AddrInfoFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("[dhtDiscovery][parseBootstrapPeers] bad bootstrap address %q: %w", s, err)
+		}
+		peers = append(peers, *info)
+	}
+	return peers, nil
+}
+
+// startDHTDiscovery joins the DHT; startDHTRendezvous then looks for the peers of a group
+func startDHTDiscovery(ctx context.Context, h host.Host, cfg DHTConfig) (*dht.IpfsDHT, error) {
+	var opts []dht.Option
+	if cfg.Public {
+		bootstrap := cfg.Bootstrap
+		if len(bootstrap) == 0 {
+			bootstrap = dht.GetDefaultBootstrapPeerAddrInfos()
+		}
+		opts = append(opts, dht.Mode(dht.ModeAuto), dht.BootstrapPeers(bootstrap...))
+	} else {
+		opts = append(opts, dht.Mode(dht.ModeServer), dht.ProtocolPrefix(dhtProtocolPrefix),
+			dht.BootstrapPeers(cfg.Bootstrap...))
+	}
+	kad, err := dht.New(ctx, h, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("[dhtDiscovery][startDHTDiscovery] DHT init failed: %w", err)
+	}
+
+	connected := 0
+	for _, pi := range cfg.Bootstrap {
+		if err := h.Connect(ctx, pi); err != nil {
+			log.Printf("[dhtDiscovery][startDHTDiscovery] Bootstrap peer %s unreachable: %v", pi.ID, err)
+			continue
+		}
+		connected++
+	}
+	if len(cfg.Bootstrap) > 0 && connected == 0 {
+		log.Printf("[dhtDiscovery][startDHTDiscovery] ⚠️ No bootstrap peer reachable, waiting for someone to connect to us")
+	}
+	if err := kad.Bootstrap(ctx); err != nil {
+		kad.Close()
+		return nil, fmt.Errorf("[dhtDiscovery][startDHTDiscovery] bootstrap failed: %w", err)
+	}
+	log.Printf("[dhtDiscovery][startDHTDiscovery] DHT started (public=%v)", cfg.Public)
+	return kad, nil
+}
+
+// startDHTRendezvous keeps advertising / looking up the group's rendezvous namespace
+// every interval (0 = dhtInterval); every found peer that is not connected and known to
+// the caller (known may be nil) is passed to onFound
+func startDHTRendezvous(ctx context.Context, h host.Host, kad *dht.IpfsDHT, group string, interval time.Duration, known func(peer.ID) bool, onFound func(peer.AddrInfo)) {
+	if interval <= 0 {
+		interval = dhtInterval
+	}
+	ns := rendezvousNamespace(group)
+	log.Printf("[dhtDiscovery][startDHTRendezvous] Rendezvous %s", ns)
+
+	go func() {
+		disc := drouting.NewRoutingDiscovery(kad)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			dhtRendezvousRound(ctx, h, kad, disc, ns, known, onFound)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// dhtRendezvousRound advertises us once and hands every new peer to onFound
+func dhtRendezvousRound(ctx context.Context, h host.Host, kad *dht.IpfsDHT, disc *drouting.RoutingDiscovery, ns string, known func(peer.ID) bool, onFound func(peer.AddrInfo)) {
+	if kad.RoutingTable().Size() == 0 {
+		log.Printf("[dhtDiscovery][rendezvous] Routing table empty, retrying later")
+		return
+	}
+	roundCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := disc.Advertise(roundCtx, ns); err != nil {
+		log.Printf("[dhtDiscovery][rendezvous] Advertise failed: %v", err)
+	}
+	found, err := disc.FindPeers(roundCtx, ns)
+	if err != nil {
+		log.Printf("[dhtDiscovery][rendezvous] FindPeers failed: %v", err)
+		return
+	}
+	for pi := range found {
+		if pi.ID == h.ID() || len(pi.Addrs) == 0 {
+			continue
+		}
+		// "known" is the caller's (one workspace's) view: a connection made for another
+		// workspace does not make the peer a member of this one
+		if known != nil && known(pi.ID) && h.Network().Connectedness(pi.ID) == network.Connected {
+			continue
+		}
+		onFound(pi)
+	}
+}
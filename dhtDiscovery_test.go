@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TestDHTRendezvous starts n hosts on loopback, bootstraps them all off the first one
+// and checks that every node finds every other through the rendezvous
+func TestDHTRendezvous(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a private DHT for up to two minutes")
+	}
+	const n = 5
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	hosts := make([]host.Host, n)
+	for i := range hosts {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			t.Fatalf("host %d: %v", i, err)
+		}
+		defer h.Close()
+		hosts[i] = h
+	}
+
+	var mu sync.Mutex
+	seen := make([]map[peer.ID]bool, n)
+	bootstrap := []peer.AddrInfo{{ID: hosts[0].ID(), Addrs: hosts[0].Addrs()}}
+	group := fmt.Sprintf("dht-test-%d", time.Now().UnixNano())
+	for i, h := range hosts {
+		seen[i] = make(map[peer.ID]bool)
+		i := i
+		var cfg DHTConfig
+		if i > 0 {
+			cfg.Bootstrap = bootstrap
+		}
+		kad, err := startDHTDiscovery(ctx, h, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kad.Close()
+		startDHTRendezvous(ctx, h, kad, group, 2*time.Second, nil, func(pi peer.AddrInfo) {
+			mu.Lock()
+			seen[i][pi.ID] = true
+			mu.Unlock()
+		})
+	}
+
+	for {
+		mu.Lock()
+		done := true
+		total := 0
+		for i := range seen {
+			total += len(seen[i])
+			if len(seen[i]) < n-1 {
+				done = false
+			}
+		}
+		mu.Unlock()
+		if done {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out, %d of %d discoveries", total, n*(n-1))
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -9,11 +9,10 @@ import (
 	"io"
 )
 
-// ⚠️ You should replace this with a group-based secret key (32 bytes = 256 bits)
-var encryptionKey = []byte("this_is_a_demo_key_32_bytes_long!!") // 32 bytes
+// Keys are per workspace (Workspace.Key, 32 bytes derived from the group secret)
 
 // Compress data with gzip and encrypt it using AES-256-GCM
-func encryptAndCompress(input []byte) ([]byte, error) {
+func encryptAndCompress(key, input []byte) ([]byte, error) {
 	// Compress
 	var compressed bytes.Buffer
 	writer := gzip.NewWriter(&compressed)
@@ -27,7 +26,7 @@ func encryptAndCompress(input []byte) ([]byte, error) {
 	}
 
 	// Encrypt
-	block, err := aes.NewCipher(encryptionKey)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -47,8 +46,8 @@ func encryptAndCompress(input []byte) ([]byte, error) {
 }
 
 // Decrypt AES-256-GCM encrypted data and decompress it using gzip
-func decryptAndDecompress(input []byte) ([]byte, error) {
-	block, err := aes.NewCipher(encryptionKey)
+func decryptAndDecompress(key, input []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
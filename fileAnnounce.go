@@ -2,14 +2,12 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
-	"os"
-	"path/filepath"
-	"sync"
+	"strings"
+	"time"
 
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 /*
@@ -18,147 +16,187 @@ This file manages how peers:
     - broadcast what files/folders they are offering [UPDATED ]
     - listen for other peers' announcements [DONE]
     - keep an updated list of available files/folders across the network [UPDATED ]
+    - expire listings of peers that went away, show how fresh each listing is (peerLiveness.go) [DONE]
+    - one topic and one listing per workspace, the topic name only carries the workspace ID (workspace.go) [DONE]
+    - only accept signed announcements that pass the topic validator (announceValidation.go) [DONE]
+    - entries carry size, CID, mtime and ledger heads, big listings are fetched by digest (announceListing.go) [DONE]
+    - periodic announcements with a TTL, deltas when shared/ changes, requests from joining peers (announceSchedule.go) [DONE]
+    - paths excluded by .shareignore rules are never announced (shareIgnore.go) [DONE]
 */
 
-var (
-	fileTopic      *pubsub.Topic
-	fileSub        *pubsub.Subscription
-	knownFiles     = make(map[string][]string) // peerID → list of offered files/folders
-	knownFilesLock sync.Mutex
-)
-
 type FileAnnouncement struct {
-	PeerID   string   `json:"peer_id"`
-	FileList []string `json:"file_list"`
+	PeerID   string           `json:"peer_id"`
+	FileList []string         `json:"file_list"` // names only, all that nodes before rich listings read
+	Entries  []AnnouncedEntry `json:"entries,omitempty"`
+	Digest   string           `json:"digest,omitempty"` // listing digest, see announceListing.go
+	Total    int              `json:"total,omitempty"`  // entries in the full listing (Entries is empty above maxInlineEntries)
+	Kind     string           `json:"kind,omitempty"`   // announceFull (also when empty) or announceDelta, see announceSchedule.go
+	TTL      int              `json:"ttl,omitempty"`    // seconds receivers keep the listing without hearing from us again
+	Request  bool             `json:"request,omitempty"`
+	Base     string           `json:"base,omitempty"`    // delta: digest of the listing it applies to
+	Added    []AnnouncedEntry `json:"added,omitempty"`   // delta: new or changed entries
+	Removed  []string         `json:"removed,omitempty"` // delta: names that are gone
 }
 
-// Setup PubSub: Join topic and start listening
-func setupFilePubSub(ctx context.Context, ps *pubsub.PubSub, peerID string) error {
+// listing returns the announced entries, names only for announcements of older nodes
+func (ann FileAnnouncement) listing() []AnnouncedEntry {
+	if ann.Entries != nil {
+		return ann.Entries
+	}
+	entries := make([]AnnouncedEntry, len(ann.FileList))
+	for i, name := range ann.FileList {
+		entries[i] = AnnouncedEntry{Name: name, Type: entryFile}
+		if strings.HasSuffix(name, "/") {
+			entries[i].Type = entryDir
+		}
+	}
+	return entries
+}
+
+// Setup PubSub: Join the workspace's topic and start listening
+func setupFilePubSub(ctx context.Context, ps *pubsub.PubSub, ws *Workspace, peerID string) error {
 	var err error
-	fileTopic, err = ps.Join("file-presence")
+	ws.topic, err = ps.Join(ws.TopicName())
 	if err != nil {
 		log.Printf("[PubSub][setupFilePubSub] Failed to join topic: %v", err)
 		return err
 	}
-	log.Println("[PubSub][setupFilePubSub] Joined pubsub topic 'file-presence'")
+	log.Printf("[PubSub][setupFilePubSub] Joined pubsub topic '%s' for workspace %s", ws.TopicName(), ws.Name)
+	if err := announceGuard.register(ps, ws); err != nil {
+		log.Printf("[PubSub][setupFilePubSub] Failed to install announcement validator: %v", err)
+		return err
+	}
 
-	fileSub, err = fileTopic.Subscribe()
+	ws.sub, err = ws.topic.Subscribe()
 	if err != nil {
 		log.Printf("[PubSub][setupFilePubSub] Failed to subscribe: %v", err)
 		return err
 	}
 	log.Println("[PubSub][setupFilePubSub] Subscribed to file announcements")
 
-	go listenForAnnouncements(ctx, peerID)
+	go listenForAnnouncements(ctx, ws, peerID)
 	return nil
 }
 
-// Announce local files and folders in ./shared
-func announceLocalFiles(peerID string) {
-	var fileNames []string
-
-	// Recursively walk through 'shared' directory
-	err := filepath.Walk("shared", func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("[PubSub][announceLocalFiles] Walk error: %v", err)
-			return nil
-		}
-
-		// Skip root 'shared/' itself
-		if path == "shared" {
-			return nil
-		}
-
-		// Always store relative path
-		relPath, err := filepath.Rel("shared", path)
-		if err != nil {
-			log.Printf("[PubSub][announceLocalFiles] RelPath error: %v", err)
-			return nil
-		}
+// Announce local files and folders in the workspace's shared folder
+func announceLocalFiles(ws *Workspace) {
+	ws.announceLock.Lock()
+	defer ws.announceLock.Unlock()
+	publishFullListing(ws, false)
+}
 
-		if info.IsDir() {
-			// Optional: Also announce folders
-			fileNames = append(fileNames, relPath+"/") // Folder ends with slash
-		} else {
-			fileNames = append(fileNames, relPath)
-		}
-		return nil
-	})
+// publishFullListing rescans shared/ and publishes all of it; request asks others to answer in kind.
+// Callers hold ws.announceLock.
+func publishFullListing(ws *Workspace, request bool) {
+	entries, digest, err := buildLocalListing(ws)
 	if err != nil {
-		log.Printf("[PubSub][announceLocalFiles] Walk error: %v", err)
+		log.Printf("[PubSub][announceLocalFiles] %v", err)
 		return
 	}
+	sendFullListing(ws, entries, digest, request)
+}
 
+func sendFullListing(ws *Workspace, entries []AnnouncedEntry, digest string, request bool) {
 	msg := FileAnnouncement{
-		PeerID:   peerID,
-		FileList: fileNames,
+		PeerID:  node.ID().String(),
+		Kind:    announceFull,
+		Digest:  digest,
+		Total:   len(entries),
+		TTL:     int(announceTTL.Seconds()),
+		Request: request,
 	}
-
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("[PubSub][announceLocalFiles] ❌ JSON encode failed: %v", err)
+	// large listings only go out as a digest, peers fetch them over /listing/1.0.0
+	if len(entries) <= maxInlineEntries {
+		msg.Entries = entries
+		msg.FileList = entryNames(entries)
+	}
+	if !publishAnnouncement(ws, msg) {
 		return
 	}
+	ws.lastFullAt = time.Now()
 
-	if err := fileTopic.Publish(context.Background(), data); err != nil {
-		log.Printf("[PubSub][announceLocalFiles] Failed to publish: %v", err)
+	if msg.Entries == nil && len(entries) > 0 {
+		log.Printf("[Announce] Announced digest %s of %d files/folders in %s", shortID(digest), len(entries), ws.Name)
 		return
 	}
-
-	log.Printf("[Announce] Announced files/folders: %v", fileNames)
+	log.Printf("[Announce] Announced files/folders in %s: %v", ws.Name, msg.FileList)
 }
 
 // Listen for incoming file/folder announcements
-func listenForAnnouncements(ctx context.Context, selfID string) {
-	log.Println("[PubSub][listenForAnnouncements] Listening for file announcements...")
+func listenForAnnouncements(ctx context.Context, ws *Workspace, selfID string) {
+	log.Printf("[PubSub][listenForAnnouncements] Listening for file announcements in %s...", ws.Name)
 
 	for {
-		msg, err := fileSub.Next(ctx)
+		msg, err := ws.sub.Next(ctx)
 		if err != nil {
 			log.Printf("[PubSub][listenForAnnouncements] PubSub error: %v", err)
 			return
 		}
 
 		// Ignore our own announcements
-		if msg.ReceivedFrom.String() == selfID {
+		if msg.GetFrom().String() == selfID {
 			continue
 		}
 
-		var ann FileAnnouncement
-		if err := json.Unmarshal(msg.Data, &ann); err != nil {
-			log.Printf("[PubSub][listenForAnnouncements] Failed to parse announcement: %v", err)
+		// decoded and checked against the signer by the topic validator
+		ann, ok := msg.ValidatorData.(FileAnnouncement)
+		if !ok {
 			continue
 		}
 
 		log.Printf("[PubSub][listenForAnnouncements] Received announcement from %s", ann.PeerID)
+		liveness.Seen(ann.PeerID)
+		if !ws.authenticated(ann.PeerID) {
+			// knowing the topic is not membership: verify first, then take its listing over /listing
+			go admitAnnouncer(ws, msg.GetFrom(), ann)
+			continue
+		}
+		ws.notePeer(ann.PeerID, false)
 
-		knownFilesLock.Lock()
-		knownFiles[ann.PeerID] = ann.FileList
-		knownFilesLock.Unlock()
+		if ann.Request {
+			go answerRequest(ws, ann.PeerID)
+		}
+		ttl := time.Duration(ann.TTL) * time.Second
+
+		ws.filesLock.Lock()
+		unchanged := ann.Digest != "" && ws.filesDigest[ann.PeerID] == ann.Digest
+		if unchanged {
+			ws.filesAt[ann.PeerID] = time.Now()
+			ws.filesTTL[ann.PeerID] = ttl
+		}
+		ws.filesLock.Unlock()
+		if unchanged {
+			continue // periodic re-announcement, only refreshes the TTL
+		}
 
-		log.Printf("[Announce] Peer %s offers: %v", ann.PeerID, ann.FileList)
+		switch {
+		case ann.Kind == announceDelta:
+			applyDelta(ws, msg.GetFrom(), ann)
+		case ann.Entries == nil && ann.Total > 0:
+			log.Printf("[Announce] Peer %s has %d entries in %s, fetching its listing", ann.PeerID, ann.Total, ws.Name)
+			go fetchListing(ws, msg.GetFrom(), ann.Digest, ttl)
+			continue // fetchListing shows the files once it has them
+		default:
+			storeListing(ws, ann.PeerID, ann.listing(), ann.Digest, ttl)
+			log.Printf("[Announce] Peer %s offers in %s: %v", ann.PeerID, ws.Name, ann.FileList)
+		}
 
 		printLock.Lock()
-		showAvailableFiles()
+		showAvailableFiles(ws)
 		printLock.Unlock()
 	}
 }
 
-// Show available files/folders from all known peers
-func showAvailableFiles() {
-	fmt.Println("📂 Available Files/Folders:")
-	if len(knownFiles) == 0 {
-		fmt.Println("   (No announcements yet)")
+// admitAnnouncer verifies an announcer that is not a known member yet and then fetches its listing
+func admitAnnouncer(ws *Workspace, from peer.ID, ann FileAnnouncement) {
+	ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	defer cancel()
+	if err := ws.authenticate(ctx, from); err != nil {
+		log.Printf("[PubSub][listenForAnnouncements] Ignoring announcements of %s in %s: %v", shortID(from.String()), ws.Name, err)
 		return
 	}
-
-	for peerID, files := range knownFiles {
-		fmt.Printf("🧑 %s:\n", peerID)
-		if len(files) == 0 {
-			fmt.Println("   (No files listed by this peer)")
-		}
-		for _, f := range files {
-			fmt.Printf("   - %s\n", f)
-		}
+	if ann.Request {
+		go answerRequest(ws, ann.PeerID)
 	}
+	fetchListing(ws, from, ann.Digest, time.Duration(ann.TTL)*time.Second)
 }
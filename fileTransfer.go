@@ -14,6 +14,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -31,6 +32,15 @@ import (
 	- uses a progressbar to visually indicate transfer.
 	- verifies the SHA-256 hash to detect corruption.
 	- prints download stats and refreshes the file listing.
+3 content addressed requests ("cid:<sha256>") for history commands [DONE]
+	- handler serves the object store copy (any stored version), else a shared file with that hash
+	- fetchContentByCID streams the content into the object store and checks it against the CID
+	- chunks over maxChunkLen and paths over maxPathLen are refused, never allocated
+4 ref requests ("<file>@<tag|branch|version>") serve that version under the file's name [DONE]
+5 per workspace: protocol, shared/download folder, object store and key (see workspace.go) [DONE]
+6 paths excluded by .shareignore rules are never served, by path, folder or CID (see shareIgnore.go) [DONE]
+7 "label:path" requests for every shared root, refused when the root's encrypt or acl setting
+  does not allow the peer; received names are checked before anything is written (see roots.go) [DONE]
 
 
 -------------------------------------------------------------------------
@@ -98,13 +108,30 @@ Receiver:
 
 const chunkSize = 4096 // 4KB
 
+// Receivers refuse larger frames instead of allocating whatever length a peer sends.
+// Senders use chunkSize, encrypted chunks only add a few bytes of framing.
+const (
+	maxChunkLen = 1 << 20
+	maxPathLen  = 4096
+)
+
+// cidRequestPrefix marks a /file-transfer request for content by hash instead of by path
+const cidRequestPrefix = "cid:"
+
+// useEncryption asks senders to encrypt with the workspace key (-E)
 var useEncryption = false
 
-func sendSingleFile(s network.Stream, filePath string, peerWantsEncryption bool) error {
-	relPath, err := filepath.Rel("shared", filePath)
-	if err != nil {
-		return fmt.Errorf("[FileTransfer][sendSingleFile] Failed to calculate relative path: %v", err)
+func sendSingleFile(ws *Workspace, s network.Stream, filePath string, peerWantsEncryption bool) error {
+	relPath, _, ok := ws.nameOf(filePath)
+	if !ok {
+		return fmt.Errorf("[FileTransfer][sendSingleFile] %s is not inside a shared root", filePath)
 	}
+	return sendFileAs(ws, s, filePath, relPath, peerWantsEncryption)
+}
+
+// sendFileAs sends filePath announcing it under relPath (objects are sent under their CID)
+func sendFileAs(ws *Workspace, s network.Stream, filePath string, relPath string, peerWantsEncryption bool) error {
+	var err error
 
 	// ✨ First send the relative path
 	pathBytes := []byte(relPath)
@@ -148,7 +175,7 @@ func sendSingleFile(s network.Stream, filePath string, peerWantsEncryption bool)
 		hash.Write(data)
 
 		if peerWantsEncryption {
-			data, err = encryptAndCompress(data)
+			data, err = encryptAndCompress(ws.Key, data)
 			if err != nil {
 				return fmt.Errorf("[FileTransfer][sendSingleFile] Encryption failed: %v", err)
 			}
@@ -181,23 +208,26 @@ func sendSingleFile(s network.Stream, filePath string, peerWantsEncryption bool)
 	return nil
 }
 
-func sendFolderContents(s network.Stream, folderPath string, peerWantsEncryption bool) error {
+func sendFolderContents(ws *Workspace, s network.Stream, folderPath string, peerWantsEncryption bool) error {
 	return filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			log.Printf("[FileTransfer][sendFolderContents] Walk error: %v", err)
 			return nil
 		}
+		if ws.ignored(path, info.IsDir()) {
+			return skipIgnored(info.IsDir())
+		}
 
 		if info.IsDir() {
 			return nil // Skip folders themselves
 		}
 
 		log.Printf("[FileTransfer][sendFolderContents] Sending file inside folder: %s", path)
-		return sendSingleFile(s, path, peerWantsEncryption)
+		return sendSingleFile(ws, s, path, peerWantsEncryption)
 	})
 }
 
-func handleFileRequest(s network.Stream) {
+func handleFileRequest(ws *Workspace, s network.Stream) {
 	defer func(s network.Stream) {
 		if err := s.Close(); err != nil {
 			log.Printf("[FileTransfer][handleFileRequest]❌ Error closing stream: %v", err)
@@ -221,24 +251,79 @@ func handleFileRequest(s network.Stream) {
 	}
 	peerWantsEncryption := encFlag == 1
 	log.Printf("[FileTransfer][handleFileRequest] Peer requested %s transfer", encryptionStatus(peerWantsEncryption))
+	remotePeer := s.Conn().RemotePeer().String()
+
+	// Content addressed request ("cid:<sha256>") used by history commands
+	if strings.HasPrefix(requestedPath, cidRequestPrefix) {
+		cid := strings.TrimPrefix(requestedPath, cidRequestPrefix)
+		localPath, ok := findLocalFileByCID(ws, cid)
+		if !ok {
+			log.Printf("[FileTransfer][handleFileRequest] No local content for CID %s", cid)
+			return
+		}
+		if !ws.canServeCID(cid, localPath, remotePeer, peerWantsEncryption) {
+			log.Printf("[FileTransfer][handleFileRequest] Refusing CID %s to %s, the roots holding it do not allow it (%s)", shortID(cid), shortID(remotePeer), encryptionStatus(peerWantsEncryption))
+			return
+		}
+		if err := sendFileAs(ws, s, localPath, cid, peerWantsEncryption); err != nil {
+			log.Printf("[FileTransfer][handleFileRequest] Failed to send CID %s: %v", cid, err)
+		}
+		return
+	}
+
+	// "<file>@<tag|branch|version>": serve that version's content under the file's name
+	if file, ref, ok := splitFileRef(requestedPath); ok && !ws.ignoredName(filepath.ToSlash(file), false) {
+		if _, err := os.Stat(ws.sharedPath(requestedPath)); err != nil {
+			if root, _, known := ws.splitName(file); known && !root.allows(remotePeer, peerWantsEncryption) {
+				log.Printf("[FileTransfer][handleFileRequest] Refusing %s to %s, root %q does not allow it", requestedPath, shortID(remotePeer), root.Label)
+				return
+			}
+			_, v, err := resolveVersion(ws, file, ref)
+			if err != nil {
+				log.Printf("[FileTransfer][handleFileRequest] Cannot resolve %s: %v", requestedPath, err)
+				return
+			}
+			localPath, ok := findLocalFileByCID(ws, v.CID)
+			if !ok {
+				log.Printf("[FileTransfer][handleFileRequest] No local content for %s (CID %s)", requestedPath, shortID(v.CID))
+				return
+			}
+			if err := sendFileAs(ws, s, localPath, file, peerWantsEncryption); err != nil {
+				log.Printf("[FileTransfer][handleFileRequest] Failed to send %s: %v", requestedPath, err)
+			}
+			return
+		}
+	}
 
-	// Find and handle file or folder
-	rootPath := filepath.Join("shared", requestedPath)
+	// Find and handle file or folder ("label:path" for roots other than shared_dir, see roots.go)
+	rootPath, root, ok := ws.localPath(filepath.ToSlash(requestedPath))
+	if !ok {
+		log.Printf("[FileTransfer][handleFileRequest] Refusing %s, not a path inside a shared root", requestedPath)
+		return
+	}
+	if !root.allows(remotePeer, peerWantsEncryption) {
+		log.Printf("[FileTransfer][handleFileRequest] Refusing %s to %s, root %q does not allow it (%s)", requestedPath, shortID(remotePeer), root.Label, encryptionStatus(peerWantsEncryption))
+		return
+	}
 	info, err := os.Stat(rootPath)
 	if err != nil {
 		log.Printf("[FileTransfer][handleFileRequest] Requested item not found: %v", err)
 		return
 	}
+	if ws.ignored(rootPath, info.IsDir()) {
+		log.Printf("[FileTransfer][handleFileRequest] Refusing %s, it is excluded by the ignore rules", requestedPath)
+		return
+	}
 
 	if info.IsDir() {
 		log.Printf("[FileTransfer][handleFileRequest] Folder requested, sending contents recursively...")
-		err = sendFolderContents(s, rootPath, peerWantsEncryption)
+		err = sendFolderContents(ws, s, rootPath, peerWantsEncryption)
 		if err != nil {
 			log.Printf("[FileTransfer][handleFileRequest] ❌ Failed to send folder: %v", err)
 		}
 	} else {
 		log.Printf("[FileTransfer][handleFileRequest] Single file requested, sending...")
-		err = sendSingleFile(s, rootPath, peerWantsEncryption)
+		err = sendSingleFile(ws, s, rootPath, peerWantsEncryption)
 		if err != nil {
 			log.Printf("[FileTransfer][handleFileRequest] Failed to send file: %v", err)
 		}
@@ -246,7 +331,7 @@ func handleFileRequest(s network.Stream) {
 
 	log.Printf("[FileTransfer][handleFileRequest] Completed transfer for %s", requestedPath)
 	printLock.Lock()
-	showAvailableFiles()
+	showAvailableFiles(ws)
 	printLock.Unlock()
 }
 
@@ -254,7 +339,12 @@ func isStreamCancelError(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "canceled stream")
 }
 
-func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
+// isNotInWorkspaceError is what opening a workspace protocol on a peer outside the workspace gives
+func isNotInWorkspaceError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "protocols not supported")
+}
+
+func requestFileFromPeer(ws *Workspace, peerInfo peer.AddrInfo, fileName string) error {
 	log.Printf("[FileTransfer][requestFileFromPeer] Requesting '%s' from peer %s", fileName, peerInfo.ID)
 
 	log.Println("[FileTransfer][requestFileFromPeer] Connecting to peer...")
@@ -263,7 +353,7 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 	}
 	log.Println("[FileTransfer][requestFileFromPeer] Connected.")
 
-	stream, err := node.NewStream(context.Background(), peerInfo.ID, "/file-transfer/1.0.0")
+	stream, err := ws.newStream(transferStreamContext(context.Background(), node, peerInfo.ID), peerInfo.ID, ws.Protocol(fileTransferProtocol))
 	if err != nil {
 		return fmt.Errorf("[FileTransfer][requestFileFromPeer] Stream creation failed: %w", err)
 	}
@@ -283,9 +373,9 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 
 	reader := bufio.NewReader(stream)
 
-	saveDir := filepath.Join(".", "TransferredFiles")
+	saveDir := ws.DownloadDir
 	if err := os.MkdirAll(saveDir, os.ModePerm); err != nil {
-		return fmt.Errorf("[FileTransfer][requestFileFromPeer] Could not create TransferredFiles directory: %w", err)
+		return fmt.Errorf("[FileTransfer][requestFileFromPeer] Could not create %s: %w", saveDir, err)
 	}
 
 	log.Println("[FileTransfer][requestFileFromPeer] Receiving file(s)...")
@@ -323,6 +413,9 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 		if pathLen == 0 {
 			break // clean termination
 		}
+		if pathLen > maxPathLen {
+			return fmt.Errorf("[FileTransfer][requestFileFromPeer] ❌ Path of %d bytes refused", pathLen)
+		}
 
 		// 2️⃣ Read path bytes
 		pathBytes := make([]byte, pathLen)
@@ -332,7 +425,10 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 		relativePath := string(pathBytes)
 		log.Printf("[FileTransfer][requestFileFromPeer] Receiving: %s", relativePath)
 
-		outputPath := filepath.Join(saveDir, relativePath)
+		outputPath, err := downloadPath(saveDir, relativePath) // label:path lands in label/path (roots.go)
+		if err != nil {
+			return fmt.Errorf("[FileTransfer][requestFileFromPeer] %w", err)
+		}
 
 		// Create parent folders if needed
 		if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
@@ -361,6 +457,10 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 			if chunkLen == 0 {
 				break // end of the current file
 			}
+			if chunkLen > maxChunkLen {
+				_ = outputFile.Close()
+				return fmt.Errorf("[FileTransfer][requestFileFromPeer] Chunk of %d bytes refused (max %d)", chunkLen, maxChunkLen)
+			}
 
 			chunk := make([]byte, chunkLen)
 			if _, err := io.ReadFull(reader, chunk); err != nil {
@@ -372,7 +472,7 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 			}
 
 			if useEncryption {
-				chunk, err = decryptAndDecompress(chunk)
+				chunk, err = decryptAndDecompress(ws.Key, chunk)
 				if err != nil {
 					err := outputFile.Close()
 					if err != nil {
@@ -412,6 +512,11 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 		}
 
 		log.Printf("[FileTransfer][requestFileFromPeer] File '%s' verified", relativePath)
+
+		// keep the fetched version so it can be restored even after the sender changes it
+		if _, err := ws.Objects.PutFile(outputPath); err != nil {
+			log.Printf("[FileTransfer][requestFileFromPeer] Could not keep '%s' in object store: %v", relativePath, err)
+		}
 	}
 
 	elapsed := time.Since(startTime)
@@ -428,6 +533,160 @@ func requestFileFromPeer(peerInfo peer.AddrInfo, fileName string) error {
 	return nil
 }
 
+// findLocalFileByCID returns the workspace's object store copy of cid, or a file of a shared root whose content hashes to cid
+func findLocalFileByCID(ws *Workspace, cid string) (string, bool) {
+	if ws.Objects.Has(cid) {
+		path, _ := ws.Objects.Path(cid)
+		return path, true
+	}
+	if p, ok := ws.cachedPathOfCID(cid); ok {
+		return p, true
+	}
+	found := ""
+	_ = ws.walkShared(func(_ *SharedRoot, path, _ string, info os.FileInfo) error {
+		if found != "" || info.IsDir() {
+			return nil // ignored files are never walked, so never served by hash either
+		}
+		if sum, err := ws.cachedFileCID(path, info); err == nil && sum == cid {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found, found != ""
+}
+
+// cachedPathOfCID looks cid up in the CID cache, a hit counts while the file's size and mtime still match
+func (ws *Workspace) cachedPathOfCID(cid string) (string, bool) {
+	ws.listingLock.Lock()
+	var candidates []string
+	for p, c := range ws.cids {
+		if c.cid == cid {
+			candidates = append(candidates, p)
+		}
+	}
+	ws.listingLock.Unlock()
+
+	sort.Strings(candidates)
+	for _, p := range candidates {
+		info, err := os.Stat(p)
+		if err != nil || info.IsDir() || ws.ignored(p, false) {
+			continue
+		}
+		if _, _, ok := ws.nameOf(p); !ok {
+			continue
+		}
+		if sum, err := ws.cachedFileCID(p, info); err == nil && sum == cid {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// fetchContentByCID asks the workspace's members (preferred one first) for content by hash and
+// returns its object store path. The received bytes are checked against the CID so any member can serve it.
+func fetchContentByCID(ws *Workspace, cid string, preferredPeer string) (string, error) {
+	if !validCID(cid) {
+		return "", fmt.Errorf("[FileTransfer][fetchContentByCID] %q is not a valid CID", cid)
+	}
+	knownPeersLock.Lock()
+	candidates := make([]peer.AddrInfo, 0, len(knownPeers))
+	if pi, ok := knownPeers[preferredPeer]; ok && ws.isMember(preferredPeer) {
+		candidates = append(candidates, pi)
+	}
+	for id, pi := range knownPeers {
+		if id != preferredPeer && ws.isMember(id) {
+			candidates = append(candidates, pi)
+		}
+	}
+	knownPeersLock.Unlock()
+
+	for _, pi := range candidates {
+		if err := fetchContentFromPeer(ws, pi, cid); err != nil {
+			log.Printf("[FileTransfer][fetchContentByCID] Peer %s could not serve %s: %v", pi.ID, shortID(cid), err)
+			continue
+		}
+		return ws.Objects.Path(cid)
+	}
+	return "", fmt.Errorf("[FileTransfer][fetchContentByCID] no member of %s has content %s", ws.Name, cid)
+}
+
+// fetchContentFromPeer streams one CID from the peer into the object store, chunk by chunk
+func fetchContentFromPeer(ws *Workspace, peerInfo peer.AddrInfo, cid string) error {
+	if err := node.Connect(context.Background(), peerInfo); err != nil {
+		return fmt.Errorf("[FileTransfer][fetchContentFromPeer] Connect failed: %w", err)
+	}
+	stream, err := ws.newStream(transferStreamContext(context.Background(), node, peerInfo.ID), peerInfo.ID, ws.Protocol(fileTransferProtocol))
+	if err != nil {
+		return fmt.Errorf("[FileTransfer][fetchContentFromPeer] Stream creation failed: %w", err)
+	}
+	defer func() {
+		if cerr := stream.Close(); cerr != nil && !isStreamCancelError(cerr) {
+			log.Printf("[FileTransfer][fetchContentFromPeer] Error closing stream: %v", cerr)
+		}
+	}()
+
+	if _, err := stream.Write([]byte(cidRequestPrefix + cid + "\n")); err != nil {
+		return fmt.Errorf("[FileTransfer][fetchContentFromPeer] Failed to send CID: %w", err)
+	}
+	if _, err := stream.Write([]byte{boolToByte(useEncryption)}); err != nil {
+		return fmt.Errorf("[FileTransfer][fetchContentFromPeer] Failed to send encryption flag: %w", err)
+	}
+
+	reader := bufio.NewReader(stream)
+
+	// Same framing as requestFileFromPeer, for exactly one file
+	pathLenBuf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, pathLenBuf); err != nil {
+		return fmt.Errorf("[FileTransfer][fetchContentFromPeer] peer has no content for CID: %w", err)
+	}
+	if _, err := io.CopyN(io.Discard, reader, int64(binary.BigEndian.Uint32(pathLenBuf))); err != nil {
+		return fmt.Errorf("[FileTransfer][fetchContentFromPeer] Path read error: %w", err)
+	}
+
+	err = ws.Objects.PutStream(cid, func(w io.Writer) error {
+		lenBuf := make([]byte, 4)
+		chunk := make([]byte, 0, chunkSize)
+		for {
+			if _, err := io.ReadFull(reader, lenBuf); err != nil {
+				return fmt.Errorf("chunk length read error: %w", err)
+			}
+			chunkLen := binary.BigEndian.Uint32(lenBuf)
+			if chunkLen == 0 {
+				break
+			}
+			if chunkLen > maxChunkLen {
+				return fmt.Errorf("chunk of %d bytes refused (max %d)", chunkLen, maxChunkLen)
+			}
+			if uint32(cap(chunk)) < chunkLen {
+				chunk = make([]byte, chunkLen)
+			}
+			chunk = chunk[:chunkLen]
+			if _, err := io.ReadFull(reader, chunk); err != nil {
+				return fmt.Errorf("chunk read error: %w", err)
+			}
+			data := chunk
+			if useEncryption {
+				if data, err = decryptAndDecompress(ws.Key, chunk); err != nil {
+					return fmt.Errorf("decryption failed: %w", err)
+				}
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		expectedHash := make([]byte, 32)
+		if _, err := io.ReadFull(reader, expectedHash); err != nil {
+			return fmt.Errorf("final hash read error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[FileTransfer][fetchContentFromPeer] %w", err)
+	}
+	return nil
+}
+
 func encryptionStatus(enabled bool) string {
 	if enabled {
 		return "encrypted"
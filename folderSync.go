@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Files used to flow one way, into TransferredFiles/, and never came back as versions
+
+1. A workspace with "sync": true (or the -sync flag) keeps its shared folder in two-way
+   sync: every member converges on the same files [DONE]
+2. Local edits, new files and deletions in shared/ become ledger versions (deletions
+   as tombstones, see CRDT.go) [DONE]
+3. Remote versions are applied into shared/: winning head fetched by CID, tombstones
+   remove the file [DONE]
+4. Renames are a tombstone on the old name plus the same CID on the new one; peers
+   move the content they already hold instead of downloading it again [DONE]
+5. Concurrent changes fork the version DAG; the same head wins on every peer, the other
+   heads land next to it as <name>.sync-conflict-<cid><ext> [DONE]
+6. A peer whose listing changed is asked for its ledger (/hello) on the next pass [DONE]
+7. sync / sync now in the CLI [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                          # one pass (runFolderSync)
+
+ 1. record   walk shared/, compare with the state file:
+             changed CID            → version, parent = the version we last had on disk
+             gone                   → tombstone, parent = that version
+             gone + same CID at a new name → rename (both of the above)
+ 2. pull     /hello with peers whose announced listing changed since the last pass
+ 3. apply    for every file in the ledger take the winning head (syncWinner):
+             tombstone   → remove our copy
+             other CID   → fetch (object store, shared/, then members) and replace
+             other heads → conflict copy, once per version
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- The state file (.peerlink/sync-state.json) remembers, per file, the CID and version on
+  disk after the last pass. That is how a deletion is told apart from a file we never had
+- Parents are the version we last had, not the current heads: an edit made while a
+  remote change was on its way forks instead of silently overwriting it
+- A local change is recorded before anything is applied, and a file whose content
+  differs from the state file is never replaced, so unrecorded edits are not lost
+- Edit vs delete: an edit always beats a concurrent delete, the file comes back everywhere.
+  Among edits the LWW head wins (LatestHead)
+- Conflict copies stay local (every peer writes the same ones), they are never recorded
+- Ignored paths (shareIgnore.go) are neither recorded nor written
+- Every shared root is synced under its label:path names (roots.go); ro roots are recorded
+  but never written, names of roots this node does not have are skipped
+- Disjoint edits never fork: two nodes editing different files end up identical
+  (folderSync_test.go runs two nodes over loopback)
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	syncStateFile    = ".peerlink/sync-state.json"
+	folderSyncPeriod = 15 * time.Second
+	conflictMarker   = ".sync-conflict-"
+)
+
+// syncedFile is what shared/ held for a name after the last pass
+type syncedFile struct {
+	CID     string `json:"cid"`
+	Version string `json:"version"`
+}
+
+type syncState struct {
+	Files  map[string]syncedFile `json:"files"`
+	Copies []string              `json:"conflict_copies,omitempty"` // versions already written as conflict copies
+}
+
+type FolderSync struct {
+	pass sync.Mutex // one pass at a time
+
+	mu      sync.Mutex
+	path    string
+	files   map[string]syncedFile
+	copies  map[string]bool
+	failed  map[string]string // name → CID that could not be fetched, logged once
+	changed map[string]bool   // peers whose listing changed since the last pull
+	kick    chan struct{}
+}
+
+func loadFolderSync(path string) (*FolderSync, error) {
+	fs := &FolderSync{
+		path:    path,
+		files:   make(map[string]syncedFile),
+		copies:  make(map[string]bool),
+		failed:  make(map[string]string),
+		changed: make(map[string]bool),
+		kick:    make(chan struct{}, 1),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[FolderSync][loadFolderSync] %w", err)
+	}
+	var st syncState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("[FolderSync][loadFolderSync] bad %s: %w", path, err)
+	}
+	for name, f := range st.Files {
+		fs.files[name] = f
+	}
+	for _, id := range st.Copies {
+		fs.copies[id] = true
+	}
+	return fs, nil
+}
+
+func (fs *FolderSync) saveLocked() error {
+	st := syncState{Files: fs.files}
+	for id := range fs.copies {
+		st.Copies = append(st.Copies, id)
+	}
+	sort.Strings(st.Copies)
+	if err := os.MkdirAll(filepath.Dir(fs.path), os.ModePerm); err != nil {
+		return fmt.Errorf("[FolderSync][save] %w", err)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[FolderSync][save] %w", err)
+	}
+	if err := writeFileAtomic(fs.path, data); err != nil {
+		return fmt.Errorf("[FolderSync][save] %w", err)
+	}
+	return nil
+}
+
+// Kick asks for a pass soon
+func (fs *FolderSync) Kick() {
+	select {
+	case fs.kick <- struct{}{}:
+	default:
+	}
+}
+
+// ListingChanged marks a peer whose ledger we should pull on the next pass
+func (fs *FolderSync) ListingChanged(peerID string) {
+	fs.mu.Lock()
+	fs.changed[peerID] = true
+	fs.mu.Unlock()
+	fs.Kick()
+}
+
+func isConflictCopy(name string) bool {
+	return strings.Contains(path.Base(name), conflictMarker)
+}
+
+func conflictCopyName(name, cid string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + conflictMarker + shortID(cid) + ext
+}
+
+// startFolderSync runs passes on ledger changes, changed listings and every folderSyncPeriod
+func startFolderSync(ctx context.Context, ws *Workspace) {
+	if !ws.Sync {
+		return
+	}
+	log.Printf("[FolderSync] Keeping %s in two-way sync with workspace %s", ws.SharedDir, ws.Name)
+	changes, cancel := ws.Store.Subscribe(16)
+	go func() {
+		defer cancel()
+		tick := time.NewTicker(folderSyncPeriod)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+			case <-ws.Syncer.kick:
+			case <-tick.C:
+			}
+			runFolderSync(ws)
+		}
+	}()
+	ws.Syncer.Kick()
+}
+
+func runFolderSync(ws *Workspace) {
+	fs := ws.Syncer
+	fs.pass.Lock()
+	defer fs.pass.Unlock()
+
+	if err := recordLocalChanges(ws); err != nil {
+		log.Printf("[FolderSync][run] %v", err)
+	}
+	pullChangedLedgers(ws)
+	applyLedger(ws)
+
+	fs.mu.Lock()
+	if err := fs.saveLocked(); err != nil {
+		log.Printf("[FolderSync][run] %v", err)
+	}
+	fs.mu.Unlock()
+}
+
+// localFiles hashes every file of the shared roots except conflict copies and half written temp files
+func localFiles(ws *Workspace) map[string]string {
+	disk := make(map[string]string)
+	_ = ws.walkShared(func(_ *SharedRoot, p, name string, info os.FileInfo) error {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".peerlink-") || isConflictCopy(name) {
+			return nil
+		}
+		cid, err := ws.cachedFileCID(p, info)
+		if err != nil {
+			log.Printf("[FolderSync][localFiles] Skipping '%s': %v", name, err)
+			return nil
+		}
+		disk[name] = cid
+		return nil
+	})
+	return disk
+}
+
+// recordLocalChanges turns edits, new files, deletions and renames in shared/ into versions
+func recordLocalChanges(ws *Workspace) error {
+	fs := ws.Syncer
+	disk := localFiles(ws)
+	fs.mu.Lock()
+	state := make(map[string]syncedFile, len(fs.files))
+	for name, f := range fs.files {
+		state[name] = f
+	}
+	fs.mu.Unlock()
+
+	var written []string
+	var gone []string
+	for name, cid := range disk {
+		if f, ok := state[name]; !ok || f.CID != cid {
+			written = append(written, name)
+		}
+	}
+	var unshared []string
+	for name := range state {
+		if _, ok := disk[name]; ok {
+			continue
+		}
+		if ws.ignoredName(name, false) {
+			unshared = append(unshared, name) // newly ignored: stop syncing it, peers keep their copy
+			continue
+		}
+		gone = append(gone, name)
+	}
+	fs.mu.Lock()
+	for _, name := range unshared {
+		delete(fs.files, name)
+	}
+	fs.mu.Unlock()
+	if len(written)+len(gone) == 0 {
+		return nil
+	}
+	sort.Strings(written)
+	sort.Strings(gone)
+
+	// a vanished file whose content reappeared under a new name was renamed
+	renamedTo := make(map[string]string)
+	renamedFrom := make(map[string]string)
+	for _, old := range gone {
+		for _, name := range written {
+			if _, taken := renamedFrom[name]; !taken && disk[name] == state[old].CID {
+				if _, tracked := state[name]; !tracked {
+					renamedTo[old], renamedFrom[name] = name, old
+					break
+				}
+			}
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	self := node.ID().String()
+	next := make(map[string]syncedFile)
+	var dropped []string
+
+	_, err := ws.Store.Update("sync", func(tx *MetadataTxn) error {
+		for _, name := range written {
+			cid := disk[name]
+			meta, tracked := tx.Get(name)
+			head, hasHead := syncWinner(meta)
+			f, known := state[name]
+			if hasHead && !head.Deleted && head.CID == cid {
+				next[name] = syncedFile{CID: cid, Version: head.VersionID} // already recorded (startup scan, or the same change remotely)
+				continue
+			}
+			if _, err := ws.Objects.PutFile(ws.sharedPath(name)); err != nil {
+				log.Printf("[FolderSync][record] Skipping '%s': %v", name, err)
+				continue
+			}
+
+			var parents []string
+			_, based := meta.Versions[f.Version]
+			switch {
+			case known && based:
+				parents = []string{f.Version}
+			case hasHead && head.Deleted:
+				parents = meta.Heads // re-created after a delete
+			}
+			message := "local edit on " + hostname
+			switch {
+			case renamedFrom[name] != "":
+				message = "renamed from " + renamedFrom[name] + " on " + hostname
+			case !tracked:
+				message = "added on " + hostname
+			}
+			if !tracked {
+				meta = FileMetadata{FileName: name, Versions: make(map[string]FileVersion), Heads: []string{}}
+			}
+			v := NewFileVersion(self, message, cid, parents)
+			meta.AddVersion(v)
+			tx.Put(meta)
+			next[name] = syncedFile{CID: cid, Version: v.VersionID}
+			log.Printf("[FolderSync][record] '%s': %s (%s)", name, message, shortID(cid))
+		}
+
+		for _, name := range gone {
+			f := state[name]
+			dropped = append(dropped, name)
+			meta, tracked := tx.Get(name)
+			head, hasHead := syncWinner(meta)
+			if !tracked || !hasHead || head.Deleted {
+				continue
+			}
+			if head.VersionID != f.Version && head.CID != f.CID {
+				// changed remotely while we deleted it: the edit wins, apply brings it back
+				log.Printf("[FolderSync][record] '%s' was deleted here but changed remotely, keeping the remote version", name)
+				continue
+			}
+			parents := []string{f.Version}
+			if _, based := meta.Versions[f.Version]; !based {
+				parents = meta.Heads // our base was squashed by a checkpoint
+			}
+			message := "deleted on " + hostname
+			if renamedTo[name] != "" {
+				message = "renamed to " + renamedTo[name] + " on " + hostname
+			}
+			meta.AddVersion(NewDeletion(self, message, parents))
+			tx.Put(meta)
+			log.Printf("[FolderSync][record] '%s': %s", name, message)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[FolderSync][record] %w", err)
+	}
+
+	fs.mu.Lock()
+	for _, name := range dropped {
+		delete(fs.files, name)
+	}
+	for name, f := range next {
+		fs.files[name] = f
+	}
+	fs.mu.Unlock()
+	return nil
+}
+
+// pullChangedLedgers runs /hello with every member whose listing changed since the last pass
+func pullChangedLedgers(ws *Workspace) {
+	fs := ws.Syncer
+	fs.mu.Lock()
+	peers := make([]string, 0, len(fs.changed))
+	for id := range fs.changed {
+		peers = append(peers, id)
+	}
+	fs.changed = make(map[string]bool)
+	fs.mu.Unlock()
+
+	for _, id := range peers {
+		pid, err := peer.Decode(id)
+		if err != nil || !ws.isMember(id) {
+			continue
+		}
+		knownPeersLock.Lock()
+		pi, ok := knownPeers[id]
+		knownPeersLock.Unlock()
+		if !ok {
+			pi = peer.AddrInfo{ID: pid} // reachable through the pubsub connection
+		}
+		runSourceNode(ws, pi, "")
+	}
+}
+
+// applyLedger brings the read-write roots to the winning head of every file in the ledger
+func applyLedger(ws *Workspace) {
+	fs := ws.Syncer
+	disk := localFiles(ws)
+	live := make(map[string]bool)
+
+	for name, meta := range ws.Store.Snapshot() {
+		if isSnapshotKey(name) || isConflictCopy(name) || ws.ignoredName(name, false) {
+			continue
+		}
+		target, root, ok := ws.localPath(name)
+		if !ok || root.ReadOnly() {
+			continue // a root we do not have, or one we only publish (roots.go)
+		}
+		head, ok := syncWinner(meta)
+		if !ok || head.Tree != nil {
+			continue
+		}
+		for _, id := range meta.Heads {
+			live[id] = true
+		}
+
+		fs.mu.Lock()
+		f, known := fs.files[name]
+		fs.mu.Unlock()
+		cid, exists := disk[name]
+		if exists && (!known || cid != f.CID) && cid != head.CID {
+			continue // local change the next record step picks up
+		}
+
+		switch {
+		case head.Deleted && exists:
+			if err := os.Remove(target); err != nil {
+				log.Printf("[FolderSync][apply] Cannot remove '%s': %v", name, err)
+				continue
+			}
+			removeEmptyParents(root.Path, filepath.Dir(target))
+			log.Printf("[FolderSync][apply] '%s' removed (%s)", name, head.Message)
+			fallthrough
+		case head.Deleted:
+			fs.mu.Lock()
+			delete(fs.files, name)
+			fs.mu.Unlock()
+		case !exists || cid != head.CID:
+			if !writeVersion(ws, name, target, head) {
+				continue
+			}
+			log.Printf("[FolderSync][apply] '%s' updated to %s by %s", name, shortID(head.VersionID), shortID(head.Author))
+			fallthrough
+		default:
+			fs.mu.Lock()
+			fs.files[name] = syncedFile{CID: head.CID, Version: head.VersionID}
+			fs.mu.Unlock()
+		}
+
+		writeConflictCopies(ws, name, meta, head)
+	}
+
+	fs.mu.Lock()
+	for id := range fs.copies {
+		if !live[id] {
+			delete(fs.copies, id) // fork resolved, a new one gets new copies
+		}
+	}
+	fs.mu.Unlock()
+}
+
+// syncWinner is the head every peer applies: the newest edit, a tombstone only if all heads are
+func syncWinner(meta FileMetadata) (FileVersion, bool) {
+	edits := FileMetadata{Versions: meta.Versions}
+	for _, id := range meta.Heads {
+		if !meta.Versions[id].Deleted {
+			edits.Heads = append(edits.Heads, id)
+		}
+	}
+	if head, ok := LatestHead(edits); ok {
+		return head, true
+	}
+	return LatestHead(meta)
+}
+
+// writeVersion puts v's content at target, from local copies if possible, else from members
+func writeVersion(ws *Workspace, name, target string, v FileVersion) bool {
+	src, ok := findLocalFileByCID(ws, v.CID)
+	var err error
+	if !ok {
+		src, err = fetchContentByCID(ws, v.CID, v.Author)
+	}
+	fs := ws.Syncer
+	if err != nil {
+		fs.mu.Lock()
+		if fs.failed[name] != v.CID {
+			log.Printf("[FolderSync][apply] Cannot get '%s' (%s) yet: %v", name, shortID(v.CID), err)
+		}
+		fs.failed[name] = v.CID
+		fs.mu.Unlock()
+		return false
+	}
+	if err := copyFileAtomic(target, src); err != nil {
+		log.Printf("[FolderSync][apply] %v", err)
+		return false
+	}
+	fs.mu.Lock()
+	delete(fs.failed, name)
+	fs.mu.Unlock()
+	return true
+}
+
+// writeConflictCopies puts the content of every losing head next to the file, once per version
+func writeConflictCopies(ws *Workspace, name string, meta FileMetadata, winner FileVersion) {
+	fs := ws.Syncer
+	for _, id := range meta.Heads {
+		v := meta.Versions[id]
+		if id == winner.VersionID || v.Deleted || v.Tree != nil || v.CID == winner.CID {
+			continue
+		}
+		fs.mu.Lock()
+		done := fs.copies[id]
+		fs.mu.Unlock()
+		if done {
+			continue
+		}
+		copyName := conflictCopyName(name, v.CID)
+		target := ws.sharedPath(copyName)
+		if _, err := os.Stat(target); err == nil || writeVersion(ws, copyName, target, v) {
+			fs.mu.Lock()
+			fs.copies[id] = true
+			fs.mu.Unlock()
+			log.Printf("[FolderSync][apply] ⚠️ '%s' was changed concurrently by %s, their version is in '%s'", name, shortID(v.Author), copyName)
+		}
+	}
+}
+
+// removeEmptyParents removes dir and its parents up to (not including) root while they are empty
+func removeEmptyParents(root, dir string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return // not empty
+		}
+	}
+}
+
+func cmdSyncStatus(ws *Workspace) error {
+	if !ws.Sync {
+		fmt.Printf("🔄 %s is not synced, set \"sync\": true in %s or start with -sync\n", ws.Name, defaultWorkspacesPath)
+		return nil
+	}
+	fs := ws.Syncer
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fmt.Printf("🔄 %s ⇄ workspace %s: %d file(s) in sync, %d conflict copies written\n", ws.SharedDir, ws.Name, len(fs.files), len(fs.copies))
+	names := make([]string, 0, len(fs.failed))
+	for name := range fs.failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("   ⏳ %s waiting for content %s\n", name, shortID(fs.failed[name]))
+	}
+	return nil
+}
+
+func cmdSyncNow(ws *Workspace) error {
+	if !ws.Sync {
+		return fmt.Errorf("[FolderSync][cmdSyncNow] %s is not synced (see 'sync')", ws.Name)
+	}
+	for _, id := range ws.Members() {
+		ws.Syncer.ListingChanged(id)
+	}
+	runFolderSync(ws)
+	return cmdSyncStatus(ws)
+}
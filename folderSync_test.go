@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// syncTestNode is one member of the two node folder sync test
+type syncTestNode struct {
+	h  host.Host
+	ws *Workspace
+}
+
+func newSyncTestNode(t *testing.T, name, sharedDir string) *syncTestNode {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	t.Cleanup(func() { h.Close() })
+	ws, err := openWorkspace(WorkspaceConfig{Name: name, Secret: "folder-sync-test", SharedDir: sharedDir, Sync: true}, "json", RetentionPolicy{})
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	node = h // registerListingHandler registers on the global node
+	runTargetNode(h, ws)
+	knownPeersLock.Lock()
+	knownPeers[h.ID().String()] = *host.InfoFromHost(h)
+	knownPeersLock.Unlock()
+	return &syncTestNode{h: h, ws: ws}
+}
+
+// pass runs one folder sync pass of n after the other node's listing changed
+func (n *syncTestNode) pass(other *syncTestNode) {
+	node = n.h
+	n.ws.Syncer.ListingChanged(other.h.ID().String())
+	runFolderSync(n.ws)
+}
+
+func writeTestFile(t *testing.T, root, rel, content string) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// folderContents maps every file under root to its content
+func folderContents(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestFolderSyncDisjointEdits has two nodes add, edit and delete different files and
+// checks that both shared folders end up identical, without forks or conflict copies
+func TestFolderSyncDisjointEdits(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil { // .peerlink/ of both workspaces
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	knownPeersLock.Lock()
+	saved := knownPeers
+	knownPeers = make(map[string]peer.AddrInfo)
+	knownPeersLock.Unlock()
+	savedNode := node
+	t.Cleanup(func() {
+		knownPeersLock.Lock()
+		knownPeers = saved
+		knownPeersLock.Unlock()
+		node = savedNode
+	})
+
+	alice := newSyncTestNode(t, "alice", filepath.Join(dir, "alice"))
+	bob := newSyncTestNode(t, "bob", filepath.Join(dir, "bob"))
+
+	// both prove membership once and count as synced, so neither side syncs back on its own
+	node = alice.h
+	if err := alice.h.Connect(context.Background(), *host.InfoFromHost(bob.h)); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.ws.authenticate(context.Background(), bob.h.ID()); err != nil {
+		t.Fatal(err)
+	}
+	alice.ws.notePeer(bob.h.ID().String(), true)
+	bob.ws.notePeer(alice.h.ID().String(), true)
+
+	converge := func(step string) {
+		t.Helper()
+		alice.pass(bob)
+		bob.pass(alice)
+		alice.pass(bob)
+
+		a, b := folderContents(t, alice.ws.SharedDir), folderContents(t, bob.ws.SharedDir)
+		if !reflect.DeepEqual(a, b) {
+			t.Fatalf("%s: folders differ\nalice: %v\nbob:   %v", step, a, b)
+		}
+		if ledgerDigest(alice.ws.Store.Snapshot()) != ledgerDigest(bob.ws.Store.Snapshot()) {
+			t.Fatalf("%s: ledgers differ", step)
+		}
+		for name, meta := range alice.ws.Store.Snapshot() {
+			if len(meta.Heads) != 1 {
+				t.Errorf("%s: %s forked into %d heads", step, name, len(meta.Heads))
+			}
+		}
+		for name := range a {
+			if isConflictCopy(name) {
+				t.Errorf("%s: unexpected conflict copy %s", step, name)
+			}
+		}
+	}
+
+	writeTestFile(t, alice.ws.SharedDir, "notes.txt", "alice's notes")
+	writeTestFile(t, alice.ws.SharedDir, "docs/plan.md", "# plan")
+	writeTestFile(t, bob.ws.SharedDir, "todo.txt", "bob's todo")
+	writeTestFile(t, bob.ws.SharedDir, "img/logo.svg", "<svg/>")
+	converge("add")
+	if got := folderContents(t, bob.ws.SharedDir); got["notes.txt"] != "alice's notes" || len(got) != 4 {
+		t.Fatalf("add: bob has %v", got)
+	}
+
+	writeTestFile(t, alice.ws.SharedDir, "notes.txt", "alice's notes, edited")
+	if err := os.Remove(filepath.Join(alice.ws.SharedDir, "docs", "plan.md")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, bob.ws.SharedDir, "todo.txt", "bob's todo, edited")
+	if err := os.Rename(filepath.Join(bob.ws.SharedDir, "img", "logo.svg"), filepath.Join(bob.ws.SharedDir, "logo.svg")); err != nil {
+		t.Fatal(err)
+	}
+	converge("edit, delete and rename")
+
+	want := map[string]string{
+		"notes.txt": "alice's notes, edited",
+		"todo.txt":  "bob's todo, edited",
+		"logo.svg":  "<svg/>",
+	}
+	if got := folderContents(t, alice.ws.SharedDir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("alice has %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Find "the doc mentioning the Q3 budget" without knowing its name
+
+1. Inverted index (term → file → count) over the text files of the workspace's shared
+   folder: plain text, markdown, source code, configs [DONE]
+2. Kept up to date from buildLocalListing, so every rescan of the change watcher
+   (announceSchedule.go) re-indexes exactly the files whose CID changed [DONE]
+3. Remote full-text queries (SearchQuery.Text) are answered with a score and a
+   snippet per file, members of the workspace only [DONE]
+4. find <words> / search text:<word> in the CLI [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- All words must occur (AND), score is Σ tf·log(1+N/df) over the query words
+- Only postings live in memory; snippets are cut from the file on disk when a query
+  matches it
+- Access: there are no per-file ACLs in this tree, the boundary is the workspace.
+  The index only covers that workspace's shared folder, queries travel on its own
+  topic, and text queries from peers that are not known members are not answered
+- Files over maxIndexedFileSize, or with NUL bytes / invalid UTF-8, are skipped
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	maxIndexedFileSize = 2 << 20
+	maxSnippetLen      = 160
+	minTermLen         = 2
+)
+
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".rst": true, ".tex": true, ".log": true, ".csv": true,
+	".go": true, ".py": true, ".js": true, ".ts": true, ".java": true, ".c": true, ".h": true, ".cpp": true,
+	".hpp": true, ".rs": true, ".rb": true, ".sh": true, ".sql": true, ".html": true, ".css": true,
+	".json": true, ".yaml": true, ".yml": true, ".toml": true, ".xml": true, ".ini": true, ".cfg": true, ".conf": true,
+}
+
+type indexedDoc struct {
+	cid   string
+	terms []string // distinct terms, to drop its postings again
+}
+
+// TextIndex is one workspace's full-text index, keyed by ledger-style relative names
+type TextIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]indexedDoc
+	postings map[string]map[string]int // term → name → occurrences
+}
+
+// TextHit is what a peer returns for one file matching a text query
+type TextHit struct {
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet,omitempty"`
+}
+
+func NewTextIndex() *TextIndex {
+	return &TextIndex{docs: make(map[string]indexedDoc), postings: make(map[string]map[string]int)}
+}
+
+func isIndexable(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return textExtensions[ext] || ext == ""
+}
+
+// Update re-indexes the files of a fresh listing whose CID changed and drops vanished ones;
+// locate maps a listed name to the file on disk
+func (ix *TextIndex) Update(locate func(name string) string, entries []AnnouncedEntry) {
+	live := make(map[string]bool)
+	for _, e := range entries {
+		if e.Type != entryFile || e.Size > maxIndexedFileSize || !isIndexable(e.Name) {
+			continue
+		}
+		live[e.Name] = true
+		ix.mu.RLock()
+		doc, ok := ix.docs[e.Name]
+		ix.mu.RUnlock()
+		if ok && doc.cid == e.CID {
+			continue
+		}
+		data, err := os.ReadFile(locate(e.Name))
+		if err != nil {
+			log.Printf("[FullText][Update] Cannot read '%s': %v", e.Name, err)
+			continue
+		}
+		if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+			ix.replace(e.Name, indexedDoc{cid: e.CID}, nil) // binary: remember the CID so it is not re-read
+			continue
+		}
+		ix.replace(e.Name, indexedDoc{cid: e.CID}, countTerms(string(data)))
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for name := range ix.docs {
+		if !live[name] {
+			ix.removeLocked(name)
+		}
+	}
+}
+
+func (ix *TextIndex) replace(name string, doc indexedDoc, counts map[string]int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.removeLocked(name)
+	for term, n := range counts {
+		if ix.postings[term] == nil {
+			ix.postings[term] = make(map[string]int)
+		}
+		ix.postings[term][name] = n
+		doc.terms = append(doc.terms, term)
+	}
+	ix.docs[name] = doc
+}
+
+func (ix *TextIndex) removeLocked(name string) {
+	for _, term := range ix.docs[name].terms {
+		delete(ix.postings[term], name)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	delete(ix.docs, name)
+}
+
+// Search returns every indexed file containing all words of text, with its score
+func (ix *TextIndex) Search(text string) map[string]float64 {
+	terms := tokenize(text)
+	if len(terms) == 0 {
+		return nil
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	n := float64(len(ix.docs))
+	var scores map[string]float64
+	for _, term := range terms {
+		docs := ix.postings[term]
+		idf := math.Log(1 + n/float64(len(docs)+1))
+		next := make(map[string]float64)
+		for name, tf := range docs {
+			if prev, ok := scores[name]; ok || scores == nil {
+				next[name] = prev + float64(tf)*idf
+			}
+		}
+		scores = next
+		if len(scores) == 0 {
+			return nil
+		}
+	}
+	return scores
+}
+
+// Size is the number of indexed documents and distinct terms
+func (ix *TextIndex) Size() (int, int) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs), len(ix.postings)
+}
+
+func tokenize(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(word) >= minTermLen {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+func countTerms(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, t := range tokenize(text) {
+		counts[t]++
+	}
+	return counts
+}
+
+// snippet returns the line of the file that contains the most query words, trimmed around the first one
+func snippet(file, text string) string {
+	terms := tokenize(text)
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	best, bestHits := "", 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), maxIndexedFileSize)
+	for sc.Scan() {
+		line := sc.Text()
+		lower := strings.ToLower(line)
+		hits := 0
+		for _, t := range terms {
+			if strings.Contains(lower, t) {
+				hits++
+			}
+		}
+		if hits > bestHits {
+			best, bestHits = line, hits
+			if hits == len(terms) {
+				break
+			}
+		}
+	}
+	if bestHits == 0 {
+		return ""
+	}
+	return trimAround(strings.TrimSpace(best), terms)
+}
+
+func trimAround(line string, terms []string) string {
+	runes := []rune(line)
+	if len(runes) <= maxSnippetLen {
+		return line
+	}
+	at := 0
+	lower := []rune(strings.ToLower(line))
+	for _, t := range terms {
+		if i := strings.Index(string(lower), t); i >= 0 {
+			at = utf8.RuneCountInString(string(lower)[:i])
+			break
+		}
+	}
+	start := at - maxSnippetLen/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxSnippetLen
+	if end > len(runes) {
+		end, start = len(runes), len(runes)-maxSnippetLen
+	}
+	out := string(runes[start:end])
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(runes) {
+		out += "…"
+	}
+	return out
+}
+
+// textHits returns the files of listing that match q, best text score first, and the scores
+func textHits(ws *Workspace, q SearchQuery, listing []AnnouncedEntry) ([]AnnouncedEntry, map[string]float64) {
+	scores := ws.Text.Search(q.Text)
+	var matches []AnnouncedEntry
+	for _, e := range listing {
+		if _, ok := scores[e.Name]; ok && q.Matches(e) {
+			matches = append(matches, e)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return scores[matches[i].Name] > scores[matches[j].Name] })
+	return matches, scores
+}
+
+func cmdFind(ws *Workspace, words []string) error {
+	if len(tokenize(strings.Join(words, " "))) == 0 {
+		return fmt.Errorf("[FullText][cmdFind] give at least one word of %d+ letters", minTermLen)
+	}
+	return cmdSearch(ws, []string{"text:" + strings.Join(words, " ")})
+}
@@ -0,0 +1,768 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Audit the ledger with ordinary git tools
+
+1. export-git <file>   write the ledger + local object contents as a `git fast-import` stream [DONE]
+2. Peer IDs mapped to "Name <email>" through a JSON file (-git-authors) [DONE]
+3. import-git <repo|stream>   seed the ledger from an existing git history [DONE]
+4. A stream we exported imports back into the same version IDs [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+  git init audit && git -C audit fast-import < ledger.fi && git -C audit log --all --graph
+
+- Every file gets its own branch, files/<path>, one commit per FileVersion with the
+  same parents. Snapshots live on snapshots/<folder> with the whole tree per commit.
+- Ledger branches become branches/<path>/<name>, tags become tags <path>/<name>
+  (lightweight, the libp2p signature can't be expressed in git).
+- Content missing from the object store is not fetched from peers; the commit is
+  still written (the history stays complete) and the file keeps its previous state.
+- Each commit message ends with PeerLink-* trailers so import can restore the exact
+  version, tombstones (Deleted) and GC checkpoints (horizon and squashed IDs) included. Commits without them (a plain git repo) get deterministic IDs derived from
+  the commit and the path, so importing the same repo twice changes nothing.
+- Imported versions are authored by the mapped peer ID, or by us if the git author
+  is not in the authors file (an unknown author would block GC forever).
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const defaultGitAuthorsPath = ".peerlink/git-authors.json"
+
+// gitAuthorsPath is set from -git-authors in main
+var gitAuthorsPath = defaultGitAuthorsPath
+
+// loadGitAuthors reads peer ID → "Name <email>"; a missing file is an empty mapping
+func loadGitAuthors(p string) (map[string]string, error) {
+	authors := make(map[string]string)
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return authors, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[gitFastImport][loadGitAuthors] %w", err)
+	}
+	if err := json.Unmarshal(data, &authors); err != nil {
+		return nil, fmt.Errorf("[gitFastImport][loadGitAuthors] bad %s: %w", p, err)
+	}
+	return authors, nil
+}
+
+func gitIdent(authors map[string]string, peerID string) string {
+	if ident, ok := authors[peerID]; ok {
+		return ident
+	}
+	return fmt.Sprintf("%s <%s@peerlink.invalid>", shortID(peerID), peerID)
+}
+
+// gitRefName makes a ledger key safe for `git check-ref-format`
+func gitRefName(name string) string {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for i, part := range parts {
+		var b strings.Builder
+		for _, r := range part {
+			if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+				b.WriteRune('_')
+				continue
+			}
+			b.WriteRune(r)
+		}
+		part = strings.NewReplacer("..", "__", "@{", "_{").Replace(b.String())
+		if part == "" || part == "." || strings.HasPrefix(part, ".") {
+			part = "_" + part
+		}
+		if strings.HasSuffix(part, ".lock") || strings.HasSuffix(part, ".") {
+			part += "_"
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "/")
+}
+
+// gitQuotePath quotes a path the way fast-import expects when it has special characters
+func gitQuotePath(p string) string {
+	if !strings.ContainsAny(p, "\"\\\n") {
+		return p
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(p) + `"`
+}
+
+// ─── export ──────────────────────────────────────────────────────────────────
+
+type fastExporter struct {
+	ws        *Workspace
+	w         *bufio.Writer
+	authors   map[string]string
+	nextMark  int
+	blobMarks map[string]int // CID → mark
+	missing   int
+	commits   int
+}
+
+func (e *fastExporter) mark() int {
+	e.nextMark++
+	return e.nextMark
+}
+
+func (e *fastExporter) data(b []byte) {
+	fmt.Fprintf(e.w, "data %d\n", len(b))
+	e.w.Write(b)
+	e.w.WriteString("\n")
+}
+
+// blob emits the content of cid once and returns its mark, 0 if we don't have it
+func (e *fastExporter) blob(cid string) int {
+	if m, ok := e.blobMarks[cid]; ok {
+		return m
+	}
+	localPath, ok := findLocalFileByCID(e.ws, cid)
+	if !ok {
+		e.blobMarks[cid] = 0
+		e.missing++
+		return 0
+	}
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		e.blobMarks[cid] = 0
+		e.missing++
+		return 0
+	}
+	m := e.mark()
+	fmt.Fprintf(e.w, "blob\nmark :%d\n", m)
+	e.data(content)
+	e.blobMarks[cid] = m
+	return m
+}
+
+func versionTrailers(name string, v FileVersion) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\nPeerLink-File: %s\n", name)
+	fmt.Fprintf(&b, "PeerLink-Version: %s\n", v.VersionID)
+	fmt.Fprintf(&b, "PeerLink-Parents: %s\n", strings.Join(v.ParentIDs, ","))
+	fmt.Fprintf(&b, "PeerLink-Author: %s\n", v.Author)
+	fmt.Fprintf(&b, "PeerLink-HLC: %d.%d\n", v.HLC.WallTime, v.HLC.Logical)
+	fmt.Fprintf(&b, "PeerLink-Timestamp: %s\n", v.Timestamp.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "PeerLink-CID: %s\n", v.CID)
+	if v.Deleted {
+		b.WriteString("PeerLink-Deleted: true\n")
+	}
+	if v.Checkpoint != nil {
+		fmt.Fprintf(&b, "PeerLink-Checkpoint-Horizon: %d.%d\n", v.Checkpoint.Horizon.WallTime, v.Checkpoint.Horizon.Logical)
+		fmt.Fprintf(&b, "PeerLink-Checkpoint-Squashed: %s\n", strings.Join(v.Checkpoint.Squashed, ","))
+	}
+	return b.String()
+}
+
+func (e *fastExporter) exportFile(name string, meta FileMetadata) {
+	var ref string
+	if isSnapshotKey(name) {
+		folder := strings.TrimSuffix(name, "/")
+		if folder == "." {
+			folder = "root"
+		}
+		ref = "refs/heads/snapshots/" + gitRefName(folder)
+	} else {
+		ref = "refs/heads/files/" + gitRefName(name)
+	}
+
+	order := topoOrder(meta) // children first
+	marks := make(map[string]int, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		v := order[i]
+
+		// blobs first, fast-import needs them before the commit that uses them
+		blobs := make(map[string]int)
+		if v.Tree != nil {
+			for p, entry := range v.Tree.Entries {
+				blobs[p] = e.blob(entry.CID)
+			}
+		} else if !v.Deleted {
+			blobs[name] = e.blob(v.CID)
+		}
+
+		var parents []int
+		for _, p := range v.ParentIDs {
+			if m, ok := marks[p]; ok {
+				parents = append(parents, m)
+			}
+		}
+		if len(parents) == 0 {
+			fmt.Fprintf(e.w, "reset %s\n\n", ref) // root commit, don't inherit the branch tip
+		}
+
+		m := e.mark()
+		marks[v.VersionID] = m
+		when := v.orderKey().WallTime / int64(time.Second)
+		ident := gitIdent(e.authors, v.Author)
+		fmt.Fprintf(e.w, "commit %s\nmark :%d\n", ref, m)
+		fmt.Fprintf(e.w, "author %s %d +0000\ncommitter %s %d +0000\n", ident, when, ident, when)
+		e.data([]byte(v.Message + versionTrailers(name, v)))
+		for i, p := range parents {
+			if i == 0 {
+				fmt.Fprintf(e.w, "from :%d\n", p)
+			} else {
+				fmt.Fprintf(e.w, "merge :%d\n", p)
+			}
+		}
+		if v.Tree != nil {
+			e.w.WriteString("deleteall\n")
+		}
+		paths := make([]string, 0, len(blobs))
+		for p := range blobs {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			if blobs[p] != 0 {
+				fmt.Fprintf(e.w, "M 100644 :%d %s\n", blobs[p], gitQuotePath(p))
+			}
+		}
+		if v.Deleted {
+			fmt.Fprintf(e.w, "D %s\n", gitQuotePath(name))
+		}
+		e.w.WriteString("\n")
+		e.commits++
+	}
+
+	if head, ok := LatestHead(meta); ok {
+		fmt.Fprintf(e.w, "reset %s\nfrom :%d\n\n", ref, marks[head.VersionID])
+	}
+	for _, br := range meta.Branches {
+		if m, ok := marks[br.VersionID]; ok {
+			fmt.Fprintf(e.w, "reset refs/heads/branches/%s/%s\nfrom :%d\n\n", gitRefName(name), gitRefName(br.Name), m)
+		}
+	}
+	for _, t := range meta.Tags {
+		if m, ok := marks[t.VersionID]; ok {
+			fmt.Fprintf(e.w, "reset refs/tags/%s/%s\nfrom :%d\n\n", gitRefName(name), gitRefName(t.Name), m)
+		}
+	}
+}
+
+// cmdExportGit writes the whole ledger as a fast-import stream
+func cmdExportGit(ws *Workspace, outPath string) error {
+	authors, err := loadGitAuthors(gitAuthorsPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("[gitFastImport][cmdExportGit] %w", err)
+	}
+	defer f.Close()
+
+	e := &fastExporter{ws: ws, w: bufio.NewWriter(f), authors: authors, blobMarks: make(map[string]int)}
+	snapshot := ws.Store.Snapshot()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.exportFile(name, snapshot[name])
+	}
+	e.w.WriteString("done\n")
+	if err := e.w.Flush(); err != nil {
+		return fmt.Errorf("[gitFastImport][cmdExportGit] write failed: %w", err)
+	}
+
+	fmt.Printf("📦 Wrote %d commit(s) for %d file(s) to %s\n", e.commits, len(names), outPath)
+	if e.missing > 0 {
+		fmt.Printf("   ⚠️ %d version content(s) not in the local object store were left out\n", e.missing)
+	}
+	fmt.Printf("   git init audit && git -C audit fast-import --done < %s\n", outPath)
+	return nil
+}
+
+// ─── import ──────────────────────────────────────────────────────────────────
+
+type fastImportParser struct {
+	r       *bufio.Reader
+	peeked  *string
+	objects *ObjectStore // blob contents go here
+}
+
+func (p *fastImportParser) readLine() (string, error) {
+	if p.peeked != nil {
+		line := *p.peeked
+		p.peeked = nil
+		return line, nil
+	}
+	line, err := p.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func (p *fastImportParser) unread(line string) {
+	p.peeked = &line
+}
+
+// readData reads the payload announced by a "data <n>" line
+func (p *fastImportParser) readData(line string) ([]byte, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(line, "data "))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported data line %q", line)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return nil, err
+	}
+	if b, err := p.r.Peek(1); err == nil && b[0] == '\n' {
+		p.r.ReadByte()
+	}
+	return buf, nil
+}
+
+// parsePath reads a possibly C-quoted path
+func parsePath(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		if unq, err := strconv.Unquote(s); err == nil {
+			return unq
+		}
+	}
+	return s
+}
+
+type gitCommit struct {
+	mark     string
+	oid      string
+	ident    string
+	when     time.Time
+	message  string
+	parents  []string
+	modified map[string]string // path → blob mark/oid ("" when deleted)
+	order    []string
+	clearAll bool
+}
+
+// parseTrailers returns the message without PeerLink-* trailers, and the trailers
+func parseTrailers(msg string) (string, map[string]string) {
+	trailers := make(map[string]string)
+	i := strings.Index(msg, "\n\nPeerLink-File: ")
+	if i < 0 {
+		return strings.TrimSpace(msg), trailers
+	}
+	for _, line := range strings.Split(msg[i+2:], "\n") {
+		if k, v, ok := strings.Cut(line, ": "); ok && strings.HasPrefix(k, "PeerLink-") {
+			trailers[strings.TrimPrefix(k, "PeerLink-")] = v
+		}
+	}
+	return msg[:i], trailers
+}
+
+// parseIdent splits "Name <email> 1700000000 +0100"
+func parseIdent(s string) (string, time.Time) {
+	end := strings.LastIndex(s, ">")
+	if end < 0 {
+		return s, time.Time{}
+	}
+	fields := strings.Fields(s[end+1:])
+	var when time.Time
+	if len(fields) > 0 {
+		if secs, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			when = time.Unix(secs, 0).UTC()
+		}
+	}
+	return s[:end+1], when
+}
+
+type gitImporter struct {
+	objects  *ObjectStore
+	self     string
+	byIdent  map[string]string // "Name <email>" or "<email>" → peer ID
+	blobs    map[string]string // mark → CID
+	states   map[string]map[string]string
+	imported map[string]FileMetadata
+	skipped  int
+}
+
+func (im *gitImporter) peerFor(ident string) string {
+	if id, ok := im.byIdent[ident]; ok {
+		return id
+	}
+	if i := strings.Index(ident, "<"); i >= 0 {
+		if id, ok := im.byIdent[ident[i:]]; ok {
+			return id
+		}
+	}
+	return im.self
+}
+
+func (im *gitImporter) addVersion(file string, v FileVersion) {
+	meta, ok := im.imported[file]
+	if !ok {
+		meta = FileMetadata{FileName: file, Versions: make(map[string]FileVersion)}
+	}
+	meta.Versions[v.VersionID] = v
+	im.imported[file] = meta
+}
+
+func (im *gitImporter) versionAt(commit, file string) (FileVersion, bool) {
+	id, ok := im.states[commit][file]
+	if !ok {
+		return FileVersion{}, false
+	}
+	v, ok := im.imported[file].Versions[id]
+	return v, ok
+}
+
+func (im *gitImporter) commit(c gitCommit) {
+	state := make(map[string]string)
+	if len(c.parents) > 0 {
+		for p, id := range im.states[c.parents[0]] {
+			state[p] = id
+		}
+	}
+	if c.clearAll {
+		state = make(map[string]string)
+	}
+	defer func() {
+		if c.mark != "" {
+			im.states[c.mark] = state
+		}
+	}()
+
+	body, trailers := parseTrailers(c.message)
+	if file, ok := trailers["File"]; ok {
+		// a commit we exported: restore the version exactly
+		if isSnapshotKey(file) {
+			im.skipped++
+			return
+		}
+		var hlc HLCTimestamp
+		fmt.Sscanf(trailers["HLC"], "%d.%d", &hlc.WallTime, &hlc.Logical)
+		ts, _ := time.Parse(time.RFC3339Nano, trailers["Timestamp"])
+		var parents []string
+		if trailers["Parents"] != "" {
+			parents = strings.Split(trailers["Parents"], ",")
+		}
+		v := FileVersion{
+			VersionID: trailers["Version"],
+			ParentIDs: parents,
+			Author:    trailers["Author"],
+			Timestamp: ts,
+			HLC:       hlc,
+			Message:   body,
+			CID:       trailers["CID"],
+			Deleted:   trailers["Deleted"] == "true",
+		}
+		if horizon, ok := trailers["Checkpoint-Horizon"]; ok {
+			cp := &Checkpoint{Squashed: []string{}}
+			fmt.Sscanf(horizon, "%d.%d", &cp.Horizon.WallTime, &cp.Horizon.Logical)
+			if trailers["Checkpoint-Squashed"] != "" {
+				cp.Squashed = strings.Split(trailers["Checkpoint-Squashed"], ",")
+			}
+			v.Checkpoint = cp
+		}
+		im.addVersion(file, v)
+		state[file] = v.VersionID
+		return
+	}
+
+	author := im.peerFor(c.ident)
+	commitKey := c.oid
+	if commitKey == "" {
+		commitKey = "mark" + c.mark
+	}
+	subject, _, _ := strings.Cut(body, "\n")
+	message := fmt.Sprintf("%s [git %s by %s]", subject, shortID(commitKey), c.ident)
+	newVersion := func(file, cid string, parents []string) {
+		sum := sha256.Sum256([]byte("git-import|" + commitKey + "|" + file))
+		v := FileVersion{
+			VersionID: hex.EncodeToString(sum[:]),
+			ParentIDs: parents,
+			Author:    author,
+			Timestamp: c.when,
+			HLC:       HLCTimestamp{WallTime: c.when.UnixNano()},
+			Message:   message,
+			CID:       cid,
+		}
+		im.addVersion(file, v)
+		state[file] = v.VersionID
+	}
+	// file parents = that file's version in every parent commit
+	parentsOf := func(file string) []string {
+		var ids []string
+		for _, p := range c.parents {
+			if v, ok := im.versionAt(p, file); ok && indexOf(ids, v.VersionID) < 0 {
+				ids = append(ids, v.VersionID)
+			}
+		}
+		return ids
+	}
+
+	for _, file := range c.order {
+		dataref := c.modified[file]
+		if dataref == "" {
+			delete(state, file)
+			continue
+		}
+		cid, ok := im.blobs[dataref]
+		if strings.HasPrefix(dataref, "inline:") {
+			cid, ok = strings.TrimPrefix(dataref, "inline:"), true
+		}
+		if !ok {
+			continue // submodule or a blob outside the stream
+		}
+		newVersion(file, cid, parentsOf(file))
+	}
+
+	// a merge that kept the first parent's content still joins the other lines of history
+	if len(c.parents) > 1 {
+		seen := make(map[string]struct{})
+		for _, p := range c.parents[1:] {
+			for file := range im.states[p] {
+				seen[file] = struct{}{}
+			}
+		}
+		for file := range seen {
+			if _, touched := c.modified[file]; touched {
+				continue
+			}
+			current, ok := im.versionAt(c.parents[0], file)
+			if !ok || state[file] != current.VersionID {
+				continue
+			}
+			if parents := parentsOf(file); len(parents) > 1 {
+				newVersion(file, current.CID, parents)
+			}
+		}
+	}
+}
+
+func (im *gitImporter) run(r io.Reader) error {
+	p := &fastImportParser{r: bufio.NewReaderSize(r, 1<<16), objects: im.objects}
+	for {
+		line, err := p.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "blob":
+			var mark string
+			for {
+				line, err = p.readLine()
+				if err != nil {
+					return err
+				}
+				if strings.HasPrefix(line, "mark ") {
+					mark = strings.TrimPrefix(line, "mark ")
+				} else if strings.HasPrefix(line, "data ") {
+					break
+				}
+			}
+			content, err := p.readData(line)
+			if err != nil {
+				return err
+			}
+			cid, err := im.objects.Put(content)
+			if err != nil {
+				return err
+			}
+			im.blobs[mark] = cid
+
+		case strings.HasPrefix(line, "commit "):
+			c, err := p.readCommit()
+			if err != nil {
+				return err
+			}
+			im.commit(c)
+
+		case strings.HasPrefix(line, "tag "):
+			// annotated tags can't be signed by a peer, skip them
+			for {
+				line, err = p.readLine()
+				if err != nil {
+					return err
+				}
+				if strings.HasPrefix(line, "data ") {
+					if _, err := p.readData(line); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		case line == "done":
+			return nil
+		}
+		// reset, feature, option, progress, checkpoint and blank lines carry nothing we need
+	}
+}
+
+func (p *fastImportParser) readCommit() (gitCommit, error) {
+	c := gitCommit{modified: make(map[string]string)}
+	for {
+		line, err := p.readLine()
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return c, err
+		}
+		switch {
+		case strings.HasPrefix(line, "mark "):
+			c.mark = strings.TrimPrefix(line, "mark ")
+		case strings.HasPrefix(line, "original-oid "):
+			c.oid = strings.TrimPrefix(line, "original-oid ")
+		case strings.HasPrefix(line, "author "):
+			c.ident, c.when = parseIdent(strings.TrimPrefix(line, "author "))
+		case strings.HasPrefix(line, "committer "):
+			if c.ident == "" {
+				c.ident, c.when = parseIdent(strings.TrimPrefix(line, "committer "))
+			}
+		case strings.HasPrefix(line, "data "):
+			msg, err := p.readData(line)
+			if err != nil {
+				return c, err
+			}
+			c.message = string(msg)
+		case strings.HasPrefix(line, "from "):
+			c.parents = append([]string{strings.TrimPrefix(line, "from ")}, c.parents...)
+		case strings.HasPrefix(line, "merge "):
+			c.parents = append(c.parents, strings.TrimPrefix(line, "merge "))
+		case line == "deleteall":
+			c.clearAll = true
+			c.modified = make(map[string]string)
+			c.order = nil
+		case strings.HasPrefix(line, "M "):
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 4 {
+				return c, fmt.Errorf("bad filemodify %q", line)
+			}
+			file := parsePath(fields[3])
+			dataref := fields[2]
+			if dataref == "inline" {
+				data, err := p.readLine()
+				if err != nil {
+					return c, err
+				}
+				content, err := p.readData(data)
+				if err != nil {
+					return c, err
+				}
+				// inline content has no mark, stash it under a synthetic one
+				cid, err := p.objects.Put(content)
+				if err != nil {
+					return c, err
+				}
+				dataref = "inline:" + cid
+			}
+			if fields[1] == "160000" || fields[1] == "040000" {
+				continue // submodules and trees aren't files
+			}
+			if _, seen := c.modified[file]; !seen {
+				c.order = append(c.order, file)
+			}
+			c.modified[file] = dataref
+		case strings.HasPrefix(line, "D "):
+			file := parsePath(strings.TrimPrefix(line, "D "))
+			if _, seen := c.modified[file]; !seen {
+				c.order = append(c.order, file)
+			}
+			c.modified[file] = ""
+		case line == "":
+			return c, nil
+		case strings.HasPrefix(line, "encoding "), strings.HasPrefix(line, "N "):
+		default:
+			// next command without the optional blank line
+			p.unread(line)
+			return c, nil
+		}
+	}
+}
+
+// cmdImportGit seeds the ledger from a git repository (or a fast-export stream file)
+func cmdImportGit(ws *Workspace, source string) error {
+	authors, err := loadGitAuthors(gitAuthorsPath)
+	if err != nil {
+		return err
+	}
+	im := &gitImporter{
+		objects:  ws.Objects,
+		self:     node.ID().String(),
+		byIdent:  make(map[string]string),
+		blobs:    make(map[string]string),
+		states:   make(map[string]map[string]string),
+		imported: make(map[string]FileMetadata),
+	}
+	for id, ident := range authors {
+		im.byIdent[ident] = id
+		if i := strings.Index(ident, "<"); i >= 0 {
+			im.byIdent[ident[i:]] = id
+		}
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("[gitFastImport][cmdImportGit] %w", err)
+	}
+	if info.IsDir() {
+		cmd := exec.Command("git", "-C", source, "fast-export", "--all", "--show-original-ids",
+			"--reencode=yes", "--signed-tags=strip", "--tag-of-filtered-object=drop")
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("[gitFastImport][cmdImportGit] cannot run git: %w", err)
+		}
+		runErr := im.run(out)
+		io.Copy(io.Discard, out)
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("[gitFastImport][cmdImportGit] git fast-export failed: %w", err)
+		}
+		if runErr != nil {
+			return fmt.Errorf("[gitFastImport][cmdImportGit] bad stream: %w", runErr)
+		}
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := im.run(f); err != nil {
+			return fmt.Errorf("[gitFastImport][cmdImportGit] bad stream: %w", err)
+		}
+	}
+
+	count := 0
+	for name, meta := range im.imported {
+		meta.FileName = name
+		meta.Heads = computeHeads(meta.Versions)
+		im.imported[name] = meta
+		count += len(meta.Versions)
+	}
+	changed, err := ws.Store.MergeRemote("git-import", im.imported)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📥 Read %d version(s) of %d file(s) from %s, %d file(s) updated\n", count, len(im.imported), source, len(changed))
+	if im.skipped > 0 {
+		fmt.Printf("   %d snapshot commit(s) skipped, take new snapshots after importing\n", im.skipped)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+func newGitTestWorkspace(t *testing.T, name string) *Workspace {
+	t.Helper()
+	ws := newTestWorkspace(t, name)
+	objects, err := NewObjectStore(filepath.Join(t.TempDir(), "objects"), RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Objects = objects
+	return ws
+}
+
+// TestGitRoundTrip exports edits, a tombstone and a checkpoint and imports them into
+// an empty ledger: every version must come back with the same ID and fields
+func TestGitRoundTrip(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	saved := node
+	node = h // import authors unknown git commits as us
+	defer func() { node = saved }()
+
+	src := newGitTestWorkspace(t, "export")
+	self := h.ID().String()
+	put := func(content string) string {
+		cid, err := src.Objects.Put([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cid
+	}
+
+	_, err = src.Store.Update("local", func(tx *MetadataTxn) error {
+		notes := FileMetadata{FileName: "notes.txt", Versions: make(map[string]FileVersion)}
+		v1 := NewFileVersion(self, "first", put("one"), nil)
+		notes.AddVersion(v1)
+		v2 := NewFileVersion(self, "second", put("two"), []string{v1.VersionID})
+		notes.AddVersion(v2)
+		notes.AddVersion(NewDeletion(self, "removed", []string{v2.VersionID}))
+		tx.Put(notes)
+
+		plan := FileMetadata{FileName: "docs/plan.md", Versions: make(map[string]FileVersion)}
+		cp := NewFileVersion(self, "checkpoint", put("squashed state"), nil)
+		cp.Checkpoint = &Checkpoint{Horizon: hlcClock.Now(), Squashed: []string{"aaaa", "bbbb"}}
+		cp.VersionID = checkpointID(cp.Checkpoint.Squashed) // a valid one, see checkCheckpoint
+		plan.AddVersion(cp)
+		plan.AddVersion(NewFileVersion(self, "after gc", put("newer"), []string{cp.VersionID}))
+		tx.Put(plan)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := filepath.Join(t.TempDir(), "ledger.fi")
+	if err := cmdExportGit(src, stream); err != nil {
+		t.Fatal(err)
+	}
+	dst := newGitTestWorkspace(t, "import")
+	if err := cmdImportGit(dst, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := src.Store.Snapshot(), dst.Store.Snapshot()
+	if len(got) != len(want) {
+		t.Fatalf("imported %d files, exported %d", len(got), len(want))
+	}
+	for name, meta := range want {
+		imported := got[name]
+		if !reflect.DeepEqual(imported.Heads, meta.Heads) {
+			t.Errorf("%s: heads %v, want %v", name, imported.Heads, meta.Heads)
+		}
+		for id, v := range meta.Versions {
+			back, ok := imported.Versions[id]
+			if !ok {
+				t.Errorf("%s: version %s (%s) lost", name, shortID(id), v.Message)
+				continue
+			}
+			if !back.Timestamp.Equal(v.Timestamp) {
+				t.Errorf("%s %s: timestamp %s, want %s", name, shortID(id), back.Timestamp, v.Timestamp)
+			}
+			back.Timestamp = v.Timestamp
+			if len(back.ParentIDs) == 0 {
+				back.ParentIDs = v.ParentIDs // nil and empty both mean a root
+			}
+			if !reflect.DeepEqual(back, v) {
+				t.Errorf("%s %s:\n got %+v\nwant %+v", name, shortID(id), back, v)
+			}
+		}
+	}
+}
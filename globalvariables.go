@@ -6,19 +6,22 @@ import (
 	"sync"
 )
 
+// metadataFilePath is where the default workspace's CRDT ledger is persisted by the
+// JSON backend, boltLedgerPath by the bbolt one (-store bolt), see workspace.go
+const (
+	metadataFilePath = "sync-metadata.json"
+	boltLedgerPath   = ".peerlink/ledger.db"
+)
+
 // Global state declaration
 var (
 	localFileMetadata FileMetadata
 	node              host.Host
-	syncedPeers       = make(map[string]bool)
-	usedEncryption    = false
 
 	// 👇 Peer store
 	knownPeers     = make(map[string]peer.AddrInfo)
 	knownPeersLock sync.Mutex
 
 	// printLock at the global level
-	printLock       sync.Mutex
-	fileMetadataMap = make(map[string]FileMetadata) // key: file name
-
+	printLock sync.Mutex
 )
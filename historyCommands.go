@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Git-style history commands on top of FileMetadata.Versions (the CRDT DAG)
+
+1. log <file>                 topologically ordered history with a graph view [DONE]
+2. show <version>             one version's fields, parents and children [DONE]
+3. diff <v1> <v2>             line diff, content fetched from peers by CID if needed [DONE]
+4. checkout <file> <version>  restore an old version into shared/ as a new head [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- Versions can be named by any unique prefix of their version ID, or as
+  <file>@<tag|branch|version> (see refs.go)
+- Topological order = every child before its parents, newest (HLC) first among ready ones
+- checkout never rewrites history: it adds a version whose parents are the
+  current heads and whose CID is the restored content, so peers just see a new head
+- checkout of a tombstone removes the file and adds a deletion head; snapshot
+  versions are whole folders and go through getsnapshot instead
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+// maxDiffCells caps the LCS table so a diff of two huge files can't eat the node's memory
+const maxDiffCells = 4_000_000
+
+// resolveVersion finds a version by ID prefix, optionally restricted to one file.
+// "<file>@<ref>" or a file plus a tag/branch name resolve through refs.go.
+func resolveVersion(ws *Workspace, fileName, ref string) (string, FileVersion, error) {
+	if f, r, ok := splitFileRef(ref); ok && fileName == "" {
+		fileName, ref = f, r
+	}
+	if fileName != "" {
+		meta, ok := ws.Store.Get(fileName)
+		if !ok {
+			return "", FileVersion{}, fmt.Errorf("[history][resolveVersion] file '%s' is not in the ledger", fileName)
+		}
+		id, err := resolveRef(meta, ref)
+		if err != nil {
+			return "", FileVersion{}, err
+		}
+		return fileName, meta.Versions[id], nil
+	}
+
+	var matches []FileVersion
+	var matchFiles []string
+	for name, meta := range ws.Store.Snapshot() {
+		for id, v := range meta.Versions {
+			if strings.HasPrefix(id, ref) {
+				matches = append(matches, v)
+				matchFiles = append(matchFiles, name)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", FileVersion{}, fmt.Errorf("[history][resolveVersion] no version matches '%s'", ref)
+	case 1:
+		return matchFiles[0], matches[0], nil
+	}
+	return "", FileVersion{}, fmt.Errorf("[history][resolveVersion] '%s' is ambiguous (%d versions match)", ref, len(matches))
+}
+
+// topoOrder lists versions children first; among versions that are ready the newest HLC goes first
+func topoOrder(meta FileMetadata) []FileVersion {
+	pendingChildren := make(map[string]int, len(meta.Versions))
+	for _, v := range meta.Versions {
+		for _, p := range v.ParentIDs {
+			if _, ok := meta.Versions[p]; ok {
+				pendingChildren[p]++
+			}
+		}
+	}
+	ready := make([]FileVersion, 0)
+	for id, v := range meta.Versions {
+		if pendingChildren[id] == 0 {
+			ready = append(ready, v)
+		}
+	}
+
+	out := make([]FileVersion, 0, len(meta.Versions))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return compareVersions(ready[i], ready[j]) > 0 })
+		v := ready[0]
+		ready = ready[1:]
+		out = append(out, v)
+		for _, p := range v.ParentIDs {
+			if _, ok := meta.Versions[p]; !ok {
+				continue
+			}
+			pendingChildren[p]--
+			if pendingChildren[p] == 0 {
+				ready = append(ready, meta.Versions[p])
+			}
+		}
+	}
+	return out
+}
+
+// cmdLog prints a file's history with a simple lane graph, like `git log --graph --oneline`
+func cmdLog(ws *Workspace, fileName string) error {
+	meta, ok := ws.Store.Get(fileName)
+	if !ok {
+		return fmt.Errorf("[history][cmdLog] file '%s' is not in the ledger", fileName)
+	}
+	heads := make(map[string]struct{}, len(meta.Heads))
+	for _, h := range meta.Heads {
+		heads[h] = struct{}{}
+	}
+
+	var lanes []string // version ID each column is waiting for
+	fmt.Printf("📜 log %s\n", fileName)
+	for _, v := range topoOrder(meta) {
+		col := indexOf(lanes, v.VersionID)
+		if col < 0 {
+			lanes = append(lanes, v.VersionID)
+			col = len(lanes) - 1
+		}
+
+		var graph strings.Builder
+		for i := range lanes {
+			if i == col {
+				graph.WriteString("* ")
+			} else {
+				graph.WriteString("| ")
+			}
+		}
+		label := ""
+		if _, isHead := heads[v.VersionID]; isHead {
+			label = " (HEAD)"
+		}
+		fmt.Printf("%s%s%s %s %s  %s\n", graph.String(), shortID(v.VersionID), label, v.orderKey(), shortID(v.Author), v.Message)
+
+		// this lane now waits for the first parent; extra parents open new lanes (a merge)
+		var next []string
+		for i, id := range lanes {
+			if i != col {
+				next = append(next, id)
+				continue
+			}
+			if len(v.ParentIDs) > 0 && indexOf(lanes, v.ParentIDs[0]) < 0 {
+				next = append(next, v.ParentIDs[0])
+			}
+		}
+		for _, p := range v.ParentIDs[min(1, len(v.ParentIDs)):] {
+			if indexOf(next, p) < 0 {
+				next = append(next, p)
+			}
+		}
+		lanes = next
+		if len(v.ParentIDs) > 1 {
+			fmt.Println(strings.Repeat("| ", col) + "|\\")
+		}
+	}
+	return nil
+}
+
+// cmdShow prints one version
+func cmdShow(ws *Workspace, ref string) error {
+	fileName, v, err := resolveVersion(ws, "", ref)
+	if err != nil {
+		return err
+	}
+	meta, _ := ws.Store.Get(fileName)
+
+	var children []string
+	for id, other := range meta.Versions {
+		if indexOf(other.ParentIDs, v.VersionID) >= 0 {
+			children = append(children, shortID(id))
+		}
+	}
+	sort.Strings(children)
+
+	_, local := findLocalFileByCID(ws, v.CID)
+	fmt.Printf("version   %s\n", v.VersionID)
+	fmt.Printf("file      %s\n", fileName)
+	fmt.Printf("author    %s\n", v.Author)
+	fmt.Printf("hlc       %s\n", v.orderKey())
+	fmt.Printf("wallclock %s\n", v.Timestamp.Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("cid       %s (local content: %v)\n", v.CID, local)
+	fmt.Printf("parents   %v\n", v.ParentIDs)
+	fmt.Printf("children  %v\n", children)
+	fmt.Printf("\n    %s\n", v.Message)
+	return nil
+}
+
+// contentForVersion reads the version's bytes locally or fetches them from peers by CID
+func contentForVersion(ws *Workspace, v FileVersion) ([]byte, error) {
+	if path, ok := findLocalFileByCID(ws, v.CID); ok {
+		return os.ReadFile(path)
+	}
+	log.Printf("[history][contentForVersion] Content %s not local, asking peers...", shortID(v.CID))
+	path, err := fetchContentByCID(ws, v.CID, v.Author)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// cmdDiff prints a unified-style line diff between two versions
+func cmdDiff(ws *Workspace, ref1, ref2 string) error {
+	_, v1, err := resolveVersion(ws, "", ref1)
+	if err != nil {
+		return err
+	}
+	_, v2, err := resolveVersion(ws, "", ref2)
+	if err != nil {
+		return err
+	}
+	if v1.CID == v2.CID {
+		fmt.Println("(no content changes)")
+		return nil
+	}
+	a, err := contentForVersion(ws, v1)
+	if err != nil {
+		return err
+	}
+	b, err := contentForVersion(ws, v2)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("--- %s\n+++ %s\n", shortID(v1.VersionID), shortID(v2.VersionID))
+	for _, line := range lineDiff(splitLines(string(a)), splitLines(string(b))) {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineDiff is a plain LCS diff: " " kept, "-" only in a, "+" only in b
+func lineDiff(a, b []string) []string {
+	if len(a)*len(b) > maxDiffCells {
+		return []string{fmt.Sprintf("(files too large to diff: %d vs %d lines)", len(a), len(b))}
+	}
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	out := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
+
+// cmdCheckout writes an old version back into the shared folder and records it as a new head
+func cmdCheckout(ws *Workspace, fileName, ref string) error {
+	_, v, err := resolveVersion(ws, fileName, ref)
+	if err != nil {
+		return err
+	}
+	if v.Tree != nil || isSnapshotKey(fileName) {
+		return fmt.Errorf("[history][cmdCheckout] %s is a folder snapshot, restore it with 'getsnapshot'", shortID(v.VersionID))
+	}
+	target, root, ok := ws.localPath(fileName)
+	switch {
+	case !ok:
+		return fmt.Errorf("[history][cmdCheckout] %s is not in a shared root of this node (see 'roots')", fileName)
+	case root.ReadOnly():
+		return fmt.Errorf("[history][cmdCheckout] root %q is read-only, nothing is written into it", root.Label)
+	}
+	if v.Deleted || v.CID == "" {
+		return checkoutDeletion(ws, fileName, target, v)
+	}
+	content, err := contentForVersion(ws, v)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Objects.Put(content); err != nil {
+		return fmt.Errorf("[history][cmdCheckout] cannot store restored content: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return fmt.Errorf("[history][cmdCheckout] cannot create folder: %w", err)
+	}
+	if err := os.WriteFile(target, content, 0644); err != nil {
+		return fmt.Errorf("[history][cmdCheckout] cannot write %s: %w", target, err)
+	}
+
+	var restored FileVersion
+	_, err = ws.Store.Update("checkout", func(tx *MetadataTxn) error {
+		meta, _ := tx.Get(fileName)
+		restored = NewFileVersion(node.ID().String(), "checkout of "+shortID(v.VersionID), v.CID, meta.Heads)
+		meta.AddVersion(restored)
+		tx.Put(meta)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ %s restored to %s as new head %s\n", fileName, shortID(v.VersionID), shortID(restored.VersionID))
+	return nil
+}
+
+// checkoutDeletion restores a tombstone: the file is removed and a deletion becomes the new head
+func checkoutDeletion(ws *Workspace, fileName, target string, v FileVersion) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("[history][cmdCheckout] cannot remove %s: %w", target, err)
+	}
+	var restored FileVersion
+	_, err := ws.Store.Update("checkout", func(tx *MetadataTxn) error {
+		meta, _ := tx.Get(fileName)
+		restored = NewDeletion(node.ID().String(), "checkout of "+shortID(v.VersionID), meta.Heads)
+		meta.AddVersion(restored)
+		tx.Put(meta)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ %s restored to deletion %s as new head %s\n", fileName, shortID(v.VersionID), shortID(restored.VersionID))
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, x := range list {
+		if x == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestTopoOrderForkedDAG: r → a1 → a2 and r → b1, merged by m. Children come before parents,
+// among ready versions the newest HLC goes first
+func TestTopoOrderForkedDAG(t *testing.T) {
+	meta := FileMetadata{FileName: "plan.md", Versions: make(map[string]FileVersion)}
+	add := func(msg string, wall int64, parents ...string) FileVersion {
+		v := NewFileVersion("peer", msg, contentCID([]byte(msg)), parents)
+		v.HLC = HLCTimestamp{WallTime: wall}
+		meta.AddVersion(v)
+		return v
+	}
+	r := add("r", 100)
+	a1 := add("a1", 200, r.VersionID)
+	b1 := add("b1", 300, r.VersionID)
+	a2 := add("a2", 400, a1.VersionID)
+	add("m", 500, a2.VersionID, b1.VersionID)
+
+	var got []string
+	for _, v := range topoOrder(meta) {
+		got = append(got, v.Message)
+	}
+	if want := []string{"m", "a2", "b1", "a1", "r"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order %v, want %v", got, want)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff(splitLines("a\nb\nc\n"), splitLines("a\nc\nd\n"))
+	if want := []string{" a", "-b", " c", "+d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("diff %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+1. Hybrid logical clock (HLC) timestamp carried on every FileVersion [DONE]
+2. Advance the local clock whenever remote versions are merged [DONE]
+3. Total order of versions (HLC → author → version ID) for history and LWW [DONE]
+4. Warn when a peer's physical clock is way ahead of ours, or ours of theirs [DONE]
+5. Versions stamped too far in the future are held back before they can win LWW [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- WallTime is unix nanoseconds of the max physical time seen so far
+- Logical breaks ties when the wall clock didn't move (or went backwards)
+- Versions written before HLC existed have a zero HLC; their wall clock
+  Timestamp is used instead so old ledgers still sort sensibly
+- A remote timestamp further than maxClockDrift in the future is logged and
+  NOT adopted, so one broken laptop can't drag every clock into next year
+- Versions stamped that far ahead are not merged at all (holdBackFuture, called by
+  MergeRemote): they would win every LWW pick until our clock caught up. Their
+  descendants are later still, so whole branches wait together. The sender keeps
+  them and every later sync offers them again, once they are within maxClockDrift
+  of our clock they merge normally
+- The HLC is part of a new version's ID (GenerateHash), and a version we already hold is
+  never replaced by a peer's copy, so nobody can restamp a known version to win LWW
+- /hello/2.0.0 carries each side's physical clock (SyncSummary.Clock), a skew beyond
+  maxClockDrift is logged on both sides: the peer ahead and the peer behind
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+// maxClockDrift is how far ahead of us a peer's clock may be before we warn and refuse to follow it
+const maxClockDrift = 5 * time.Minute
+
+type HLCTimestamp struct {
+	WallTime int64  `json:"wall"`
+	Logical  uint32 `json:"logical"`
+}
+
+type HybridClock struct {
+	mu       sync.Mutex
+	last     HLCTimestamp
+	maxDrift time.Duration
+	physical func() time.Time
+}
+
+var hlcClock = NewHybridClock(maxClockDrift)
+
+func NewHybridClock(maxDrift time.Duration) *HybridClock {
+	return &HybridClock{maxDrift: maxDrift, physical: time.Now}
+}
+
+func (t HLCTimestamp) IsZero() bool {
+	return t.WallTime == 0 && t.Logical == 0
+}
+
+// Compare returns -1, 0 or 1
+func (t HLCTimestamp) Compare(o HLCTimestamp) int {
+	switch {
+	case t.WallTime < o.WallTime:
+		return -1
+	case t.WallTime > o.WallTime:
+		return 1
+	case t.Logical < o.Logical:
+		return -1
+	case t.Logical > o.Logical:
+		return 1
+	}
+	return 0
+}
+
+func (t HLCTimestamp) String() string {
+	return fmt.Sprintf("%s+%d", time.Unix(0, t.WallTime).UTC().Format(time.RFC3339Nano), t.Logical)
+}
+
+// Now returns a timestamp for a local event (a new version)
+func (c *HybridClock) Now() HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.physical().UnixNano()
+	if pt > c.last.WallTime {
+		c.last = HLCTimestamp{WallTime: pt}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update folds a remote timestamp into the clock (receive event)
+func (c *HybridClock) Update(remote HLCTimestamp, from string) {
+	if remote.IsZero() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.physical().UnixNano()
+
+	if drift := time.Duration(remote.WallTime - pt); drift > c.maxDrift {
+		log.Printf("[HLC][Update] ⚠️ Peer %s clock is %s ahead of ours, not advancing local clock", from, drift.Round(time.Second))
+		return
+	}
+
+	switch {
+	case pt > c.last.WallTime && pt > remote.WallTime:
+		c.last = HLCTimestamp{WallTime: pt}
+	case remote.WallTime > c.last.WallTime:
+		c.last = HLCTimestamp{WallTime: remote.WallTime, Logical: remote.Logical + 1}
+	case c.last.WallTime > remote.WallTime:
+		c.last.Logical++
+	default: // equal wall times
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.last.Logical++
+	}
+}
+
+// physicalNow is the clock's wall time as a timestamp, without ticking the clock
+func (c *HybridClock) physicalNow() HLCTimestamp {
+	return HLCTimestamp{WallTime: c.physical().UnixNano()}
+}
+
+// aheadBy reports how far ts is ahead of our physical clock, ok when that exceeds maxDrift
+func (c *HybridClock) aheadBy(ts HLCTimestamp) (time.Duration, bool) {
+	drift := time.Duration(ts.WallTime - c.physical().UnixNano())
+	return drift, drift > c.maxDrift
+}
+
+// holdBackFuture drops remote versions we don't have yet whose order key is too far
+// ahead of our clock, so they never take part in ordering until the clock gets there
+func holdBackFuture(name string, local, remote FileMetadata, source string) FileMetadata {
+	out := remote
+	held, worst := 0, time.Duration(0)
+	for id, v := range remote.Versions {
+		if _, known := local.Versions[id]; known {
+			continue
+		}
+		drift, ahead := hlcClock.aheadBy(v.orderKey())
+		if !ahead {
+			continue
+		}
+		if held == 0 {
+			out.Versions = make(map[string]FileVersion, len(remote.Versions))
+			for k, kept := range remote.Versions {
+				out.Versions[k] = kept
+			}
+		}
+		delete(out.Versions, id)
+		held++
+		if drift > worst {
+			worst = drift
+		}
+	}
+	if held > 0 {
+		log.Printf("[HLC][holdBackFuture] ⚠️ Holding back %d version(s) of %s from %s, stamped up to %s ahead of our clock",
+			held, name, shortID(source), worst.Round(time.Second))
+	}
+	return out
+}
+
+// checkPeerClock warns when the peer's physical clock and ours are more than maxDrift apart
+func checkPeerClock(theirs HLCTimestamp, source string) {
+	if theirs.IsZero() {
+		return // older node
+	}
+	drift, ahead := hlcClock.aheadBy(theirs)
+	switch {
+	case ahead:
+		log.Printf("[HLC][checkPeerClock] ⚠️ Peer %s clock is %s ahead of ours, its new versions wait until we get there",
+			shortID(source), drift.Round(time.Second))
+	case -drift > hlcClock.maxDrift:
+		log.Printf("[HLC][checkPeerClock] ⚠️ Our clock is %s ahead of peer %s, it holds back the versions we write now",
+			(-drift).Round(time.Second), shortID(source))
+	}
+}
+
+// orderKey is the HLC of a version, falling back to its wall clock for pre-HLC versions
+func (v FileVersion) orderKey() HLCTimestamp {
+	if !v.HLC.IsZero() {
+		return v.HLC
+	}
+	return HLCTimestamp{WallTime: v.Timestamp.UnixNano()}
+}
+
+// compareVersions is the total order used by history views and last-writer-wins
+func compareVersions(a, b FileVersion) int {
+	if c := a.orderKey().Compare(b.orderKey()); c != 0 {
+		return c
+	}
+	switch {
+	case a.Author < b.Author:
+		return -1
+	case a.Author > b.Author:
+		return 1
+	case a.VersionID < b.VersionID:
+		return -1
+	case a.VersionID > b.VersionID:
+		return 1
+	}
+	return 0
+}
+
+// SortedVersions returns all versions oldest first in HLC order
+func SortedVersions(meta FileMetadata) []FileVersion {
+	out := make([]FileVersion, 0, len(meta.Versions))
+	for _, v := range meta.Versions {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return compareVersions(out[i], out[j]) < 0 })
+	return out
+}
+
+// LatestHead picks the last-writer-wins head when a file has forked
+func LatestHead(meta FileMetadata) (FileVersion, bool) {
+	var latest FileVersion
+	found := false
+	for _, id := range meta.Heads {
+		v, ok := meta.Versions[id]
+		if !ok {
+			continue
+		}
+		if !found || compareVersions(v, latest) > 0 {
+			latest, found = v, true
+		}
+	}
+	return latest, found
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFutureVersionsHeldBack: a version stamped an hour ahead must not win LWW, and
+// merges once our clock is within maxClockDrift of it
+func TestFutureVersionsHeldBack(t *testing.T) {
+	now := time.Now()
+	saved := hlcClock
+	hlcClock = &HybridClock{maxDrift: maxClockDrift, physical: func() time.Time { return now }}
+	defer func() { hlcClock = saved }()
+
+	ws := newTestWorkspace(t, "clock")
+	if err := writeTestVersion(ws, "plan.txt", "ours"); err != nil {
+		t.Fatal(err)
+	}
+	base, _ := ws.Store.Get("plan.txt")
+
+	future := FileVersion{ParentIDs: base.Heads, Author: "skewed", Timestamp: now.Add(time.Hour),
+		HLC: HLCTimestamp{WallTime: now.Add(time.Hour).UnixNano()}, Message: "from the future", CID: "c-future"}
+	future.VersionID = GenerateHash(future)
+	child := FileVersion{ParentIDs: []string{future.VersionID}, Author: "skewed", Timestamp: now.Add(time.Hour + time.Second),
+		HLC: HLCTimestamp{WallTime: now.Add(time.Hour + time.Second).UnixNano()}, Message: "built on it", CID: "c-child"}
+	child.VersionID = GenerateHash(child)
+	slightly := FileVersion{ParentIDs: base.Heads, Author: "peer", Timestamp: now.Add(time.Minute),
+		HLC: HLCTimestamp{WallTime: now.Add(time.Minute).UnixNano()}, Message: "a minute ahead", CID: "c-minute"}
+	slightly.VersionID = GenerateHash(slightly)
+
+	remote := base
+	remote.Versions = map[string]FileVersion{}
+	for id, v := range base.Versions {
+		remote.Versions[id] = v
+	}
+	for _, v := range []FileVersion{future, child, slightly} {
+		remote.Versions[v.VersionID] = v
+	}
+	remote.Heads = computeHeads(remote.Versions)
+
+	if _, err := ws.Store.MergeRemote("skewed", map[string]FileMetadata{"plan.txt": remote}); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := ws.Store.Get("plan.txt")
+	if _, ok := meta.Versions[future.VersionID]; ok {
+		t.Fatalf("version an hour ahead was merged")
+	}
+	if _, ok := meta.Versions[child.VersionID]; ok {
+		t.Fatalf("descendant of a held back version was merged")
+	}
+	if head, _ := LatestHead(meta); head.VersionID != slightly.VersionID {
+		t.Errorf("LWW head %q, want the version within the allowed drift", head.Message)
+	}
+	if hlcClock.Now().WallTime >= future.HLC.WallTime {
+		t.Errorf("clock followed the future timestamp")
+	}
+
+	// an hour later the same versions are offered again and merge
+	now = now.Add(time.Hour)
+	if _, err := ws.Store.MergeRemote("skewed", map[string]FileMetadata{"plan.txt": remote}); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ = ws.Store.Get("plan.txt")
+	if head, _ := LatestHead(meta); head.VersionID != child.VersionID {
+		t.Errorf("after catching up the head is %q, want %q", head.Message, child.Message)
+	}
+}
+
+// TestRestampedVersionIgnored: a peer resending one of our versions with a later HLC
+// can't make it win LWW over a concurrent edit
+func TestRestampedVersionIgnored(t *testing.T) {
+	ws := newTestWorkspace(t, "restamp")
+	if err := writeTestVersion(ws, "plan.txt", "base"); err != nil {
+		t.Fatal(err)
+	}
+	base, _ := ws.Store.Get("plan.txt")
+	ours := NewFileVersion("alice", "ours", contentCID([]byte("ours")), base.Heads)
+	theirs := NewFileVersion("bob", "theirs", contentCID([]byte("theirs")), base.Heads)
+	if GenerateHash(ours) == GenerateHash(FileVersion{ParentIDs: ours.ParentIDs, Author: ours.Author,
+		Timestamp: ours.Timestamp, Message: ours.Message, CID: ours.CID}) {
+		t.Errorf("HLC not part of the version ID")
+	}
+	_, err := ws.Store.Update("local", func(tx *MetadataTxn) error {
+		meta, _ := tx.Get("plan.txt")
+		meta.AddVersion(ours)
+		meta.AddVersion(theirs)
+		tx.Put(meta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := ours
+	forged.HLC = HLCTimestamp{WallTime: theirs.HLC.WallTime + 1} // still within the allowed drift
+	remote := FileMetadata{FileName: "plan.txt", Versions: map[string]FileVersion{forged.VersionID: forged}}
+	if _, err := ws.Store.MergeRemote("mallory", map[string]FileMetadata{"plan.txt": remote}); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := ws.Store.Get("plan.txt")
+	if meta.Versions[ours.VersionID].HLC != ours.HLC {
+		t.Errorf("our version restamped to %s", meta.Versions[ours.VersionID].HLC)
+	}
+	if head, _ := LatestHead(meta); head.VersionID != theirs.VersionID {
+		t.Errorf("LWW head %q, want %q", head.Message, theirs.Message)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+1. Incremental (want/have) metadata sync over /hello/2.0.0 [DONE]
+2. Exchange a Merkle style digest of the whole ledger first, stop if equal [DONE]
+3. Exchange per-file heads only when the digests differ [DONE]
+4. Send only the versions the other side is missing by walking parents [DONE]
+5. Fall back to /hello/1.1.0, then /hello/1.0.0 (whole map) for older peers [DONE]
+6. Checkpoints are part of the digest and sent when the peer lacks them, so compacting
+   changes what "in sync" means (ledgerGC.go) [DONE]
+7. Both sides end with an explicit ack of what they merged, that is what GC waits for [DONE]
+8. A "have" round bounds the delta by versions both sides know, even when each side's
+   heads are unknown to the other [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                          # internal flow of data
+
+ INITIATOR                               RESPONDER
+-----------                             -----------
+1. send {digest}                   ->   read digest
+                                   <-   send {digest}
+   digests equal? both stop here (steady state, ~100 bytes each way)
+
+2. send {heads + refs digest per file}  ->   read heads
+                                        <-   send {heads + refs digest per file}
+
+2b. send {haves per differing file}     ->   read haves       (only if both set "haves")
+                                        <-   send {haves per differing file}
+
+3. send {versions B is missing, refs}   ->   read + merge
+                                        <-   send {versions A is missing, refs}
+   read + merge
+
+4. send {ack: merged, snapshot time}    ->   record ack
+                                        <-   send {ack: merged, snapshot time}
+   record ack                               (also after step 1 when digests are equal)
+
+ "missing" = reachable from my heads, minus everything reachable from your heads and
+             haves, plus my checkpoints you do not list
+ haves     = your heads I already have, plus my versions at newest-first positions
+             0, 1, 2, 4, 8, ...: whichever of them you know bounds what you send me,
+             so a fork resends about as much as diverged, not the whole history
+ ack       = min(both snapshot times): nothing older can still be missing on either side.
+             A peer that sends none (v1, older v2) is never acked and so blocks GC
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	helloProtocolV1       = "/hello/1.0.0" // whole map, bare JSON
+	helloProtocolV1Header = "/hello/1.1.0" // whole map with the schema header (ledgerSchema.go)
+	helloProtocolV2       = "/hello/2.0.0"
+)
+
+// SyncSummary is what each side tells the other before any versions are sent
+type SyncSummary struct {
+	SchemaVersion int                 `json:"schema_version,omitempty"` // ledger schema (see ledgerSchema.go)
+	Digest        string              `json:"digest"`
+	Heads         map[string][]string `json:"heads,omitempty"`       // file name → head version IDs
+	Refs          map[string]string   `json:"refs,omitempty"`        // file name → digest of its tags/branches
+	Checkpoints   map[string][]string `json:"checkpoints,omitempty"` // file name → checkpoint version IDs (ledgerGC.go)
+	Clock         HLCTimestamp        `json:"clock"`                 // sender's physical clock, for skew warnings
+	Haves         bool                `json:"haves,omitempty"`       // sender takes part in step 2b
+}
+
+// SyncHaves lists, per file whose heads differ, version IDs the sender already has
+type SyncHaves struct {
+	Have map[string][]string `json:"have"`
+}
+
+// SyncAck closes a sync: the sender merged everything and had taken its snapshot at Through
+type SyncAck struct {
+	Merged  bool         `json:"merged"`
+	Through HLCTimestamp `json:"through"`
+}
+
+// SyncDelta carries only the versions the receiving side does not have yet
+type SyncDelta struct {
+	Versions map[string][]FileVersion `json:"versions"`       // file name → missing versions
+	Refs     map[string]SyncRefs      `json:"refs,omitempty"` // file name → tags/branches, when they differ
+}
+
+// SyncRefs carries a file's tags and branches (small, so sent whole when they differ)
+type SyncRefs struct {
+	Tags     map[string]Tag    `json:"tags,omitempty"`
+	Branches map[string]Branch `json:"branches,omitempty"`
+}
+
+// ledgerHeads returns the sorted heads of every file in the map
+func ledgerHeads(metaMap map[string]FileMetadata) map[string][]string {
+	heads := make(map[string][]string, len(metaMap))
+	for name, meta := range metaMap {
+		h := append([]string(nil), meta.Heads...)
+		sort.Strings(h)
+		heads[name] = h
+	}
+	return heads
+}
+
+// checkpointIDs returns the sorted IDs of a file's checkpoint versions
+func checkpointIDs(meta FileMetadata) []string {
+	var ids []string
+	for id, v := range meta.Versions {
+		if v.Checkpoint != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ledgerCheckpoints returns the checkpoint IDs of every file that has any
+func ledgerCheckpoints(metaMap map[string]FileMetadata) map[string][]string {
+	cps := make(map[string][]string)
+	for name, meta := range metaMap {
+		if ids := checkpointIDs(meta); len(ids) > 0 {
+			cps[name] = ids
+		}
+	}
+	return cps
+}
+
+// ledgerDigest builds a Merkle style root over the ledger:
+// leaf = sha256(file name | sorted heads | refs digest [| checkpoint IDs]), root = sha256(sorted leaves)
+func ledgerDigest(metaMap map[string]FileMetadata) string {
+	heads := ledgerHeads(metaMap)
+	names := make([]string, 0, len(heads))
+	for name := range heads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	root := sha256.New()
+	for _, name := range names {
+		data := name + "|" + strings.Join(heads[name], ",") + "|" + refsDigest(metaMap[name])
+		if cps := checkpointIDs(metaMap[name]); len(cps) > 0 {
+			data += "|" + strings.Join(cps, ",") // ledgers without checkpoints keep their digest
+		}
+		leaf := sha256.Sum256([]byte(data))
+		root.Write(leaf[:])
+	}
+	return hex.EncodeToString(root.Sum(nil))
+}
+
+// ancestorsOf returns every version reachable from the given IDs (inclusive) inside meta
+func ancestorsOf(meta FileMetadata, ids []string) map[string]struct{} {
+	seen := make(map[string]struct{})
+	stack := append([]string(nil), ids...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		v, ok := meta.Versions[id]
+		if !ok {
+			continue // we don't know this version, nothing to walk
+		}
+		seen[id] = struct{}{}
+		stack = append(stack, v.ParentIDs...)
+	}
+	return seen
+}
+
+// versionsMissingFrom walks parents from our heads and stops at anything the other side already has.
+// known are the peer's heads and haves; those we don't hold ourselves bound nothing.
+func versionsMissingFrom(meta FileMetadata, known []string) []FileVersion {
+	theirs := ancestorsOf(meta, known)
+	missing := make([]FileVersion, 0)
+	seen := make(map[string]struct{})
+	stack := append([]string(nil), meta.Heads...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if _, ok := theirs[id]; ok {
+			continue
+		}
+		v, ok := meta.Versions[id]
+		if !ok {
+			continue
+		}
+		missing = append(missing, v)
+		stack = append(stack, v.ParentIDs...)
+	}
+	return missing
+}
+
+// haveSample lists what we can prove to hold of a file: the peer's heads we know, and
+// our versions newest first at exponentially growing distances
+func haveSample(meta FileMetadata, theirHeads []string) []string {
+	var have []string
+	for _, id := range theirHeads {
+		if _, ok := meta.Versions[id]; ok {
+			have = append(have, id)
+		}
+	}
+	versions := SortedVersions(meta)
+	for pos := 0; pos < len(versions); pos = max(1, 2*pos) { // 0, 1, 2, 4, 8, ... from the newest
+		have = append(have, versions[len(versions)-1-pos].VersionID)
+	}
+	if len(versions) > 0 && versions[0].VersionID != have[len(have)-1] {
+		have = append(have, versions[0].VersionID) // the oldest one too
+	}
+	return have
+}
+
+// ledgerHaves samples every file whose heads differ from the peer's
+func ledgerHaves(metaMap map[string]FileMetadata, theirHeads map[string][]string) map[string][]string {
+	ourHeads := ledgerHeads(metaMap)
+	haves := make(map[string][]string)
+	for name, meta := range metaMap {
+		if !sameStrings(ourHeads[name], theirHeads[name]) {
+			haves[name] = haveSample(meta, theirHeads[name])
+		}
+	}
+	return haves
+}
+
+// ledgerRefs returns the refs digest of every file that has tags or branches
+func ledgerRefs(metaMap map[string]FileMetadata) map[string]string {
+	refs := make(map[string]string)
+	for name, meta := range metaMap {
+		if d := refsDigest(meta); d != "" {
+			refs[name] = d
+		}
+	}
+	return refs
+}
+
+// buildSyncDelta collects, per file, the versions the peer lacks given its heads, haves and
+// checkpoints, plus the file's refs whenever their digest differs from the peer's
+func buildSyncDelta(metaMap map[string]FileMetadata, theirs SyncSummary, theirHaves map[string][]string) SyncDelta {
+	delta := SyncDelta{Versions: make(map[string][]FileVersion), Refs: make(map[string]SyncRefs)}
+	theirHeads := theirs.Heads
+	ourHeads := ledgerHeads(metaMap)
+	for name, meta := range metaMap {
+		if d := refsDigest(meta); d != "" && d != theirs.Refs[name] {
+			delta.Refs[name] = SyncRefs{Tags: meta.Tags, Branches: meta.Branches}
+		}
+		var missing []FileVersion
+		if !sameStrings(ourHeads[name], theirHeads[name]) {
+			known := append(append([]string(nil), theirHeads[name]...), theirHaves[name]...)
+			missing = versionsMissingFrom(meta, known)
+		}
+		// heads never move on compaction, checkpoints have to be offered on their own
+		for _, id := range checkpointIDs(meta) {
+			if indexOf(theirs.Checkpoints[name], id) < 0 && !containsVersion(missing, id) {
+				missing = append(missing, meta.Versions[id])
+			}
+		}
+		if len(missing) > 0 {
+			delta.Versions[name] = missing
+		}
+	}
+	return delta
+}
+
+// deltaAsMetadataMap turns received versions into partial FileMetadata ready for MergeRemote
+func deltaAsMetadataMap(delta SyncDelta) map[string]FileMetadata {
+	remote := make(map[string]FileMetadata, len(delta.Versions))
+	for name, versions := range delta.Versions {
+		meta := FileMetadata{FileName: name, Versions: make(map[string]FileVersion)}
+		for _, v := range versions {
+			meta.Versions[v.VersionID] = v
+		}
+		remote[name] = meta
+	}
+	for name, refs := range delta.Refs {
+		meta, ok := remote[name]
+		if !ok {
+			meta = FileMetadata{FileName: name, Versions: make(map[string]FileVersion)}
+		}
+		meta.Tags, meta.Branches = refs.Tags, refs.Branches
+		remote[name] = meta
+	}
+	return remote
+}
+
+func containsVersion(versions []FileVersion, id string) bool {
+	for _, v := range versions {
+		if v.VersionID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSONLine(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("[incrementalSync][writeJSONLine] JSON marshal error: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("[incrementalSync][writeJSONLine] Stream write error: %w", err)
+	}
+	return nil
+}
+
+func readJSONLine(r *bufio.Reader, v interface{}) error {
+	raw, err := r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("[incrementalSync][readJSONLine] Stream read error: %w", err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("[incrementalSync][readJSONLine] JSON unmarshal error: %w", err)
+	}
+	return nil
+}
+
+// exchangeJSON sends ours and reads theirs; the initiator always writes first so both sides never block on a read
+func exchangeJSON(rw io.ReadWriter, r *bufio.Reader, initiator bool, ours, theirs interface{}) error {
+	if initiator {
+		if err := writeJSONLine(rw, ours); err != nil {
+			return err
+		}
+		return readJSONLine(r, theirs)
+	}
+	if err := readJSONLine(r, theirs); err != nil {
+		return err
+	}
+	return writeJSONLine(rw, ours)
+}
+
+// runIncrementalSync runs the /hello/2.0.0 exchange on an open stream and merges what the peer sent.
+// It returns the names of files that received new versions.
+func runIncrementalSync(ws *Workspace, rw io.ReadWriter, initiator bool, source string) ([]string, error) {
+	r := bufio.NewReader(rw)
+	started := hlcClock.Now()
+	local := ws.Store.Snapshot()
+
+	// 1️⃣ digest only
+	ours := SyncSummary{SchemaVersion: currentLedgerSchema, Digest: ledgerDigest(local), Clock: hlcClock.physicalNow()}
+	var theirs SyncSummary
+	if err := exchangeJSON(rw, r, initiator, ours, &theirs); err != nil {
+		return nil, err
+	}
+	if err := checkPeerSchema(theirs.SchemaVersion, source); err != nil {
+		return nil, err
+	}
+	checkPeerClock(theirs.Clock, source)
+	if ours.Digest == theirs.Digest {
+		log.Printf("[CRDT][runIncrementalSync] Ledgers already in sync (digest %s)", shortID(ours.Digest))
+		exchangeAck(ws, rw, r, initiator, source, started)
+		return nil, nil
+	}
+
+	// 2️⃣ heads per file
+	ours = SyncSummary{SchemaVersion: currentLedgerSchema, Digest: ours.Digest, Heads: ledgerHeads(local), Refs: ledgerRefs(local), Checkpoints: ledgerCheckpoints(local), Haves: true}
+	if err := exchangeJSON(rw, r, initiator, ours, &theirs); err != nil {
+		return nil, err
+	}
+
+	// 2️⃣b what each side already has of the files that differ (older v2 peers skip it)
+	var theirHaves SyncHaves
+	if theirs.Haves {
+		if err := exchangeJSON(rw, r, initiator, SyncHaves{Have: ledgerHaves(local, theirs.Heads)}, &theirHaves); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3️⃣ only the versions the other side is missing
+	outgoing := buildSyncDelta(local, theirs, theirHaves.Have)
+	var incoming SyncDelta
+	if err := exchangeJSON(rw, r, initiator, outgoing, &incoming); err != nil {
+		return nil, err
+	}
+
+	changed, err := ws.Store.MergeRemote(source, deltaAsMetadataMap(incoming))
+	if err != nil {
+		return nil, err
+	}
+	// 4️⃣ both directions merged: acknowledge it explicitly (see ledgerGC.go)
+	exchangeAck(ws, rw, r, initiator, source, started)
+	log.Printf("[CRDT][runIncrementalSync] Sent versions for %d file(s), merged versions for %d file(s)",
+		len(outgoing.Versions), len(changed))
+	return changed, nil
+}
+
+// exchangeAck sends our ack and records the peer's; a peer that sends none is not acked
+func exchangeAck(ws *Workspace, rw io.ReadWriter, r *bufio.Reader, initiator bool, source string, started HLCTimestamp) {
+	var theirs SyncAck
+	if err := exchangeJSON(rw, r, initiator, SyncAck{Merged: true, Through: started}, &theirs); err != nil || !theirs.Merged {
+		log.Printf("[CRDT][runIncrementalSync] No ack from %s (older node?), GC keeps waiting for it", shortID(source))
+		return
+	}
+	through := started
+	if theirs.Through.Compare(through) < 0 {
+		through = theirs.Through
+	}
+	ws.Acks.Record(source, through)
+}
+
+// shortID trims a hash for log output
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+// TestSyncDeltaBoundedByHaves: after a fork neither side knows the other's head, yet each
+// sends only its own new version instead of the whole shared history
+func TestSyncDeltaBoundedByHaves(t *testing.T) {
+	a, b := newTestWorkspace(t, "a"), newTestWorkspace(t, "b")
+	for i := 0; i < 50; i++ {
+		if err := writeTestVersion(a, "shared.txt", string(rune('A'+i%26))+"-shared"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syncOverPipe(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestVersion(a, "shared.txt", "a's edit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestVersion(b, "shared.txt", "b's edit"); err != nil {
+		t.Fatal(err)
+	}
+
+	la, lb := a.Store.Snapshot(), b.Store.Snapshot()
+	summary := func(local map[string]FileMetadata) SyncSummary {
+		return SyncSummary{Heads: ledgerHeads(local), Refs: ledgerRefs(local), Checkpoints: ledgerCheckpoints(local)}
+	}
+	if without := buildSyncDelta(la, summary(lb), nil); len(without.Versions["shared.txt"]) != 51 {
+		t.Fatalf("heads alone: %d versions, expected the whole history", len(without.Versions["shared.txt"]))
+	}
+	toB := buildSyncDelta(la, summary(lb), ledgerHaves(lb, summary(la).Heads))
+	toA := buildSyncDelta(lb, summary(la), ledgerHaves(la, summary(lb).Heads))
+	if n := len(toB.Versions["shared.txt"]); n != 1 {
+		t.Errorf("a sends %d versions to b, want 1", n)
+	}
+	if n := len(toA.Versions["shared.txt"]); n != 1 {
+		t.Errorf("b sends %d versions to a, want 1", n)
+	}
+
+	if err := syncOverPipe(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if ledgerDigest(a.Store.Snapshot()) != ledgerDigest(b.Store.Snapshot()) {
+		t.Errorf("ledgers differ after the sync")
+	}
+}
+
+// TestHaveSamplePositions: newest first at 0, 1, 2, 4, 8, then the oldest
+func TestHaveSamplePositions(t *testing.T) {
+	meta, chain := testChain("notes.txt", 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	var want []string
+	for _, pos := range []int{0, 1, 2, 4, 8, 9} {
+		want = append(want, chain[len(chain)-1-pos].VersionID)
+	}
+	if got := haveSample(meta, nil); !sameStrings(got, want) {
+		t.Errorf("sample %v, want %v", got, want)
+	}
+}
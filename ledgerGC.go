@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Garbage collection / history pruning for the metadata ledger
+
+1. Track, per peer, the explicit ack it sent at the end of its last two way sync
+   (incrementalSync.go step 4) [DONE]
+2. Safe horizon = retention horizon, but only once every known peer acked after it [DONE]
+3. Squash versions older than the horizon into one checkpoint version per file [DONE]
+4. Peers receiving a checkpoint drop the same versions (applied inside MergeFileMetadata) [DONE]
+5. gc          report what would be dropped and who is blocking it [DONE]
+   gc apply    do it [DONE]
+6. -auto-gc compaction mode running periodically [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- "known peers" = members of the workspace + every other author in its ledger. A peer that
+  wrote versions but never synced with us again blocks GC, on purpose.
+- An ack at time T means: at T we had everything the peer had and the other way round,
+  so nothing created before T can still show up with a parent we'd have dropped.
+- HLC is monotonic along parent edges, so "older than the horizon" is closed under
+  ancestors and the squashed set never leaves a dangling middle.
+- Heads, tag targets and branch targets are never squashed; their parents are
+  re-pointed to the checkpoint instead. Nothing after a tag or branch target is
+  squashed either: the target keeps its children and never turns into a second head.
+- The checkpoint ID only depends on the squashed IDs, so two peers compacting the same
+  history produce the same checkpoint and converge.
+- Peers compacting with different horizons produce overlapping checkpoints. The one with
+  the later horizon (then more squashed, then higher ID) absorbs the other, so every
+  node ends up with the same single checkpoint. A checkpoint squashing an older one
+  also lists what that one squashed, so the old one is recognised wherever it turns up.
+- Checkpoints are in the /hello digest and sent explicitly, heads alone never change.
+- A received checkpoint is checked against the rules planCheckpoint follows (ID from the
+  squashed IDs, horizon not in our future, nothing squashed at or after the horizon, no
+  head, ref target or descendant of one squashed) and dropped if it breaks any of them,
+  so a member cannot gossip history away. The sender offers it again on the next sync.
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const gcAcksFile = ".peerlink/gc-acks.json"
+
+// Checkpoint is set on versions that replace a squashed stretch of history
+type Checkpoint struct {
+	Horizon  HLCTimestamp `json:"horizon"`
+	Squashed []string     `json:"squashed"` // version IDs this checkpoint replaces
+}
+
+type syncAckBook struct {
+	mu   sync.Mutex
+	acks map[string]HLCTimestamp // peer ID → what its last explicit ack covers (incrementalSync.go)
+	path string
+}
+
+// gcRetention is how old a version must be before gc may squash it
+var gcRetention = 90 * 24 * time.Hour
+
+func loadSyncAcks(path string) *syncAckBook {
+	book := &syncAckBook{acks: make(map[string]HLCTimestamp), path: path}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &book.acks); err != nil {
+			log.Printf("[gc][loadSyncAcks] Ignoring unreadable %s: %v", path, err)
+		}
+	}
+	return book
+}
+
+// Record stores that peerID acknowledged having merged everything up to ts
+func (b *syncAckBook) Record(peerID string, ts HLCTimestamp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.acks[peerID].Compare(ts) >= 0 {
+		return
+	}
+	b.acks[peerID] = ts
+	if err := os.MkdirAll(filepath.Dir(b.path), os.ModePerm); err != nil {
+		log.Printf("[gc][Record] %v", err)
+		return
+	}
+	data, _ := json.MarshalIndent(b.acks, "", "  ")
+	if err := os.WriteFile(b.path, data, 0644); err != nil {
+		log.Printf("[gc][Record] Failed to persist acks: %v", err)
+	}
+}
+
+func (b *syncAckBook) Get(peerID string) (HLCTimestamp, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ts, ok := b.acks[peerID]
+	return ts, ok
+}
+
+// knownPeerIDs is every peer of the workspace that must ack before we drop history
+func knownPeerIDs(ws *Workspace, metaMap map[string]FileMetadata) []string {
+	self := node.ID().String()
+	set := make(map[string]struct{})
+	for _, id := range ws.Members() {
+		set[id] = struct{}{}
+	}
+	for _, meta := range metaMap {
+		for _, v := range meta.Versions {
+			if v.Author != "" && v.Checkpoint == nil {
+				set[v.Author] = struct{}{}
+			}
+		}
+	}
+	delete(set, self)
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// safeHorizon returns the horizon all known peers have acknowledged, and who blocks it
+func safeHorizon(ws *Workspace, metaMap map[string]FileMetadata, now time.Time) (HLCTimestamp, []string) {
+	horizon := HLCTimestamp{WallTime: now.Add(-gcRetention).UnixNano()}
+	var blockers []string
+	for _, id := range knownPeerIDs(ws, metaMap) {
+		ack, ok := ws.Acks.Get(id)
+		if !ok || ack.Compare(horizon) < 0 {
+			blockers = append(blockers, id)
+		}
+	}
+	return horizon, blockers
+}
+
+// protectedVersions are never squashed: heads, anything a tag or branch points to, and
+// every descendant of those (squashing them would leave the target without children)
+func protectedVersions(meta FileMetadata) map[string]struct{} {
+	keep := make(map[string]struct{})
+	for _, h := range meta.Heads {
+		keep[h] = struct{}{}
+	}
+	var targets []string
+	for _, t := range meta.Tags {
+		targets = append(targets, t.VersionID)
+	}
+	for _, b := range meta.Branches {
+		targets = append(targets, b.VersionID)
+	}
+	children := make(map[string][]string)
+	for id, v := range meta.Versions {
+		for _, p := range v.ParentIDs {
+			children[p] = append(children[p], id)
+		}
+	}
+	for len(targets) > 0 {
+		id := targets[len(targets)-1]
+		targets = targets[:len(targets)-1]
+		if _, seen := keep[id]; seen {
+			continue
+		}
+		keep[id] = struct{}{}
+		targets = append(targets, children[id]...)
+	}
+	return keep
+}
+
+// planCheckpoint picks the versions to squash and builds the checkpoint, ok=false if not worth it
+func planCheckpoint(meta FileMetadata, horizon HLCTimestamp) (FileVersion, bool) {
+	keep := protectedVersions(meta)
+	var squashed []FileVersion
+	for id, v := range meta.Versions {
+		if _, protected := keep[id]; protected {
+			continue
+		}
+		if v.orderKey().Compare(horizon) < 0 {
+			squashed = append(squashed, v)
+		}
+	}
+	if len(squashed) < 2 {
+		return FileVersion{}, false // replacing one version with one checkpoint gains nothing
+	}
+	sort.Slice(squashed, func(i, j int) bool { return compareVersions(squashed[i], squashed[j]) < 0 })
+	newest := squashed[len(squashed)-1]
+
+	var ids []string
+	for _, v := range squashed {
+		ids = append(ids, v.VersionID)
+		if v.Checkpoint != nil {
+			ids = append(ids, v.Checkpoint.Squashed...) // flattened, see absorbCheckpoints
+		}
+	}
+	sort.Strings(ids)
+	ids = dedupeSorted(ids)
+
+	return FileVersion{
+		VersionID:  checkpointID(ids),
+		Author:     newest.Author,
+		Timestamp:  newest.Timestamp,
+		HLC:        newest.orderKey(),
+		Message:    fmt.Sprintf("checkpoint of %d versions before %s", len(ids), horizon),
+		CID:        newest.CID,
+		Tree:       newest.Tree, // a snapshot's checkpoint still names its tree (snapshots.go)
+		Deleted:    newest.Deleted,
+		Checkpoint: &Checkpoint{Horizon: horizon, Squashed: ids},
+	}, true
+}
+
+// checkpointID only depends on the sorted squashed IDs, so every peer derives the same one
+func checkpointID(squashed []string) string {
+	sum := sha256.Sum256([]byte("checkpoint|" + strings.Join(squashed, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkCheckpoint verifies a checkpoint (ours or a peer's) the way planCheckpoint builds one
+func checkCheckpoint(meta FileMetadata, protected map[string]struct{}, cp FileVersion) error {
+	horizon := cp.Checkpoint.Horizon
+	ids := cp.Checkpoint.Squashed
+	switch {
+	case len(ids) < 2:
+		return fmt.Errorf("squashes %d version(s)", len(ids))
+	case !sort.StringsAreSorted(ids) || len(dedupeSorted(ids)) != len(ids):
+		return fmt.Errorf("squashed IDs are not sorted and unique")
+	case checkpointID(ids) != cp.VersionID:
+		return fmt.Errorf("ID does not match its squashed IDs")
+	case cp.orderKey().Compare(horizon) >= 0:
+		return fmt.Errorf("stamped %s, not before its horizon %s", cp.orderKey(), horizon)
+	}
+	if drift, ahead := hlcClock.aheadBy(horizon); ahead {
+		return fmt.Errorf("horizon %s is %s ahead of our clock", horizon, drift.Round(time.Second))
+	}
+	for _, id := range ids {
+		v, ok := meta.Versions[id]
+		if !ok {
+			continue // already dropped, or never seen here
+		}
+		if v.orderKey().Compare(horizon) >= 0 {
+			return fmt.Errorf("squashes %s, which is not older than the horizon", shortID(id))
+		}
+		if _, ok := protected[id]; ok {
+			return fmt.Errorf("squashes %s, a head, ref target or descendant of one", shortID(id))
+		}
+	}
+	return nil
+}
+
+// dropInvalidCheckpoints removes checkpoints that fail checkCheckpoint before any is applied
+func dropInvalidCheckpoints(meta *FileMetadata) {
+	protected := protectedVersions(*meta)
+	for id, v := range meta.Versions {
+		if v.Checkpoint == nil {
+			continue
+		}
+		if err := checkCheckpoint(*meta, protected, v); err != nil {
+			log.Printf("[gc][dropInvalidCheckpoints] Rejecting checkpoint %s of %s: %v", shortID(id), meta.FileName, err)
+			delete(meta.Versions, id)
+			meta.Heads = computeHeads(meta.Versions)
+		}
+	}
+}
+
+func dedupeSorted(ids []string) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if len(out) == 0 || out[len(out)-1] != id {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// absorbCheckpoints maps every squashed version to the checkpoint that replaces it.
+// Overlapping checkpoints come from peers compacting with different horizons: the one
+// with the later horizon wins and replaces the other, so all nodes keep the same one.
+func absorbCheckpoints(meta FileMetadata) map[string]string {
+	var cps []FileVersion
+	for _, v := range meta.Versions {
+		if v.Checkpoint != nil {
+			cps = append(cps, v)
+		}
+	}
+	sort.Slice(cps, func(i, j int) bool {
+		a, b := cps[i].Checkpoint, cps[j].Checkpoint
+		if c := a.Horizon.Compare(b.Horizon); c != 0 {
+			return c > 0
+		}
+		if len(a.Squashed) != len(b.Squashed) {
+			return len(a.Squashed) > len(b.Squashed)
+		}
+		return cps[i].VersionID > cps[j].VersionID
+	})
+
+	replacedBy := make(map[string]string)
+	for _, cp := range cps {
+		owner := cp.VersionID
+		for _, old := range cp.Checkpoint.Squashed {
+			if winner, taken := replacedBy[old]; taken && winner != cp.VersionID {
+				owner = winner // overlaps a stronger checkpoint
+				break
+			}
+		}
+		if owner != cp.VersionID {
+			if _, squashed := replacedBy[cp.VersionID]; !squashed {
+				replacedBy[cp.VersionID] = owner
+			}
+		}
+		for _, old := range cp.Checkpoint.Squashed {
+			if _, taken := replacedBy[old]; !taken {
+				replacedBy[old] = owner
+			}
+		}
+	}
+	return replacedBy
+}
+
+// applyCheckpoints removes every version a checkpoint replaced and re-points orphaned parents.
+// Called from MergeFileMetadata so peers that receive a checkpoint prune the same versions.
+func applyCheckpoints(meta *FileMetadata) {
+	dropInvalidCheckpoints(meta)
+	replacedBy := absorbCheckpoints(*meta)
+	if len(replacedBy) == 0 {
+		return
+	}
+	// a checkpoint can itself be squashed by a later one, follow the chain
+	resolve := func(id string) string {
+		for i := 0; i < len(replacedBy); i++ {
+			next, ok := replacedBy[id]
+			if !ok {
+				break
+			}
+			id = next
+		}
+		return id
+	}
+
+	for old := range replacedBy {
+		delete(meta.Versions, old)
+	}
+	for id, v := range meta.Versions {
+		var parents []string
+		changed := false
+		for _, p := range v.ParentIDs {
+			np := resolve(p)
+			changed = changed || np != p
+			if np != id && indexOf(parents, np) < 0 {
+				parents = append(parents, np)
+			}
+		}
+		if changed {
+			v.ParentIDs = parents
+			meta.Versions[id] = v
+		}
+	}
+	meta.Heads = computeHeads(meta.Versions)
+}
+
+// cmdGC reports (and with apply, performs) a compaction of the ledger
+func cmdGC(ws *Workspace, apply bool) error {
+	snapshot := ws.Store.Snapshot()
+	horizon, blockers := safeHorizon(ws, snapshot, time.Now())
+
+	plans := make(map[string]FileVersion)
+	total := 0
+	for name, meta := range snapshot {
+		if cp, ok := planCheckpoint(meta, horizon); ok {
+			plans[name] = cp
+			total += len(cp.Checkpoint.Squashed)
+		}
+	}
+
+	fmt.Printf("🧹 GC horizon %s (retention %s)\n", horizon, gcRetention)
+	names := make([]string, 0, len(plans))
+	for name := range plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("   %s: would squash %d of %d versions into checkpoint %s\n",
+			name, len(plans[name].Checkpoint.Squashed), len(snapshot[name].Versions), shortID(plans[name].VersionID))
+	}
+	if total == 0 {
+		fmt.Println("   nothing to drop")
+		return nil
+	}
+	if len(blockers) > 0 {
+		fmt.Println("   ⏳ waiting for these peers to sync after the horizon:")
+		for _, id := range blockers {
+			fmt.Printf("      - %s\n", id)
+		}
+		return nil
+	}
+	if !apply {
+		fmt.Println("   run 'gc apply' to drop them")
+		return nil
+	}
+
+	_, err := ws.Store.Update("gc", func(tx *MetadataTxn) error {
+		for name, cp := range plans {
+			meta, ok := tx.Get(name)
+			if !ok {
+				continue
+			}
+			meta.Versions[cp.VersionID] = cp
+			applyCheckpoints(&meta)
+			tx.Put(meta)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Dropped %d versions across %d file(s)\n", total, len(plans))
+
+	if _, err := ws.Objects.Prune(ws.Store.Snapshot(), false); err != nil {
+		log.Printf("[gc][cmdGC] Object prune failed: %v", err)
+	}
+	return nil
+}
+
+// startAutoCompaction runs `gc apply` quietly on an interval (compaction mode)
+func startAutoCompaction(ctx context.Context, ws *Workspace, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			snapshot := ws.Store.Snapshot()
+			if _, blockers := safeHorizon(ws, snapshot, time.Now()); len(blockers) > 0 {
+				log.Printf("[gc][startAutoCompaction] Skipping %s, %d peer(s) have not acked the horizon", ws.Name, len(blockers))
+				continue
+			}
+			printLock.Lock()
+			if err := cmdGC(ws, true); err != nil {
+				log.Printf("[gc][startAutoCompaction] %v", err)
+			}
+			printLock.Unlock()
+		}
+	}()
+}
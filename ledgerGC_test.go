@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testChain builds a linear history whose versions have the given HLC wall times, oldest first
+func testChain(name string, walls ...int64) (FileMetadata, []FileVersion) {
+	meta := FileMetadata{FileName: name, Versions: make(map[string]FileVersion)}
+	var chain []FileVersion
+	var parents []string
+	for i, wall := range walls {
+		v := NewFileVersion("peer", string(rune('a'+i)), contentCID([]byte{byte(i)}), parents)
+		v.HLC = HLCTimestamp{WallTime: wall}
+		meta.AddVersion(v)
+		chain = append(chain, v)
+		parents = []string{v.VersionID}
+	}
+	return meta, chain
+}
+
+// TestCheckpointKeepsTaggedVersionInline: v0→v1→v2(tagged)→v3→v4 with the horizon between v3
+// and v4 squashes v0 and v1 only, so the tag target keeps its child and v4 stays the only head
+func TestCheckpointKeepsTaggedVersionInline(t *testing.T) {
+	meta, v := testChain("notes.txt", 100, 200, 300, 400, 500)
+	meta.Tags = map[string]Tag{"release": {Name: "release", VersionID: v[2].VersionID}}
+
+	cp, ok := planCheckpoint(meta, HLCTimestamp{WallTime: 450})
+	if !ok {
+		t.Fatal("nothing planned")
+	}
+	if want := []string{v[0].VersionID, v[1].VersionID}; !sameStrings(cp.Checkpoint.Squashed, sortedCopy(want)) {
+		t.Fatalf("squashed %v, want %v", cp.Checkpoint.Squashed, want)
+	}
+	meta.Versions[cp.VersionID] = cp
+	applyCheckpoints(&meta)
+
+	if !reflect.DeepEqual(meta.Heads, []string{v[4].VersionID}) {
+		t.Errorf("heads %v, want only v4", meta.Heads)
+	}
+	for _, kept := range v[2:] {
+		if _, ok := meta.Versions[kept.VersionID]; !ok {
+			t.Errorf("%s squashed", kept.Message)
+		}
+	}
+	if got := meta.Versions[v[2].VersionID].ParentIDs; !reflect.DeepEqual(got, []string{cp.VersionID}) {
+		t.Errorf("tag target's parents %v, want the checkpoint", got)
+	}
+}
+
+func sortedCopy(ids []string) []string {
+	out := append([]string(nil), ids...)
+	sort.Strings(out)
+	return out
+}
+
+// TestRemoteCheckpointsValidated: a received checkpoint is applied only if it is one
+// planCheckpoint could have built
+func TestRemoteCheckpointsValidated(t *testing.T) {
+	local, v := testChain("notes.txt", 100, 200, 300, 400)
+	forge := func(horizon int64, ids ...string) FileVersion {
+		ids = sortedCopy(ids)
+		return FileVersion{VersionID: checkpointID(ids), Author: "mallory", HLC: HLCTimestamp{WallTime: 50},
+			Checkpoint: &Checkpoint{Horizon: HLCTimestamp{WallTime: horizon}, Squashed: ids}}
+	}
+	wrongID := forge(250, v[0].VersionID, v[1].VersionID)
+	wrongID.VersionID = contentCID([]byte("whatever"))
+	cases := map[string]FileVersion{
+		"squashes the head":        forge(1000, v[0].VersionID, v[1].VersionID, v[2].VersionID, v[3].VersionID),
+		"squashes past horizon":    forge(250, v[0].VersionID, v[2].VersionID),
+		"ID not from its squashed": wrongID,
+		"horizon in the future":    forge(hlcClock.physicalNow().WallTime*2, v[0].VersionID, v[1].VersionID),
+	}
+	for name, cp := range cases {
+		remote := FileMetadata{FileName: "notes.txt", Versions: map[string]FileVersion{cp.VersionID: cp}}
+		merged := MergeFileMetadata(local.Clone(), remote)
+		if len(merged.Versions) != len(local.Versions) || !reflect.DeepEqual(merged.Heads, local.Heads) {
+			t.Errorf("%s: checkpoint applied, %d versions, heads %v", name, len(merged.Versions), merged.Heads)
+		}
+	}
+
+	good := forge(250, v[0].VersionID, v[1].VersionID)
+	remote := FileMetadata{FileName: "notes.txt", Versions: map[string]FileVersion{good.VersionID: good}}
+	merged := MergeFileMetadata(local.Clone(), remote)
+	if _, ok := merged.Versions[good.VersionID]; !ok || len(merged.Versions) != 3 {
+		t.Errorf("valid checkpoint not applied: %d versions", len(merged.Versions))
+	}
+	if !reflect.DeepEqual(merged.Heads, []string{v[3].VersionID}) {
+		t.Errorf("heads %v after a valid checkpoint", merged.Heads)
+	}
+}
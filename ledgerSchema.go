@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+1. Header on the on-disk ledger: schema version, node ID, checksum [DONE]
+2. Same header on the /hello/1.1.0 payload, schema version in the /hello/2.0.0 summary [DONE]
+   (/hello/1.0.0 stays the bare map, it is what pre-header peers fall back to)
+3. Migration framework that upgrades older ledgers step by step on load [DONE]
+4. Clear error when a ledger or a peer uses a newer schema than this build [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                            # SCHEMA HISTORY
+
+ 1   bare JSON map  file name → FileMetadata (no header)
+ 2   header { schema_version, node_id, checksum, files }
+
+ To change FileVersion/FileMetadata in an incompatible way: bump
+ currentLedgerSchema and register ledgerMigrations[old] that rewrites the raw JSON.
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const currentLedgerSchema = 2
+
+// ErrNewerSchema is returned when a ledger or peer is ahead of this build
+var ErrNewerSchema = errors.New("ledger schema is newer than this peerlink build understands, please upgrade")
+
+// LedgerEnvelope is the on-disk ledger and the /hello/1.1.0 payload (/hello/1.0.0 still sends the bare map)
+type LedgerEnvelope struct {
+	SchemaVersion int                     `json:"schema_version"`
+	NodeID        string                  `json:"node_id"`
+	Checksum      string                  `json:"checksum"` // sha256 of the compact JSON of Files
+	Files         map[string]FileMetadata `json:"files"`
+}
+
+// rawLedger is a ledger whose files haven't been decoded yet, which is what migrations work on
+type rawLedger struct {
+	SchemaVersion int             `json:"schema_version"`
+	NodeID        string          `json:"node_id"`
+	Checksum      string          `json:"checksum"`
+	Files         json.RawMessage `json:"files"`
+}
+
+// ledgerMigrations[n] upgrades a schema n ledger to schema n+1
+var ledgerMigrations = map[int]func(rawLedger) (rawLedger, error){
+	1: func(l rawLedger) (rawLedger, error) {
+		// v1 had no header, the files map is unchanged
+		l.SchemaVersion = 2
+		l.Checksum = ""
+		return l, nil
+	},
+}
+
+func ledgerNodeID() string {
+	if node == nil {
+		return ""
+	}
+	return node.ID().String()
+}
+
+func ledgerChecksum(rawFiles []byte) (string, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, rawFiles); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// encodeLedger wraps the map in a current-schema header
+func encodeLedger(metaMap map[string]FileMetadata, indent bool) ([]byte, error) {
+	files, err := json.Marshal(metaMap)
+	if err != nil {
+		return nil, fmt.Errorf("[ledgerSchema][encodeLedger] marshal files: %w", err)
+	}
+	checksum, err := ledgerChecksum(files)
+	if err != nil {
+		return nil, err
+	}
+	env := rawLedger{SchemaVersion: currentLedgerSchema, NodeID: ledgerNodeID(), Checksum: checksum, Files: files}
+	if indent {
+		return json.MarshalIndent(env, "", "  ")
+	}
+	return json.Marshal(env)
+}
+
+// decodeLedger accepts any known schema (including the bare v1 map), verifies the
+// checksum, runs migrations and returns the files plus the header as it now stands
+func decodeLedger(data []byte) (LedgerEnvelope, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] not a JSON object: %w", err)
+	}
+
+	var raw rawLedger
+	if _, hasHeader := probe["schema_version"]; hasHeader {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] bad header: %w", err)
+		}
+	} else {
+		raw = rawLedger{SchemaVersion: 1, Files: data}
+	}
+
+	if raw.SchemaVersion > currentLedgerSchema {
+		return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] schema %d from node %q, this build supports up to %d: %w",
+			raw.SchemaVersion, raw.NodeID, currentLedgerSchema, ErrNewerSchema)
+	}
+	if raw.Checksum != "" {
+		sum, err := ledgerChecksum(raw.Files)
+		if err != nil {
+			return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] unreadable files section: %w", err)
+		}
+		if sum != raw.Checksum {
+			return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] checksum mismatch (ledger corrupted?)")
+		}
+	}
+
+	for raw.SchemaVersion < currentLedgerSchema {
+		migrate, ok := ledgerMigrations[raw.SchemaVersion]
+		if !ok {
+			return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] no migration from schema %d", raw.SchemaVersion)
+		}
+		from := raw.SchemaVersion
+		var err error
+		if raw, err = migrate(raw); err != nil {
+			return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] migration %d→%d failed: %w", from, from+1, err)
+		}
+		log.Printf("[ledgerSchema][decodeLedger] Migrated ledger from schema %d to %d", from, raw.SchemaVersion)
+	}
+
+	env := LedgerEnvelope{SchemaVersion: raw.SchemaVersion, NodeID: raw.NodeID, Checksum: raw.Checksum,
+		Files: make(map[string]FileMetadata)}
+	if len(raw.Files) > 0 && string(raw.Files) != "null" {
+		if err := json.Unmarshal(raw.Files, &env.Files); err != nil {
+			return LedgerEnvelope{}, fmt.Errorf("[ledgerSchema][decodeLedger] bad files section: %w", err)
+		}
+	}
+	return env, nil
+}
+
+// checkPeerSchema is used by /hello/2.0.0 where the schema travels in the summary
+func checkPeerSchema(peerSchema int, peerID string) error {
+	if peerSchema > currentLedgerSchema {
+		return fmt.Errorf("[ledgerSchema][checkPeerSchema] peer %s speaks schema %d, we support up to %d: %w",
+			peerID, peerSchema, currentLedgerSchema, ErrNewerSchema)
+	}
+	return nil
+}
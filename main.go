@@ -8,9 +8,11 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"log"
 	"os"
 	"runtime/debug"
+	"strings"
 	"time"
 )
 
@@ -22,18 +24,24 @@ import (
 
 3 run target node [DONE]
 	- registers stream handlers on your node.
-	- registers /hello/1.0.0 → CRDT Metadata sync.
+	- registers /hello/2.0.0 → incremental CRDT Metadata sync (see incrementalSync.go).
+	- registers /hello/1.1.0 → full map CRDT Metadata sync with the schema header (see ledgerSchema.go).
+	- registers /hello/1.0.0 → full map CRDT Metadata sync, bare map (fallback for older peers).
 	- registers /file-transfer/1.0.0 → File download.
+	- registers /listing/1.0.0 → full file listing for peers that only got its digest.
 	- Returns peer address info for advertisement.
+	- called once per workspace, protocols other than the default's are prefixed
+	  with /peerlink/ws/<id> (see workspace.go).
 
 4 run source node [DONE]
 	- initiates metadata sync with a specific peer.
 	- connects to the target peer.
-	- opens a /hello/1.0.0 stream.
-	- sends your local file metadata map.
+	- opens a /hello/2.0.0 stream (falls back to /hello/1.0.0).
+	- v2: exchanges digests/heads and only the missing versions.
+	- v1: sends your local file metadata map.
 	- receives peer’s metadata map.
 	- merges remote and local metadata using MergeFileMetadata.
-	- Saves merged metadata to disk (sync-metadata.json).
+	- Saves merged metadata to disk through the workspace's MetadataStore.
 
 5 read hello protocol [DONE]
 	- handle metadata received from a peer when they initiate sync.
@@ -44,20 +52,37 @@ import (
 
 */
 
-func createNode() host.Host {
-	node, err := libp2p.New()
+// createNode starts the libp2p host with the NAT/relay options in cfg (see natTraversal.go)
+func createNode(cfg NodeConfig) host.Host {
+	node, err := libp2p.New(nodeOptions(cfg)...)
 	if err != nil {
 		log.Fatalf("[INIT][createNode] Error creating node: %s", err.Error())
 	}
 	return node
 }
 
-func runTargetNode(h host.Host) peer.AddrInfo {
-	log.Printf("[Stream][runTargetNode] Registering handlers for Peer ID '%s'", h.ID().String())
+func runTargetNode(h host.Host, ws *Workspace) peer.AddrInfo {
+	log.Printf("[Stream][runTargetNode] Registering handlers of workspace %s for Peer ID '%s'", ws.Name, h.ID().String())
 
-	h.SetStreamHandler("/hello/1.0.0", func(s network.Stream) {
-		log.Println("[Stream][/hello] Incoming stream")
-		err := readHelloProtocol(s)
+	registerAuthHandler(h, ws) // every handler below only serves verified members (workspaceAuth.go)
+
+	h.SetStreamHandler(ws.Protocol(helloProtocolV2), ws.guarded(func(s network.Stream) {
+		log.Printf("[Stream][/hello/2.0.0] Incoming stream (%s)", ws.Name)
+		// the store persists merged versions itself
+		if _, err := runIncrementalSync(ws, s, false, s.Conn().RemotePeer().String()); err != nil {
+			log.Printf("[Stream][/hello/2.0.0] Incremental sync failed: %s", err.Error())
+			_ = s.Reset()
+			return
+		}
+		ws.notePeer(s.Conn().RemotePeer().String(), true)
+		_ = s.Close()
+	}))
+	log.Printf("[Stream] Handler registered for %s", ws.Protocol(helloProtocolV2))
+
+	// whole map exchange: 1.1.0 carries the schema header, 1.0.0 the bare map pre-header peers read
+	helloV1 := ws.guarded(func(s network.Stream) {
+		log.Printf("[Stream][/hello] Incoming stream %s (%s)", s.Protocol(), ws.Name)
+		err := readHelloProtocol(ws, s)
 		if err != nil {
 			log.Printf("[Stream][/hello] Metadata read failed: %s", err.Error())
 			err := s.Reset()
@@ -71,26 +96,37 @@ func runTargetNode(h host.Host) peer.AddrInfo {
 			}
 		}
 	})
-	log.Println("[Stream] Handler registered for /hello/1.0.0")
+	for _, proto := range []protocol.ID{helloProtocolV1Header, helloProtocolV1} {
+		h.SetStreamHandler(ws.Protocol(proto), helloV1)
+		log.Printf("[Stream] Handler registered for %s", ws.Protocol(proto))
+	}
 
-	h.SetStreamHandler("/file-transfer/1.0.0", func(s network.Stream) {
-		log.Printf("[Stream][/file-transfer] Stream received from %s", s.Conn().RemotePeer())
-		handleFileRequest(s)
-	})
-	log.Println("[Stream] Handler registered for /file-transfer/1.0.0")
+	h.SetStreamHandler(ws.Protocol(fileTransferProtocol), ws.guarded(func(s network.Stream) {
+		log.Printf("[Stream][/file-transfer] Stream received from %s (%s)", s.Conn().RemotePeer(), ws.Name)
+		handleFileRequest(ws, s)
+	}))
+	log.Printf("[Stream] Handler registered for %s", ws.Protocol(fileTransferProtocol))
+
+	registerListingHandler(ws)
 
 	return *host.InfoFromHost(h)
 }
 
-func runSourceNode(targetNodeInfo peer.AddrInfo, requestedFile string) {
-	log.Printf("[CRDT][runSourceNode] Syncing to peer %s", targetNodeInfo.ID.String())
+func runSourceNode(ws *Workspace, targetNodeInfo peer.AddrInfo, requestedFile string) {
+	log.Printf("[CRDT][runSourceNode] Syncing %s to peer %s", ws.Name, targetNodeInfo.ID.String())
 
-	if err := node.Connect(context.Background(), targetNodeInfo); err != nil {
+	if err := node.Connect(syncStreamContext(context.Background()), targetNodeInfo); err != nil {
 		log.Printf("[CRDT][runSourceNode] Connect failed: %s", err.Error())
 		return
 	}
 
-	stream, err := node.NewStream(context.Background(), targetNodeInfo.ID, "/hello/1.0.0")
+	// Prefer the incremental protocol, multistream falls back to 1.1.0 / 1.0.0 for older peers
+	stream, err := ws.newStream(syncStreamContext(context.Background()), targetNodeInfo.ID,
+		ws.Protocol(helloProtocolV2), ws.Protocol(helloProtocolV1Header), ws.Protocol(helloProtocolV1))
+	if isNotInWorkspaceError(err) {
+		log.Printf("[CRDT][runSourceNode] Peer %s is not in workspace %s", shortID(targetNodeInfo.ID.String()), ws.Name)
+		return
+	}
 	if err != nil {
 		log.Printf("[CRDT][runSourceNode] Stream open failed: %s", err.Error())
 		return
@@ -102,8 +138,19 @@ func runSourceNode(targetNodeInfo peer.AddrInfo, requestedFile string) {
 		}
 	}(stream)
 
-	log.Println("[CRDT][runSourceNode] Sending local metadata for all files...")
-	if err := SendMetadataMap(stream, fileMetadataMap); err != nil {
+	if stream.Protocol() == ws.Protocol(helloProtocolV2) {
+		changed, err := runIncrementalSync(ws, stream, true, targetNodeInfo.ID.String())
+		if err != nil {
+			log.Println("[CRDT][runSourceNode] Incremental sync error:", err)
+			return
+		}
+		ws.notePeer(targetNodeInfo.ID.String(), true)
+		printRequestedMetadata(ws, changed, requestedFile)
+		return
+	}
+
+	log.Printf("[CRDT][runSourceNode] Peer only speaks %s, sending local metadata for all files...", stream.Protocol())
+	if err := SendMetadataMap(stream, ws.Store.Snapshot(), stream.Protocol() == ws.Protocol(helloProtocolV1Header)); err != nil {
 		log.Println("[CRDT][runSourceNode] Send error:", err)
 		return
 	}
@@ -114,26 +161,32 @@ func runSourceNode(targetNodeInfo peer.AddrInfo, requestedFile string) {
 		return
 	}
 
-	for name, remoteMeta := range remoteMetaMap {
-		localMeta := fileMetadataMap[name]
-		fileMetadataMap[name] = MergeFileMetadata(localMeta, remoteMeta)
-		log.Printf("[CRDT][runSourceNode] Merged metadata for file: %s", name)
+	// Merge and save in one transaction
+	changed, err := ws.Store.MergeRemote(targetNodeInfo.ID.String(), remoteMetaMap)
+	if err != nil {
+		log.Printf("[CRDT][runSourceNode] Failed to merge metadata: %v", err)
+		return
+	}
+	// /hello/1.0.0 has no ack step, such peers never unblock GC (see incrementalSync.go)
+	ws.notePeer(targetNodeInfo.ID.String(), true)
+	log.Printf("[CRDT][runSourceNode] Merged metadata for files: %v (workspace %s)", changed, ws.Name)
+	printRequestedMetadata(ws, changed, requestedFile)
+}
 
-		if name == requestedFile {
+func printRequestedMetadata(ws *Workspace, changed []string, requestedFile string) {
+	for _, name := range changed {
+		if name != requestedFile {
+			continue
+		}
+		if meta, ok := ws.Store.Get(name); ok {
 			log.Printf("[CRDT][runSourceNode] Printing metadata for transferred file: %s", name)
-			PrintMetadata(fileMetadataMap[name])
+			PrintMetadata(meta)
+			PrintHistory(meta)
 		}
 	}
-	// Save merged metadata
-	if err := saveMetadataToFile("sync-metadata.json"); err != nil {
-		log.Printf("[CRDT][runSourceNode] Failed to save metadata to file: %v", err)
-	} else {
-		log.Println("[CRDT][runSourceNode] Metadata saved to sync-metadata.json")
-	}
-
 }
 
-func readHelloProtocol(s network.Stream) error {
+func readHelloProtocol(ws *Workspace, s network.Stream) error {
 	remoteMetaMap, err := ReceiveMetadataMap(s)
 	if err != nil {
 		return err
@@ -142,26 +195,72 @@ func readHelloProtocol(s network.Stream) error {
 	peerID := s.Conn().RemotePeer()
 	log.Printf("[CRDT][readHelloProtocol] Received metadata map from %s", peerID)
 
-	for name, remoteMeta := range remoteMetaMap {
-		localMeta := fileMetadataMap[name]
-		fileMetadataMap[name] = MergeFileMetadata(localMeta, remoteMeta)
+	if _, err := ws.Store.MergeRemote(peerID.String(), remoteMetaMap); err != nil {
+		return err
 	}
 
-	if err := SendMetadataMap(s, fileMetadataMap); err != nil {
+	if err := SendMetadataMap(s, ws.Store.Snapshot(), s.Protocol() == ws.Protocol(helloProtocolV1Header)); err != nil {
 		return err
 	}
 
-	if !syncedPeers[peerID.String()] {
-		syncedPeers[peerID.String()] = true
+	if firstSync := ws.notePeer(peerID.String(), true); firstSync {
 		go func() {
 			log.Printf("[CRDT][readHelloProtocol] Syncing back to %s", peerID)
-			runSourceNode(peer.AddrInfo{ID: peerID}, "") // 👈 no file being requested
+			runSourceNode(ws, peer.AddrInfo{ID: peerID}, "") // 👈 no file being requested
 		}()
 	}
 
 	return nil
 }
 
+// scanSharedFolder records a version for every file under the workspace's shared roots that
+// is new or changed since its latest head. Ledger keys are slash separated paths relative to the
+// root, prefixed with "label:" for roots other than the shared_dir (roots.go).
+func scanSharedFolder(ws *Workspace, source, hostname string) ([]string, error) {
+	return ws.Store.Update(source, func(tx *MetadataTxn) error {
+		return ws.walkShared(func(_ *SharedRoot, path, fileName string, info os.FileInfo) error {
+			if info.IsDir() {
+				return nil // ignored paths are never walked, see shareIgnore.go
+			}
+			if ws.Sync && isConflictCopy(fileName) {
+				return nil // local only, see folderSync.go
+			}
+
+			// every version we author keeps its content in the object store
+			cid, err := ws.Objects.PutFile(path)
+			if err != nil {
+				log.Printf("[INIT][scanSharedFolder] Skipping '%s': %v", fileName, err)
+				return nil
+			}
+
+			meta, exists := tx.Get(fileName)
+			if exists {
+				// already tracked: only record a version if the content changed
+				if head, ok := LatestHead(meta); ok && head.CID == cid && len(meta.Heads) == 1 {
+					return nil
+				}
+				meta.AddVersion(NewFileVersion(node.ID().String(), "local edit on "+hostname, cid, meta.Heads))
+				tx.Put(meta)
+				log.Printf("[INIT][scanSharedFolder] File '%s' changed, new version recorded", fileName)
+				return nil
+			}
+
+			version := NewFileVersion(node.ID().String(), "initial upload from "+hostname, cid, nil)
+
+			meta = FileMetadata{
+				FileName: fileName,
+				Versions: make(map[string]FileVersion),
+				Heads:    []string{},
+			}
+			meta.AddVersion(version)
+			tx.Put(meta)
+
+			log.Printf("[INIT][scanSharedFolder] File '%s' added to metadata map", fileName)
+			return nil
+		})
+	})
+}
+
 func main() {
 	// Recovery block
 	defer func() {
@@ -176,41 +275,94 @@ func main() {
 	defer cancel()
 
 	encryptFlag := flag.Bool("E", false, "Enable AES encryption for file transfer")
+	keepVersions := flag.Int("keep-versions", 10, "Keep content of the newest N versions of every file (0 = no limit)")
+	keepDays := flag.Int("keep-days", 30, "Keep content of versions younger than N days (0 = no limit)")
+	keepTagged := flag.Bool("keep-tagged", true, "Never prune content of tagged versions")
+	gcRetentionDays := flag.Int("gc-retention-days", 90, "Ledger history older than N days may be squashed into checkpoints")
+	autoGC := flag.Bool("auto-gc", false, "Compaction mode: squash old history automatically once all peers acked it")
+	storeKind := flag.String("store", "json", "Ledger storage backend: json ("+metadataFilePath+") or bolt ("+boltLedgerPath+"), for every workspace")
+	flag.StringVar(&gitAuthorsPath, "git-authors", defaultGitAuthorsPath, "JSON file mapping peer IDs to git \"Name <email>\" for export-git/import-git")
+	port := flag.Int("port", 0, "TCP/QUIC port to listen on (0 = random)")
+	useDHT := flag.Bool("dht", false, "Also discover peers beyond the LAN through a Kademlia DHT")
+	dhtPublic := flag.Bool("dht-public", false, "Use the public IPFS DHT instead of a private one (implies -dht)")
+	bootstrapList := flag.String("bootstrap", "", "Comma separated DHT bootstrap multiaddrs (/ip4/.../tcp/.../p2p/<id>)")
+	flag.StringVar(&discoveryGroup, "group", mdnsServiceTag, "Group of the default workspace, peers only find peers of the same group")
+	syncAll := flag.Bool("sync", false, "Keep the shared folder of every workspace in two-way sync with its members")
+	homeDir := flag.String("home", os.Getenv("PEERLINK_HOME"), "Folder holding .peerlink/ and every relative path (default $PEERLINK_HOME, else the current folder)")
+	workspacesPath := flag.String("workspaces", defaultWorkspacesPath, "JSON file listing the workspaces (groups) this node joins")
+	peersConfig := flag.String("peers-config", defaultPeersConfigPath, "JSON file with static peers and DHT bootstrap peers")
+	natFlag := flag.Bool("nat", false, "Enable AutoNAT service, port mapping and hole punching")
+	relayList := flag.String("relays", "", "Comma separated relay multiaddrs to reserve a slot on (circuit relay v2 client)")
+	relayService := flag.Bool("relay-service", false, "Act as a relay node for peers that can't reach each other")
+	relayLimitData := flag.Int64("relay-limit-data", 1<<20, "Relay node: max bytes per relayed connection")
+	relayLimitTime := flag.Duration("relay-limit-duration", 2*time.Minute, "Relay node: max lifetime of a relayed connection")
 	flag.Parse()
-	usedEncryption = *encryptFlag
+	if err := resolveHome(*homeDir); err != nil {
+		log.Fatalf("[INIT] %v", err)
+	}
+	useEncryption = *encryptFlag
+	gcRetention = time.Duration(*gcRetentionDays) * 24 * time.Hour
+
+	bootstrapPeers, err := parseBootstrapPeers(*bootstrapList)
+	if err != nil {
+		log.Fatalf("[INIT] %v", err)
+	}
+	peersFile, err := loadPeersConfig(*peersConfig)
+	if err != nil {
+		log.Fatalf("[INIT] %v", err)
+	}
+	relays, err := parseBootstrapPeers(strings.Join(append(peersFile.Relays, *relayList), ","))
+	if err != nil {
+		log.Fatalf("[INIT] Bad relay address: %v", err)
+	}
+
+	workspaceConfigs, err := loadWorkspaces(*workspacesPath, discoveryGroup)
+	if err != nil {
+		log.Fatalf("[INIT] %v", err)
+	}
+	for i := range workspaceConfigs {
+		workspaceConfigs[i].Sync = workspaceConfigs[i].Sync || *syncAll
+	}
 
 	log.Println("[INIT] Starting P2P File Sync Node...")
 
 	// ✅ Create node first
-	node = createNode()
+	node = createNode(NodeConfig{
+		Port:           *port,
+		NAT:            *natFlag,
+		Relays:         relays,
+		RelayService:   *relayService,
+		RelayLimitData: *relayLimitData,
+		RelayLimitTime: *relayLimitTime,
+	})
 	log.Printf("[INIT] Peer ID: %s", node.ID().String())
+	for _, addr := range node.Addrs() {
+		log.Printf("[INIT] Listening on %s/p2p/%s", addr, node.ID())
+	}
 
 	hostname, _ := os.Hostname()
 	log.Printf("[INIT]️Hostname: %s", hostname)
 
 	// ✅ Now you can generate versions safely
-	files, err := os.ReadDir("shared")
-	if err != nil {
-		log.Fatalf("[INIT] Failed to read shared directory: %v", err)
+	// Every workspace loads its persisted ledger so history survives restarts
+	retention := RetentionPolicy{
+		KeepLast:   *keepVersions,
+		KeepDays:   *keepDays,
+		KeepTagged: *keepTagged,
 	}
-
-	for _, f := range files {
-		if f.IsDir() {
-			continue
+	for _, cfg := range workspaceConfigs {
+		ws, err := openWorkspace(cfg, *storeKind, retention)
+		if err != nil {
+			log.Fatalf("[INIT] Failed to open workspace %s: %v", cfg.Name, err)
 		}
-		fileName := f.Name()
-		version := NewFileVersion(node.ID().String(), "initial upload from "+hostname, "CID_"+fileName, nil)
-
-		meta := FileMetadata{
-			FileName: fileName,
-			Versions: make(map[string]FileVersion),
-			Heads:    []string{},
+		defer ws.Close()
+		if _, err := scanSharedFolder(ws, "startup", hostname); err != nil {
+			log.Fatalf("[INIT] Failed to record initial versions of %s: %v", ws.Name, err)
 		}
-		meta.AddVersion(version)
-		fileMetadataMap[fileName] = meta
-
-		log.Printf("[INIT] File '%s' added to metadata map", fileName)
+		log.Printf("[INIT] Workspace %s (id %s): shared %s, downloads %s", ws.Name, ws.ID, ws.SharedDir, ws.DownloadDir)
+		workspaces = append(workspaces, ws)
 	}
+	activeWorkspace = workspaces[0]
 
 	// Optional: create a dummy local version for internal syncing
 	localFileMetadata = FileMetadata{
@@ -221,27 +373,64 @@ func main() {
 	syncVersion := NewFileVersion(node.ID().String(), "initial metadata", "CID123456", nil)
 	localFileMetadata.AddVersion(syncVersion)
 
-	_ = runTargetNode(node)
+	for _, ws := range workspaces {
+		startObjectPruning(ctx, ws, time.Hour)
+		if *autoGC {
+			startAutoCompaction(ctx, ws, time.Hour)
+		}
+		_ = runTargetNode(node, ws)
+	}
+
+	liveness = NewLivenessTracker(node)
+	liveness.Start(ctx)
+
+	staticPeers, err = NewStaticPeerManager(node, *peersConfig)
+	if err != nil {
+		log.Fatalf("[INIT] Static peers: %v", err)
+	}
 
 	log.Println("[mDNS][main] Starting local peer discovery...")
-	if err := startMdnsDiscovery(node); err != nil {
-		log.Fatalf("[mDNS][main] Discovery failed: %v", err)
+	for _, ws := range workspaces {
+		if err := startMdnsDiscovery(node, ws); err != nil {
+			log.Fatalf("[mDNS][main] Discovery failed: %v", err)
+		}
 	}
+	if *useDHT || *dhtPublic {
+		bootstrapPeers = append(bootstrapPeers, staticPeers.BootstrapPeers()...)
+		kad, err := startDHTDiscovery(ctx, node, DHTConfig{Bootstrap: bootstrapPeers, Public: *dhtPublic})
+		if err != nil {
+			log.Fatalf("[DHT][main] Discovery failed: %v", err)
+		}
+		defer kad.Close()
+		for _, ws := range workspaces {
+			ws := ws
+			startDHTRendezvous(ctx, node, kad, ws.RendezvousGroup(), 0,
+				func(id peer.ID) bool { return ws.isMember(id.String()) },
+				func(pi peer.AddrInfo) { handlePeerFound(node, ws, pi, "DHT") })
+		}
+		staticPeers.SetRouter(kad)
+	}
+	staticPeers.Start(ctx)
 
-	ps, err := pubsub.NewGossipSub(ctx, node)
+	announceGuard = NewAnnounceGuard(node.ID())
+	ps, err := pubsub.NewGossipSub(ctx, node, announceGuard.pubsubOptions()...)
 	if err != nil {
 		log.Fatalf("[PubSub][main] Init failed: %v", err)
 	}
-	if err := setupFilePubSub(ctx, ps, node.ID().String()); err != nil {
-		log.Fatalf("[PubSub][main] File announce setup failed: %v", err)
+	for _, ws := range workspaces {
+		if err := setupFilePubSub(ctx, ps, ws, node.ID().String()); err != nil {
+			log.Fatalf("[PubSub][main] File announce setup failed: %v", err)
+		}
+		if err := setupSearch(ctx, ps, ws); err != nil {
+			log.Fatalf("[PubSub][main] Search setup failed: %v", err)
+		}
 	}
 
-	go func() {
-		log.Println("[PubSub] Waiting for peer discovery and topic mesh...")
-		time.Sleep(15 * time.Second)
-		log.Println("[PubSub] Sending initial file announcement...")
-		announceLocalFiles(node.ID().String())
-	}()
+	for _, ws := range workspaces {
+		startAnnouncer(ctx, ws)
+		startSubscriptions(ctx, ws)
+		startFolderSync(ctx, ws)
+	}
 
 	startInteractiveCLI(ctx)
 	log.Println("[READY] Node is up and running. Press Ctrl+C to exit.")
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+1. Pluggable storage interface behind MetadataStore [DONE]
+2. JSON file backend (the original sync-metadata.json, now written atomically) [DONE]
+3. Crash safe embedded backend on bbolt, only touched files are rewritten [DONE]
+4. Indexes by file, version ID and author [DONE]
+5. JSON stays the import/export format (export-ledger / import-ledger) [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                          # bbolt LAYOUT (-store bolt)
+
+ meta       "schema_version" → currentLedgerSchema, "node_id" → peer ID
+ files      file name → FileMetadata without Versions (heads, tags, branches)
+ versions   file name \x00 version ID → {file, version}
+ by_file    file name \x00 version ID → ""
+ by_author  author \x00 file name \x00 version ID → file name
+
+ Versions are keyed per file: two files can hold the same version ID (same parents,
+ author, time, message and CID). Databases keyed by bare version ID are rekeyed on open.
+
+ Every Save is a single bbolt transaction, a crash leaves the previous state intact.
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+// MetadataBackend persists the ledger for MetadataStore
+type MetadataBackend interface {
+	// Load returns the whole ledger
+	Load() (map[string]FileMetadata, error)
+	// Save persists the changed files; all is the complete ledger after the change
+	Save(changed map[string]FileMetadata, all map[string]FileMetadata) error
+	// VersionsByAuthor returns file name → versions written by author
+	VersionsByAuthor(author string) (map[string][]FileVersion, error)
+	Close() error
+}
+
+// ─── JSON file ───────────────────────────────────────────────────────────────
+
+type jsonBackend struct {
+	path string
+}
+
+func newJSONBackend(path string) *jsonBackend {
+	return &jsonBackend{path: path}
+}
+
+func (b *jsonBackend) Load() (map[string]FileMetadata, error) {
+	return loadMetadataFromFile(b.path)
+}
+
+func (b *jsonBackend) Save(_ map[string]FileMetadata, all map[string]FileMetadata) error {
+	return saveMetadataToFile(b.path, all)
+}
+
+func (b *jsonBackend) VersionsByAuthor(author string) (map[string][]FileVersion, error) {
+	all, err := b.Load()
+	if err != nil {
+		return nil, err
+	}
+	return versionsByAuthor(all, author), nil
+}
+
+func (b *jsonBackend) Close() error { return nil }
+
+func versionsByAuthor(all map[string]FileMetadata, author string) map[string][]FileVersion {
+	out := make(map[string][]FileVersion)
+	for name, meta := range all {
+		for _, v := range meta.Versions {
+			if v.Author == author {
+				out[name] = append(out[name], v)
+			}
+		}
+	}
+	return out
+}
+
+// ─── bbolt ───────────────────────────────────────────────────────────────────
+
+var (
+	bucketMeta     = []byte("meta")
+	bucketFiles    = []byte("files")
+	bucketVersions = []byte("versions")
+	bucketByFile   = []byte("by_file")
+	bucketByAuthor = []byte("by_author")
+)
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+type storedVersion struct {
+	File    string      `json:"file"`
+	Version FileVersion `json:"version"`
+}
+
+func indexKey(a, b string) []byte {
+	return []byte(a + "\x00" + b)
+}
+
+func newBoltBackend(path string) (*boltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("[metadataBackend][newBoltBackend] cannot create folder: %w", err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("[metadataBackend][newBoltBackend] cannot open %s (is another node using it?): %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketFiles, bucketVersions, bucketByFile, bucketByAuthor} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(bucketMeta)
+		if raw := meta.Get([]byte("schema_version")); raw != nil {
+			schema, _ := strconv.Atoi(string(raw))
+			if err := checkPeerSchema(schema, "local database "+path); err != nil {
+				return err
+			}
+		}
+		if err := meta.Put([]byte("schema_version"), []byte(strconv.Itoa(currentLedgerSchema))); err != nil {
+			return err
+		}
+		if err := rekeyVersions(tx); err != nil {
+			return err
+		}
+		return meta.Put([]byte("node_id"), []byte(ledgerNodeID()))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("[metadataBackend][newBoltBackend] init failed: %w", err)
+	}
+	return &boltBackend{db: db}, nil
+}
+
+// rekeyVersions moves versions stored under a bare version ID to "file \x00 id" and
+// rebuilds the author index the same way
+func rekeyVersions(tx *bolt.Tx) error {
+	versions := tx.Bucket(bucketVersions)
+	byAuthor := tx.Bucket(bucketByAuthor)
+	type move struct {
+		old []byte
+		sv  storedVersion
+		raw []byte
+	}
+	var moves []move
+	if err := versions.ForEach(func(k, v []byte) error {
+		if bytes.IndexByte(k, 0) >= 0 {
+			return nil // already per file
+		}
+		var sv storedVersion
+		if err := json.Unmarshal(v, &sv); err != nil {
+			return fmt.Errorf("version %s: %w", k, err)
+		}
+		moves = append(moves, move{old: append([]byte(nil), k...), sv: sv, raw: append([]byte(nil), v...)})
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, m := range moves {
+		id := m.sv.Version.VersionID
+		if err := versions.Delete(m.old); err != nil {
+			return err
+		}
+		if err := byAuthor.Delete(indexKey(m.sv.Version.Author, id)); err != nil {
+			return err
+		}
+		if err := versions.Put(indexKey(m.sv.File, id), m.raw); err != nil {
+			return err
+		}
+		if err := byAuthor.Put(authorKey(m.sv.Version.Author, m.sv.File, id), []byte(m.sv.File)); err != nil {
+			return err
+		}
+	}
+	if len(moves) > 0 {
+		log.Printf("[metadataBackend][rekeyVersions] Rekeyed %d version(s) per file", len(moves))
+	}
+	return nil
+}
+
+func authorKey(author, file, id string) []byte {
+	return indexKey(author, file+"\x00"+id)
+}
+
+// IsEmpty reports whether no file was ever stored (used to import the JSON ledger once)
+func (b *boltBackend) IsEmpty() bool {
+	empty := true
+	_ = b.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(bucketFiles).Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	return empty
+}
+
+func (b *boltBackend) Load() (map[string]FileMetadata, error) {
+	out := make(map[string]FileMetadata)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketFiles).ForEach(func(k, v []byte) error {
+			var meta FileMetadata
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return fmt.Errorf("file %s: %w", k, err)
+			}
+			meta.Versions = make(map[string]FileVersion)
+			out[string(k)] = meta
+			return nil
+		}); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketVersions).ForEach(func(k, v []byte) error {
+			var sv storedVersion
+			if err := json.Unmarshal(v, &sv); err != nil {
+				return fmt.Errorf("version %q: %w", k, err)
+			}
+			if meta, ok := out[sv.File]; ok {
+				meta.Versions[sv.Version.VersionID] = sv.Version
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[metadataBackend][boltBackend.Load] %w", err)
+	}
+	return out, nil
+}
+
+func (b *boltBackend) Save(changed map[string]FileMetadata, _ map[string]FileMetadata) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		files := tx.Bucket(bucketFiles)
+		versions := tx.Bucket(bucketVersions)
+		byFile := tx.Bucket(bucketByFile)
+		byAuthor := tx.Bucket(bucketByAuthor)
+
+		names := make([]string, 0, len(changed))
+		for name := range changed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			meta := changed[name]
+			header := meta
+			header.Versions = nil
+			data, err := json.Marshal(header)
+			if err != nil {
+				return err
+			}
+			if err := files.Put([]byte(name), data); err != nil {
+				return err
+			}
+
+			// drop versions that are gone (squashed by a checkpoint)
+			prefix := indexKey(name, "")
+			c := byFile.Cursor()
+			var stale [][]byte
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				id := string(k[len(prefix):])
+				if _, keep := meta.Versions[id]; !keep {
+					stale = append(stale, append([]byte(nil), k...))
+				}
+			}
+			for _, k := range stale {
+				id := string(k[len(prefix):])
+				if raw := versions.Get(k); raw != nil {
+					var sv storedVersion
+					if json.Unmarshal(raw, &sv) == nil {
+						_ = byAuthor.Delete(authorKey(sv.Version.Author, name, id))
+					}
+				}
+				if err := versions.Delete(k); err != nil {
+					return err
+				}
+				if err := byFile.Delete(k); err != nil {
+					return err
+				}
+			}
+
+			for id, v := range meta.Versions {
+				data, err := json.Marshal(storedVersion{File: name, Version: v})
+				if err != nil {
+					return err
+				}
+				key := indexKey(name, id)
+				if existing := versions.Get(key); bytes.Equal(existing, data) {
+					continue // unchanged, keep the write small
+				}
+				if err := versions.Put(key, data); err != nil {
+					return err
+				}
+				if err := byFile.Put(key, nil); err != nil {
+					return err
+				}
+				if err := byAuthor.Put(authorKey(v.Author, name, id), []byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[metadataBackend][boltBackend.Save] %w", err)
+	}
+	return nil
+}
+
+func (b *boltBackend) VersionsByAuthor(author string) (map[string][]FileVersion, error) {
+	out := make(map[string][]FileVersion)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		versions := tx.Bucket(bucketVersions)
+		prefix := indexKey(author, "")
+		c := tx.Bucket(bucketByAuthor).Cursor()
+		for k, file := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, file = c.Next() {
+			var sv storedVersion
+			if err := json.Unmarshal(versions.Get(k[len(prefix):]), &sv); err != nil {
+				return err
+			}
+			out[string(file)] = append(out[string(file)], sv.Version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[metadataBackend][boltBackend.VersionsByAuthor] %w", err)
+	}
+	return out, nil
+}
+
+// FindVersion looks a version up by its full ID without loading the ledger (the first
+// file holding it, when several do)
+func (b *boltBackend) FindVersion(id string) (string, FileVersion, bool) {
+	var sv storedVersion
+	found := false
+	suffix := []byte("\x00" + id)
+	_ = b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketVersions).Cursor()
+		for k, raw := c.First(); k != nil && !found; k, raw = c.Next() {
+			if bytes.HasSuffix(k, suffix) {
+				found = json.Unmarshal(raw, &sv) == nil
+			}
+		}
+		return nil
+	})
+	return sv.File, sv.Version, found
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
+
+// openMetadataBackend opens the backend picked with -store. The first time bolt is
+// used, an existing JSON ledger is imported so no history is lost.
+func openMetadataBackend(kind, jsonPath, boltPath string) (MetadataBackend, error) {
+	switch kind {
+	case "json", "":
+		return newJSONBackend(jsonPath), nil
+	case "bolt":
+		b, err := newBoltBackend(boltPath)
+		if err != nil {
+			return nil, err
+		}
+		if !b.IsEmpty() {
+			return b, nil
+		}
+		legacy, err := loadMetadataFromFile(jsonPath)
+		if err != nil {
+			b.Close()
+			return nil, fmt.Errorf("[metadataBackend][openMetadataBackend] cannot import %s: %w", jsonPath, err)
+		}
+		if len(legacy) > 0 {
+			if err := b.Save(legacy, legacy); err != nil {
+				b.Close()
+				return nil, err
+			}
+			log.Printf("[metadataBackend][openMetadataBackend] Imported %d file(s) from %s into %s", len(legacy), jsonPath, boltPath)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("[metadataBackend][openMetadataBackend] unknown store %q (use json or bolt)", kind)
+}
+
+// ─── import / export ─────────────────────────────────────────────────────────
+
+// cmdExportLedger writes the ledger in the JSON file format
+func cmdExportLedger(ws *Workspace, path string) error {
+	if err := saveMetadataToFile(path, ws.Store.Snapshot()); err != nil {
+		return err
+	}
+	fmt.Printf("📤 Ledger exported to %s\n", path)
+	return nil
+}
+
+// cmdImportLedger merges a JSON ledger file into the store (CRDT merge, nothing is overwritten)
+func cmdImportLedger(ws *Workspace, path string) error {
+	imported, err := loadMetadataFromFile(path)
+	if err != nil {
+		return err
+	}
+	changed, err := ws.Store.MergeRemote("import", imported)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📥 Imported %s, %d file(s) updated\n", path, len(changed))
+	return nil
+}
+
+// cmdAuthored lists the versions a peer wrote, straight from the author index
+func cmdAuthored(ws *Workspace, author string) error {
+	if author == "" {
+		author = node.ID().String()
+	}
+	byFile, err := ws.Store.VersionsByAuthor(author)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(byFile))
+	for name := range byFile {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("✍️  Versions by %s:\n", shortID(author))
+	if len(names) == 0 {
+		fmt.Println("   (none)")
+	}
+	for _, name := range names {
+		versions := byFile[name]
+		sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+		for _, v := range versions {
+			fmt.Printf("   %s  %s  %s  %s\n", shortID(v.VersionID), v.orderKey(), name, v.Message)
+		}
+	}
+	return nil
+}
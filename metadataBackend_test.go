@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// TestBoltSharedVersionID: two files holding the same version ID keep separate records
+func TestBoltSharedVersionID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	b, err := newBoltBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := FileVersion{VersionID: "same", Author: "peer", CID: contentCID([]byte("x"))}
+	other := FileVersion{VersionID: "other", Author: "peer"}
+	ledger := map[string]FileMetadata{
+		"a.txt": {FileName: "a.txt", Versions: map[string]FileVersion{"same": v}, Heads: []string{"same"}},
+		"b.txt": {FileName: "b.txt", Versions: map[string]FileVersion{"same": v, "other": other}, Heads: []string{"same"}},
+	}
+	if err := b.Save(ledger, ledger); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded["a.txt"].Versions["same"]; !ok {
+		t.Errorf("a.txt lost the shared version")
+	}
+	if _, ok := loaded["b.txt"].Versions["same"]; !ok {
+		t.Errorf("b.txt lost the shared version")
+	}
+
+	// squashing it out of b.txt must not touch a.txt
+	changed := map[string]FileMetadata{"b.txt": {FileName: "b.txt", Versions: map[string]FileVersion{"other": other}, Heads: []string{"other"}}}
+	if err := b.Save(changed, nil); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ = b.Load()
+	if _, ok := loaded["a.txt"].Versions["same"]; !ok {
+		t.Errorf("deleting from b.txt removed a.txt's record")
+	}
+	byAuthor, err := b.VersionsByAuthor("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byAuthor["a.txt"]) != 1 || len(byAuthor["b.txt"]) != 1 {
+		t.Errorf("author index: %v", byAuthor)
+	}
+	b.Close()
+}
+
+// TestBoltRekeysLegacyVersions: a database keyed by bare version ID is readable after reopening
+func TestBoltRekeysLegacyVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	b, err := newBoltBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := FileVersion{VersionID: "v1", Author: "peer"}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		header, _ := json.Marshal(FileMetadata{FileName: "a.txt", Heads: []string{"v1"}})
+		record, _ := json.Marshal(storedVersion{File: "a.txt", Version: v})
+		tx.Bucket(bucketFiles).Put([]byte("a.txt"), header)
+		tx.Bucket(bucketVersions).Put([]byte("v1"), record)
+		tx.Bucket(bucketByFile).Put(indexKey("a.txt", "v1"), nil)
+		return tx.Bucket(bucketByAuthor).Put(indexKey("peer", "v1"), []byte("a.txt"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	if b, err = newBoltBackend(path); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	loaded, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded["a.txt"].Versions["v1"]; !ok {
+		t.Errorf("legacy version lost")
+	}
+	if byAuthor, err := b.VersionsByAuthor("peer"); err != nil || len(byAuthor["a.txt"]) != 1 {
+		t.Errorf("author index after rekey: %v %v", byAuthor, err)
+	}
+	if file, _, ok := b.FindVersion("v1"); !ok || file != "a.txt" {
+		t.Errorf("FindVersion after rekey: %q %v", file, ok)
+	}
+}
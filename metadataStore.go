@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+1. Replace the unlocked global fileMetadataMap with a store guarded by a RWMutex [DONE]
+2. Transactional merge-and-persist: changes are staged, written to disk, then swapped in [DONE]
+3. Readers only ever get deep copies so nobody mutates shared Versions maps [DONE]
+4. Change subscription API so announcements / CLI / watchers can react to updates [DONE]
+5. go test -race: peers in one process writing, reading, subscribing and syncing over
+   /hello/2.0.0 at the same time converge (metadataStore_test.go) [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- Each workspace owns one MetadataStore (Workspace.Store, see workspace.go), the only owner of its ledger
+- Persistence goes through a MetadataBackend (metadataBackend.go): JSON file or bbolt
+- Update() holds the write lock for the whole transaction, so concurrent syncs
+  from mDNS and /hello goroutines are serialized
+- If persisting fails the in-memory ledger is left untouched
+- Subscribers get a MetadataChange per committed transaction, slow subscribers
+  drop events instead of blocking a sync
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+// MetadataChange is published to subscribers after every committed transaction
+type MetadataChange struct {
+	Files  []string // file names that were written
+	Source string   // who caused it ("startup", "hello", a peer ID ...)
+}
+
+type MetadataStore struct {
+	mu      sync.RWMutex
+	files   map[string]FileMetadata
+	backend MetadataBackend // nil keeps the ledger in memory only
+
+	subsLock sync.Mutex
+	subs     map[int]chan MetadataChange
+	nextSub  int
+
+	tagConflicts tagConflicts // tags that lost their name in a merge (refs.go)
+}
+
+// MetadataTxn stages writes for one Update call
+type MetadataTxn struct {
+	base    map[string]FileMetadata
+	pending map[string]FileMetadata
+}
+
+func NewMetadataStore(backend MetadataBackend) *MetadataStore {
+	return &MetadataStore{
+		files:   make(map[string]FileMetadata),
+		backend: backend,
+		subs:    make(map[int]chan MetadataChange),
+	}
+}
+
+// Close releases the backend (the bbolt file lock)
+func (ms *MetadataStore) Close() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.backend == nil {
+		return nil
+	}
+	return ms.backend.Close()
+}
+
+// VersionsByAuthor uses the backend's author index
+func (ms *MetadataStore) VersionsByAuthor(author string) (map[string][]FileVersion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.backend == nil {
+		return versionsByAuthor(ms.files, author), nil
+	}
+	return ms.backend.VersionsByAuthor(author)
+}
+
+// Clone deep copies the metadata so callers can modify it freely
+func (f FileMetadata) Clone() FileMetadata {
+	c := FileMetadata{
+		FileName: f.FileName,
+		Versions: make(map[string]FileVersion, len(f.Versions)),
+		Heads:    append([]string{}, f.Heads...),
+	}
+	for id, v := range f.Versions {
+		v.ParentIDs = append([]string(nil), v.ParentIDs...)
+		if v.Tree != nil {
+			tree := TreeObject{Entries: make(map[string]TreeEntry, len(v.Tree.Entries))}
+			for path, e := range v.Tree.Entries {
+				tree.Entries[path] = e
+			}
+			v.Tree = &tree
+		}
+		if v.Checkpoint != nil {
+			cp := *v.Checkpoint
+			cp.Squashed = append([]string(nil), cp.Squashed...)
+			v.Checkpoint = &cp
+		}
+		c.Versions[id] = v
+	}
+	if f.Tags != nil {
+		c.Tags = make(map[string]Tag, len(f.Tags))
+		for name, t := range f.Tags {
+			c.Tags[name] = t
+		}
+	}
+	if f.Branches != nil {
+		c.Branches = make(map[string]Branch, len(f.Branches))
+		for name, b := range f.Branches {
+			c.Branches[name] = b
+		}
+	}
+	return c
+}
+
+// Load replaces the in-memory ledger with the one in the backend (empty if missing)
+func (ms *MetadataStore) Load() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.backend == nil {
+		return nil
+	}
+	loaded, err := ms.backend.Load()
+	if err != nil {
+		return err
+	}
+	ms.files = loaded
+	return nil
+}
+
+// Get returns a copy of one file's metadata
+func (ms *MetadataStore) Get(name string) (FileMetadata, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	meta, ok := ms.files[name]
+	if !ok {
+		return FileMetadata{}, false
+	}
+	return meta.Clone(), true
+}
+
+// Snapshot returns a copy of the whole ledger
+func (ms *MetadataStore) Snapshot() map[string]FileMetadata {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	out := make(map[string]FileMetadata, len(ms.files))
+	for name, meta := range ms.files {
+		out[name] = meta.Clone()
+	}
+	return out
+}
+
+// Names returns the sorted file names in the ledger
+func (ms *MetadataStore) Names() []string {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	names := make([]string, 0, len(ms.files))
+	for name := range ms.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns a copy of a file as seen inside the transaction
+func (tx *MetadataTxn) Get(name string) (FileMetadata, bool) {
+	if meta, ok := tx.pending[name]; ok {
+		return meta.Clone(), true
+	}
+	meta, ok := tx.base[name]
+	if !ok {
+		return FileMetadata{}, false
+	}
+	return meta.Clone(), true
+}
+
+// Names returns every file name visible inside the transaction
+func (tx *MetadataTxn) Names() []string {
+	names := make([]string, 0, len(tx.base)+len(tx.pending))
+	for name := range tx.base {
+		names = append(names, name)
+	}
+	for name := range tx.pending {
+		if _, ok := tx.base[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Put stages a file's metadata for commit
+func (tx *MetadataTxn) Put(meta FileMetadata) {
+	tx.pending[meta.FileName] = meta
+}
+
+// Update runs fn inside a transaction. Staged writes are persisted and then
+// swapped into the store; subscribers are notified only after that succeeds.
+func (ms *MetadataStore) Update(source string, fn func(tx *MetadataTxn) error) ([]string, error) {
+	ms.mu.Lock()
+	tx := &MetadataTxn{base: ms.files, pending: make(map[string]FileMetadata)}
+	if err := fn(tx); err != nil {
+		ms.mu.Unlock()
+		return nil, err
+	}
+	if len(tx.pending) == 0 {
+		ms.mu.Unlock()
+		return nil, nil
+	}
+
+	next := make(map[string]FileMetadata, len(ms.files)+len(tx.pending))
+	for name, meta := range ms.files {
+		next[name] = meta
+	}
+	changed := make([]string, 0, len(tx.pending))
+	for name, meta := range tx.pending {
+		next[name] = meta
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	if ms.backend != nil {
+		if err := ms.backend.Save(tx.pending, next); err != nil {
+			ms.mu.Unlock()
+			return nil, fmt.Errorf("[metadataStore][Update] persist failed, changes discarded: %w", err)
+		}
+	}
+	ms.files = next
+	ms.mu.Unlock()
+
+	ms.publish(MetadataChange{Files: changed, Source: source})
+	return changed, nil
+}
+
+// MergeRemote merges a peer's metadata map into the store
+func (ms *MetadataStore) MergeRemote(source string, remote map[string]FileMetadata) ([]string, error) {
+	return ms.Update(source, func(tx *MetadataTxn) error {
+		for name, remoteMeta := range remote {
+			remoteMeta = verifiedTrees(name, remoteMeta) // snapshots.go
+			localMeta, _ := tx.Get(name)
+			remoteMeta = holdBackFuture(name, localMeta, remoteMeta, source) // hybridLogicalClock.go
+			// receive event for the HLC: every version we didn't know advances our clock
+			for id, v := range remoteMeta.Versions {
+				if _, known := localMeta.Versions[id]; !known {
+					hlcClock.Update(v.HLC, source)
+				}
+			}
+			merged := MergeFileMetadata(localMeta, remoteMeta)
+			if merged.FileName == "" {
+				merged.FileName = name
+			}
+			ms.tagConflicts.report(name, merged.Tags, localMeta.Tags, remoteMeta.Tags)
+			if sameStrings(merged.Heads, localMeta.Heads) && len(merged.Versions) == len(localMeta.Versions) &&
+				refsDigest(merged) == refsDigest(localMeta) {
+				continue // nothing new for this file
+			}
+			tx.Put(merged)
+		}
+		return nil
+	})
+}
+
+// Subscribe returns a channel of committed changes and a cancel function
+func (ms *MetadataStore) Subscribe(buffer int) (<-chan MetadataChange, func()) {
+	ch := make(chan MetadataChange, buffer)
+	ms.subsLock.Lock()
+	id := ms.nextSub
+	ms.nextSub++
+	ms.subs[id] = ch
+	ms.subsLock.Unlock()
+
+	cancel := func() {
+		ms.subsLock.Lock()
+		if c, ok := ms.subs[id]; ok {
+			delete(ms.subs, id)
+			close(c)
+		}
+		ms.subsLock.Unlock()
+	}
+	return ch, cancel
+}
+
+func (ms *MetadataStore) publish(change MetadataChange) {
+	ms.subsLock.Lock()
+	defer ms.subsLock.Unlock()
+	for id, ch := range ms.subs {
+		select {
+		case ch <- change:
+		default:
+			log.Printf("[metadataStore][publish] Subscriber %d is slow, dropping change for %v", id, change.Files)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestWorkspace is a workspace with only what the ledger and /hello/2.0.0 need
+func newTestWorkspace(t *testing.T, name string) *Workspace {
+	t.Helper()
+	dir := t.TempDir()
+	return &Workspace{
+		WorkspaceConfig: WorkspaceConfig{Name: name},
+		Store:           NewMetadataStore(newJSONBackend(filepath.Join(dir, "ledger.json"))),
+		Acks:            loadSyncAcks(filepath.Join(dir, "acks.json")),
+		auth:            newMemberAuth(),
+	}
+}
+
+// syncOverPipe runs one /hello/2.0.0 exchange between a and b over an in-memory connection
+func syncOverPipe(a, b *Workspace) error {
+	ca, cb := net.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		defer cb.Close()
+		_, err := runIncrementalSync(b, cb, false, a.Name)
+		errs <- err
+	}()
+	_, err := runIncrementalSync(a, ca, true, b.Name)
+	ca.Close()
+	if berr := <-errs; err == nil {
+		err = berr
+	}
+	return err
+}
+
+// writeTestVersion records an edit of name on top of its current heads
+func writeTestVersion(ws *Workspace, name, content string) error {
+	sum := sha256.Sum256([]byte(content))
+	_, err := ws.Store.Update("local", func(tx *MetadataTxn) error {
+		meta, ok := tx.Get(name)
+		if !ok {
+			meta = FileMetadata{FileName: name, Versions: make(map[string]FileVersion), Heads: []string{}}
+		}
+		meta.AddVersion(NewFileVersion(ws.Name, content, hex.EncodeToString(sum[:]), meta.Heads))
+		tx.Put(meta)
+		return nil
+	})
+	return err
+}
+
+func countVersions(metaMap map[string]FileMetadata) int {
+	n := 0
+	for _, meta := range metaMap {
+		n += len(meta.Versions)
+	}
+	return n
+}
+
+// TestMetadataStoreMultiPeer has several in-process peers edit their own files and a
+// shared one while syncing with each other, reading snapshots and following their
+// subscriptions, all at once. Run it with -race.
+func TestMetadataStoreMultiPeer(t *testing.T) {
+	const (
+		peers  = 4
+		rounds = 25
+	)
+	nodes := make([]*Workspace, peers)
+	for i := range nodes {
+		nodes[i] = newTestWorkspace(t, fmt.Sprintf("peer-%d", i))
+	}
+
+	var (
+		writers, background sync.WaitGroup
+		stop                = make(chan struct{})
+		remoteChanges       = make([]int, peers)
+	)
+
+	for i, ws := range nodes {
+		ch, cancel := ws.Store.Subscribe(256)
+		defer cancel()
+		background.Add(2)
+		go func(i int) { // subscriber
+			defer background.Done()
+			for {
+				select {
+				case change := <-ch:
+					if change.Source != "local" {
+						remoteChanges[i]++
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(i)
+		go func(ws *Workspace) { // reader
+			defer background.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					snap := ws.Store.Snapshot()
+					_ = ledgerDigest(snap)
+					for name := range snap {
+						ws.Store.Get(name)
+					}
+				}
+			}
+		}(ws)
+
+		writers.Add(1)
+		go func(ws *Workspace) {
+			defer writers.Done()
+			for r := 0; r < rounds; r++ {
+				if err := writeTestVersion(ws, fmt.Sprintf("%s/file-%d.txt", ws.Name, r%3), fmt.Sprintf("%s round %d", ws.Name, r)); err != nil {
+					t.Errorf("%s: %v", ws.Name, err)
+					return
+				}
+				if err := writeTestVersion(ws, "common.txt", fmt.Sprintf("%s common %d", ws.Name, r)); err != nil {
+					t.Errorf("%s: %v", ws.Name, err)
+					return
+				}
+			}
+		}(ws)
+	}
+
+	// every peer syncs with its neighbour in the ring while the writers run
+	var syncers sync.WaitGroup
+	writing := make(chan struct{})
+	for i := range nodes {
+		syncers.Add(1)
+		go func(a, b *Workspace) {
+			defer syncers.Done()
+			for {
+				if err := syncOverPipe(a, b); err != nil {
+					t.Errorf("sync %s → %s: %v", a.Name, b.Name, err)
+					return
+				}
+				select {
+				case <-writing:
+					return
+				default:
+				}
+			}
+		}(nodes[i], nodes[(i+1)%peers])
+	}
+	writers.Wait()
+	close(writing)
+	syncers.Wait()
+
+	// quiet network: two passes over every pair must converge
+	for pass := 0; pass < 2; pass++ {
+		for i := range nodes {
+			for j := range nodes {
+				if i == j {
+					continue
+				}
+				if err := syncOverPipe(nodes[i], nodes[j]); err != nil {
+					t.Fatalf("sync %s → %s: %v", nodes[i].Name, nodes[j].Name, err)
+				}
+			}
+		}
+	}
+	close(stop)
+	background.Wait()
+
+	want := ledgerDigest(nodes[0].Store.Snapshot())
+	for i, ws := range nodes {
+		snap := ws.Store.Snapshot()
+		if got := ledgerDigest(snap); got != want {
+			t.Errorf("%s digest %s, %s has %s", ws.Name, shortID(got), nodes[0].Name, shortID(want))
+		}
+		if got := countVersions(snap); got != peers*rounds*2 {
+			t.Errorf("%s holds %d versions, want %d", ws.Name, got, peers*rounds*2)
+		}
+		if got := len(snap); got != peers*3+1 {
+			t.Errorf("%s holds %d files, want %d", ws.Name, got, peers*3+1)
+		}
+		if remoteChanges[i] == 0 {
+			t.Errorf("%s saw no merged changes on its subscription", ws.Name)
+		}
+		for peerID := range map[string]bool{nodes[(i+1)%peers].Name: true, nodes[(i+peers-1)%peers].Name: true} {
+			if _, ok := ws.Acks.Get(peerID); !ok {
+				t.Errorf("%s has no ack from %s", ws.Name, peerID)
+			}
+		}
+	}
+
+	// the ledger on disk is what the store holds
+	for _, ws := range nodes {
+		reopened := NewMetadataStore(ws.Store.backend)
+		if err := reopened.Load(); err != nil {
+			t.Fatalf("%s: reload: %v", ws.Name, err)
+		}
+		if got := ledgerDigest(reopened.Snapshot()); got != want {
+			t.Errorf("%s: persisted ledger digest %s, want %s", ws.Name, shortID(got), shortID(want))
+		}
+	}
+}
+
+// TestCloneIsDeep: changing a clone's tree or checkpoint leaves the original alone
+func TestCloneIsDeep(t *testing.T) {
+	v := FileVersion{VersionID: "v", Tree: &TreeObject{Entries: map[string]TreeEntry{"a": {CID: "c"}}},
+		Checkpoint: &Checkpoint{Squashed: []string{"x", "y"}}}
+	orig := FileMetadata{FileName: "f", Versions: map[string]FileVersion{"v": v}}
+	c := orig.Clone()
+	c.Versions["v"].Tree.Entries["a"] = TreeEntry{CID: "changed"}
+	c.Versions["v"].Checkpoint.Squashed[0] = "changed"
+	if orig.Versions["v"].Tree.Entries["a"].CID != "c" || orig.Versions["v"].Checkpoint.Squashed[0] != "x" {
+		t.Errorf("clone shares tree or checkpoint with the original")
+	}
+}
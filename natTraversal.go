@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Reach peers that sit behind NATs / firewalls on other networks
+
+1. -nat          AutoNAT service, UPnP/NAT-PMP port mapping, DCUtR hole punching [DONE]
+2. -relays       circuit relay v2 client: reserve a slot on these relays (autorelay) [DONE]
+3. -relay-service  act as a designated relay node, with per-circuit limits [DONE]
+4. Peer list shows whether a peer is reached directly or through a relay [DONE]
+5. go test: relay + two private hosts in one process, checks the relayed path and that
+   the relay cuts off bulk transfers (natTraversal_test.go) [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- A relayed ("limited") connection is first used for /hello; hole punching then
+  usually upgrades it to a direct one and later streams use that
+- /file-transfer waits up to holePunchWait for a direct connection (woken by connectedness
+  events, not polling) before it falls back to the relay, where the relay's data/duration
+  limit applies
+- Relay limits are per circuit (-relay-limit-data bytes, -relay-limit-duration), a
+  relay node is meant to carry handshakes and metadata, not file contents
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const holePunchWait = 10 * time.Second
+
+type NodeConfig struct {
+	Port             int
+	NAT              bool            // AutoNAT service + port mapping + hole punching
+	Relays           []peer.AddrInfo // static relays to reserve a slot on
+	RelayService     bool            // be a relay for others
+	RelayLimitData   int64           // bytes per relayed circuit
+	RelayLimitTime   time.Duration   // lifetime of a relayed circuit
+	ForcePrivate     bool            // pretend to be behind a NAT (tests)
+	ListenLoopback   bool            // only listen on 127.0.0.1 (tests)
+	DisableHolePunch bool            // keep relayed connections relayed (tests)
+}
+
+// relayResources are the limits a relay node enforces on every reservation/circuit
+func relayResources(cfg NodeConfig) relayv2.Resources {
+	rc := relayv2.DefaultResources()
+	rc.Limit = &relayv2.RelayLimit{Duration: cfg.RelayLimitTime, Data: cfg.RelayLimitData}
+	rc.MaxCircuits = 16
+	rc.MaxReservationsPerIP = 4
+	return rc
+}
+
+// nodeOptions turns the config into libp2p options for createNode
+func nodeOptions(cfg NodeConfig) []libp2p.Option {
+	var opts []libp2p.Option
+	switch {
+	case cfg.ListenLoopback:
+		opts = append(opts, libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", cfg.Port)))
+	case cfg.Port != 0:
+		// a fixed port makes this node usable as someone else's -bootstrap peer
+		opts = append(opts, libp2p.ListenAddrStrings(
+			fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", cfg.Port),
+			fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", cfg.Port),
+		))
+	}
+	if cfg.NAT {
+		opts = append(opts, libp2p.NATPortMap(), libp2p.EnableNATService())
+		if !cfg.DisableHolePunch {
+			opts = append(opts, libp2p.EnableHolePunching())
+		}
+	}
+	if len(cfg.Relays) > 0 {
+		opts = append(opts, libp2p.EnableRelay(), libp2p.EnableAutoRelayWithStaticRelays(cfg.Relays))
+	}
+	if cfg.RelayService {
+		opts = append(opts, libp2p.EnableRelayService(relayv2.WithResources(relayResources(cfg))))
+		if !cfg.ForcePrivate {
+			// a designated relay must be reachable, don't wait for AutoNAT to agree
+			opts = append(opts, libp2p.ForceReachabilityPublic())
+		}
+	}
+	if cfg.ForcePrivate {
+		opts = append(opts, libp2p.ForceReachabilityPrivate())
+	}
+	return opts
+}
+
+func isRelayedAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// connPath says how we currently reach a peer: "direct", "relayed" or "-"
+func connPath(h host.Host, id peer.ID) string {
+	conns := h.Network().ConnsToPeer(id)
+	if len(conns) == 0 {
+		return "-"
+	}
+	for _, c := range conns {
+		if !c.Stat().Limited && !isRelayedAddr(c.RemoteMultiaddr()) {
+			return "direct"
+		}
+	}
+	relay := ""
+	if relayID, err := conns[0].RemoteMultiaddr().ValueForProtocol(ma.P_P2P); err == nil {
+		relay = " via " + shortID(relayID)
+	}
+	return "relayed" + relay
+}
+
+// syncStreamContext lets small protocols (/hello) run over relayed connections
+func syncStreamContext(ctx context.Context) context.Context {
+	return network.WithAllowLimitedConn(ctx, "metadata sync")
+}
+
+// transferStreamContext gives hole punching a chance to replace a relayed connection
+// before a transfer starts; if it doesn't, the transfer goes through the relay's limits
+func transferStreamContext(ctx context.Context, h host.Host, id peer.ID) context.Context {
+	waitForDirect(ctx, h, id, holePunchWait)
+	if path := connPath(h, id); strings.HasPrefix(path, "relayed") {
+		log.Printf("[nat][transferStreamContext] Only a relayed path to %s, the relay's limits apply to this transfer", shortID(id.String()))
+	}
+	return network.WithAllowLimitedConn(ctx, "file transfer")
+}
+
+// waitForDirect returns once id is reached directly (or not at all), after max, or when ctx ends
+func waitForDirect(ctx context.Context, h host.Host, id peer.ID, max time.Duration) {
+	if !strings.HasPrefix(connPath(h, id), "relayed") {
+		return
+	}
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
+	if err != nil {
+		log.Printf("[nat][waitForDirect] %v", err)
+		return
+	}
+	defer sub.Close()
+	timer := time.NewTimer(max)
+	defer timer.Stop()
+	for strings.HasPrefix(connPath(h, id), "relayed") { // checked after subscribing, no upgrade is missed
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case _, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// TestNATRelay builds relay R and private hosts A and B on loopback, connects B to A
+// only through R, checks small exchanges work and bulk data is cut off by R's limit
+func TestNATRelay(t *testing.T) {
+	const echoProto = "/peerlink/nat-test/echo/1.0.0"
+	const sinkProto = "/peerlink/nat-test/sink/1.0.0"
+	limit := int64(64 << 10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	relayHost, err := libp2p.New(nodeOptions(NodeConfig{ListenLoopback: true, RelayService: true,
+		RelayLimitData: limit, RelayLimitTime: time.Minute})...)
+	if err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	defer relayHost.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	private := NodeConfig{ListenLoopback: true, ForcePrivate: true, DisableHolePunch: true}
+	a, err := libp2p.New(nodeOptions(private)...)
+	if err != nil {
+		t.Fatalf("host A: %v", err)
+	}
+	defer a.Close()
+	b, err := libp2p.New(nodeOptions(private)...)
+	if err != nil {
+		t.Fatalf("host B: %v", err)
+	}
+	defer b.Close()
+
+	a.SetStreamHandler(echoProto, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	received := make(chan int64, 1)
+	a.SetStreamHandler(sinkProto, func(s network.Stream) {
+		defer s.Close()
+		n, _ := io.Copy(io.Discard, s)
+		received <- n
+	})
+
+	if err := a.Connect(ctx, relayInfo); err != nil {
+		t.Fatalf("A → relay: %v", err)
+	}
+	if _, err := client.Reserve(ctx, a, relayInfo); err != nil {
+		t.Fatalf("reservation failed: %v", err)
+	}
+	circuit, err := ma.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s", relayHost.ID(), a.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(relayHost.Addrs()[0].Encapsulate(circuit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Connect(ctx, *info); err != nil {
+		t.Fatalf("B → A via relay: %v", err)
+	}
+	if path := connPath(b, a.ID()); !strings.HasPrefix(path, "relayed") {
+		t.Fatalf("expected a relayed path, got %q", path)
+	}
+
+	// no direct path will come: waiting for one stops with the caller's context
+	waitCtx, waitCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	start := time.Now()
+	transferStreamContext(waitCtx, b, a.ID())
+	waitCancel()
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("transferStreamContext ignored its context, waited %s", waited)
+	}
+
+	// small exchange: must get through
+	s, err := b.NewStream(syncStreamContext(ctx), a.ID(), echoProto)
+	if err != nil {
+		t.Fatalf("stream over relay: %v", err)
+	}
+	msg := []byte("hello through the relay")
+	s.Write(msg)
+	s.CloseWrite()
+	reply, err := io.ReadAll(s)
+	if err != nil || string(reply) != string(msg) {
+		t.Fatalf("echo over relay failed: %q %v", reply, err)
+	}
+
+	// bulk transfer: the relay must cut it off around its data limit
+	s, err = b.NewStream(syncStreamContext(ctx), a.ID(), sinkProto)
+	if err != nil {
+		t.Fatalf("second stream: %v", err)
+	}
+	chunk := make([]byte, 16<<10)
+	var writeErr error
+	for sent := int64(0); sent < 4*limit && writeErr == nil; sent += int64(len(chunk)) {
+		_, writeErr = s.Write(chunk)
+	}
+	s.CloseWrite()
+	var got int64
+	select {
+	case got = <-received:
+	case <-time.After(5 * time.Second): // the circuit died before A even saw the stream
+	}
+	if writeErr == nil || got >= 4*limit {
+		t.Fatalf("relay let %d bytes through, limit is %d", got, limit)
+	}
+}
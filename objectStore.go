@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+1. Local content addressed object store for versions we author or fetch [DONE]
+2. Objects live in .peerlink/objects/<first 2 chars of CID>/<CID> like git's loose objects [DONE]
+3. Retention: keep last N versions per file, keep versions younger than N days, keep pinned (tagged) ones [DONE]
+4. /file-transfer can serve any stored object by CID (see handleFileRequest) [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- A version is kept if ANY retention rule keeps it; heads are always kept
+- KeepLast = 0 and KeepDays = 0 means "keep everything"
+- Writes go to a temp file and are renamed into place, so a crash never leaves a
+  half written object under a valid CID
+- with KeepTagged, tagged versions (refs.go) and CIDs in pins.json are never pruned
+- Objects stored or reused within objectPruneGrace are never pruned: Prune works on a
+  ledger snapshot, the version naming a fresh object may be committed after it
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const objectStoreDir = ".peerlink/objects"
+
+// objectPruneGrace keeps objects stored (or stored again) this recently, whatever the ledger says
+const objectPruneGrace = time.Hour
+
+type RetentionPolicy struct {
+	KeepLast   int  // newest N versions of every file
+	KeepDays   int  // versions younger than N days
+	KeepTagged bool // pinned CIDs are never pruned
+}
+
+type ObjectStore struct {
+	dir       string
+	retention RetentionPolicy
+	mu        sync.Mutex
+	pins      map[string]struct{}
+}
+
+func NewObjectStore(dir string, retention RetentionPolicy) (*ObjectStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("[objectStore][NewObjectStore] cannot create %s: %w", dir, err)
+	}
+	st := &ObjectStore{dir: dir, retention: retention, pins: make(map[string]struct{})}
+	data, err := os.ReadFile(filepath.Join(dir, "pins.json"))
+	if err == nil {
+		var pins []string
+		if err := json.Unmarshal(data, &pins); err != nil {
+			return nil, fmt.Errorf("[objectStore][NewObjectStore] bad pins.json: %w", err)
+		}
+		for _, cid := range pins {
+			st.pins[cid] = struct{}{}
+		}
+	}
+	return st, nil
+}
+
+// Path is where an object lives (whether or not it exists). CIDs come from peers,
+// so anything but a hex SHA-256 is refused before it can name a path.
+func (st *ObjectStore) Path(cid string) (string, error) {
+	if !validCID(cid) {
+		return "", fmt.Errorf("[objectStore][Path] %q is not a valid CID", cid)
+	}
+	return filepath.Join(st.dir, cid[:2], cid), nil
+}
+
+// validCID reports whether cid is a lowercase hex SHA-256, the only form contentCID produces
+func validCID(cid string) bool {
+	if len(cid) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range cid {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (st *ObjectStore) Has(cid string) bool {
+	path, err := st.Path(cid)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func (st *ObjectStore) Get(cid string) ([]byte, error) {
+	path, err := st.Path(cid)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// Put stores content and returns its CID
+func (st *ObjectStore) Put(data []byte) (string, error) {
+	cid := contentCID(data)
+	if st.Has(cid) {
+		st.touch(cid)
+		return cid, nil
+	}
+	return cid, st.writeObject(cid, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// PutFile copies a file into the store, hashing while copying
+func (st *ObjectStore) PutFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("[objectStore][PutFile] cannot open %s: %w", path, err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(st.dir, "incoming-*")
+	if err != nil {
+		return "", fmt.Errorf("[objectStore][PutFile] temp file failed: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), src); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("[objectStore][PutFile] copy failed: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	cid := hex.EncodeToString(h.Sum(nil))
+	if st.Has(cid) {
+		st.touch(cid)
+		return cid, nil
+	}
+	dest, _ := st.Path(cid) // hashed here, always valid
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", fmt.Errorf("[objectStore][PutFile] rename failed: %w", err)
+	}
+	return cid, nil
+}
+
+// touch restarts an existing object's prune grace, a new version is about to reference it
+func (st *ObjectStore) touch(cid string) {
+	if path, err := st.Path(cid); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+	}
+}
+
+// PutStream stores what fill writes as cid, without holding it in memory; content that does not
+// hash to cid is discarded
+func (st *ObjectStore) PutStream(cid string, fill func(w io.Writer) error) error {
+	h := sha256.New()
+	return st.writeObject(cid, func(w io.Writer) error {
+		if err := fill(io.MultiWriter(w, h)); err != nil {
+			return err
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != cid {
+			return fmt.Errorf("content hash %s does not match CID %s", got, cid)
+		}
+		return nil
+	})
+}
+
+func (st *ObjectStore) writeObject(cid string, write func(w io.Writer) error) error {
+	dest, err := st.Path(cid)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(st.dir, "incoming-*")
+	if err != nil {
+		return fmt.Errorf("[objectStore][writeObject] temp file failed: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("[objectStore][writeObject] write failed: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// Pin protects a CID from pruning (used for tagged versions)
+func (st *ObjectStore) Pin(cid string) error {
+	if !validCID(cid) {
+		return fmt.Errorf("[objectStore][Pin] %q is not a valid CID", cid)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.pins[cid]; ok {
+		return nil
+	}
+	st.pins[cid] = struct{}{}
+	return st.savePinsLocked()
+}
+
+func (st *ObjectStore) savePinsLocked() error {
+	pins := make([]string, 0, len(st.pins))
+	for cid := range st.pins {
+		pins = append(pins, cid)
+	}
+	sort.Strings(pins)
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(st.dir, "pins.json"), data, 0644)
+}
+
+// keepSet applies the retention policy to the ledger and returns every CID that must stay
+func (st *ObjectStore) keepSet(metaMap map[string]FileMetadata, now time.Time) map[string]struct{} {
+	keep := make(map[string]struct{})
+	st.mu.Lock()
+	if st.retention.KeepTagged {
+		for cid := range st.pins {
+			keep[cid] = struct{}{}
+		}
+	}
+	st.mu.Unlock()
+
+	horizon := now.Add(-time.Duration(st.retention.KeepDays) * 24 * time.Hour)
+	for _, meta := range metaMap {
+		for _, h := range meta.Heads {
+			keep[meta.Versions[h].CID] = struct{}{}
+		}
+		if st.retention.KeepTagged {
+			for _, t := range meta.Tags {
+				keep[meta.Versions[t.VersionID].CID] = struct{}{}
+			}
+		}
+		versions := SortedVersions(meta)
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			newestN := len(versions)-1-i < st.retention.KeepLast
+			recent := st.retention.KeepDays > 0 && time.Unix(0, v.orderKey().WallTime).After(horizon)
+			if newestN || recent {
+				keep[v.CID] = struct{}{}
+			}
+			// snapshots are explicit requests to keep a folder state, their files stay
+			if v.Tree != nil {
+				for _, e := range v.Tree.Entries {
+					keep[e.CID] = struct{}{}
+				}
+			}
+		}
+	}
+	return keep
+}
+
+// Prune deletes objects no retention rule keeps. dryRun only reports.
+func (st *ObjectStore) Prune(metaMap map[string]FileMetadata, dryRun bool) ([]string, error) {
+	return st.pruneAt(metaMap, dryRun, time.Now())
+}
+
+func (st *ObjectStore) pruneAt(metaMap map[string]FileMetadata, dryRun bool, now time.Time) ([]string, error) {
+	if st.retention.KeepLast == 0 && st.retention.KeepDays == 0 {
+		return nil, nil // unlimited retention
+	}
+	keep := st.keepSet(metaMap, now)
+
+	var removed []string
+	err := filepath.Walk(st.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Dir(path) == st.dir {
+			return nil // pins.json and temp files live at the top level
+		}
+		cid := info.Name()
+		if _, ok := keep[cid]; ok {
+			return nil
+		}
+		if now.Sub(info.ModTime()) < objectPruneGrace {
+			return nil // stored after metaMap was taken, its version may not be committed yet
+		}
+		removed = append(removed, cid)
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return removed, fmt.Errorf("[objectStore][Prune] walk failed: %w", err)
+	}
+	return removed, nil
+}
+
+// startObjectPruning applies retention to the workspace's objects now and then every interval
+func startObjectPruning(ctx context.Context, ws *Workspace, interval time.Duration) {
+	prune := func() {
+		removed, err := ws.Objects.Prune(ws.Store.Snapshot(), false)
+		if err != nil {
+			log.Printf("[objectStore][startObjectPruning] %v", err)
+			return
+		}
+		if len(removed) > 0 {
+			log.Printf("[objectStore][startObjectPruning] Pruned %d old object(s) in %s", len(removed), ws.Name)
+		}
+	}
+	prune()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				prune()
+			}
+		}
+	}()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestObjectStoreRefusesBadCIDs: a CID from a peer can never name a path outside the store
+func TestObjectStoreRefusesBadCIDs(t *testing.T) {
+	st, err := NewObjectStore(filepath.Join(t.TempDir(), "objects"), RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid, err := st.Put([]byte("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Path(cid); err != nil {
+		t.Errorf("valid CID refused: %v", err)
+	}
+	for _, bad := range []string{"", "ab", "../../etc/passwd", cid[:63] + "/", cid[:63] + "G", cid + "00"} {
+		if _, err := st.Path(bad); err == nil {
+			t.Errorf("Path(%q) accepted", bad)
+		}
+		if st.Has(bad) {
+			t.Errorf("Has(%q) true", bad)
+		}
+		if err := st.PutStream(bad, func(w io.Writer) error { return nil }); err == nil {
+			t.Errorf("PutStream(%q) accepted", bad)
+		}
+	}
+}
+
+// retentionFixture stores five versions of one file, a day apart, and ages their objects
+func retentionFixture(t *testing.T, policy RetentionPolicy, now time.Time) (*ObjectStore, FileMetadata, []string) {
+	t.Helper()
+	st, err := NewObjectStore(filepath.Join(t.TempDir(), "objects"), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := FileMetadata{FileName: "notes.txt", Versions: make(map[string]FileVersion)}
+	var cids []string
+	var parents []string
+	for i := 0; i < 5; i++ {
+		cid, err := st.Put([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := now.Add(-time.Duration(5-i) * 24 * time.Hour)
+		path, _ := st.Path(cid)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		v := NewFileVersion("peer", "edit", cid, parents)
+		v.HLC = HLCTimestamp{WallTime: old.UnixNano()}
+		meta.AddVersion(v)
+		cids = append(cids, cid)
+		parents = []string{v.VersionID}
+	}
+	return st, meta, cids
+}
+
+func prunedSet(t *testing.T, st *ObjectStore, meta FileMetadata, now time.Time) map[string]bool {
+	t.Helper()
+	removed, err := st.pruneAt(map[string]FileMetadata{meta.FileName: meta}, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]bool)
+	for _, cid := range removed {
+		out[cid] = true
+	}
+	return out
+}
+
+// TestPruneRetention: KeepLast, KeepDays and KeepTagged each keep what they promise, the head always stays
+func TestPruneRetention(t *testing.T) {
+	now := time.Now()
+
+	st, meta, cids := retentionFixture(t, RetentionPolicy{KeepLast: 2}, now)
+	if got := prunedSet(t, st, meta, now); len(got) != 3 || got[cids[3]] || got[cids[4]] {
+		t.Errorf("KeepLast 2 pruned %v", got)
+	}
+
+	st, meta, cids = retentionFixture(t, RetentionPolicy{KeepDays: 4}, now)
+	if got := prunedSet(t, st, meta, now); len(got) != 2 || !got[cids[0]] || !got[cids[1]] {
+		t.Errorf("KeepDays 4 pruned %v", got)
+	}
+
+	st, meta, cids = retentionFixture(t, RetentionPolicy{KeepLast: 1, KeepTagged: true}, now)
+	meta.Tags = map[string]Tag{"v1": {Name: "v1", VersionID: SortedVersions(meta)[1].VersionID}}
+	if err := st.Pin(cids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := prunedSet(t, st, meta, now); len(got) != 2 || got[cids[0]] || got[cids[1]] || got[cids[4]] {
+		t.Errorf("KeepTagged pruned %v", got)
+	}
+}
+
+// TestPruneGrace: an object stored after the ledger snapshot survives until its version is committed
+func TestPruneGrace(t *testing.T) {
+	now := time.Now()
+	st, meta, _ := retentionFixture(t, RetentionPolicy{KeepLast: 1}, now)
+	fresh, err := st.Put([]byte("not in the ledger yet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prunedSet(t, st, meta, now); got[fresh] {
+		t.Errorf("fresh object pruned")
+	}
+	if got := prunedSet(t, st, meta, now.Add(2*objectPruneGrace)); !got[fresh] {
+		t.Errorf("unreferenced object kept past the grace window")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+knownPeers / knownFiles used to grow forever
+
+1. Last-seen timestamp per peer from connection events, announcements and heartbeats [DONE]
+2. States: online, unreachable (no connection / ping failing), gone [DONE]
+3. Gone peers are dropped from knownPeers and every workspace's members [DONE]
+4. Listings expire after the TTL they were announced with, listings of older nodes
+   (no TTL) once they are older than announcementTTL and the peer is not online [DONE]
+5. CLI shows each peer's state and how old its file listing is [DONE]
+6. peers also shows the connection path, direct or relayed (natTraversal.go) [DONE]
+7. Gone peers are evicted from the tracker itself after peerEvictAfter, so it does not
+   grow with every peer ever seen [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+ online ──(disconnect / ping fails)──▶ unreachable ──(unseen > peerGoneAfter)──▶ gone
+    ▲                                       │                                     │
+    └────────(connect, ping, announce)──────┘                (unseen > peerEvictAfter)
+                                                                                  ▼
+                                                                               evicted
+
+- Only peers we discovered or heard an announcement from are tracked, random DHT
+  connections are not
+- A gone peer that comes back (mDNS, static peer, DHT) simply starts over as online
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	heartbeatInterval = 30 * time.Second
+	peerGoneAfter     = 10 * time.Minute
+	peerEvictAfter    = time.Hour // gone peers stay listed as gone this long
+	announcementTTL   = 5 * time.Minute
+)
+
+type PeerState int
+
+const (
+	PeerOnline PeerState = iota
+	PeerUnreachable
+	PeerGone
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerOnline:
+		return "online"
+	case PeerUnreachable:
+		return "unreachable"
+	}
+	return "gone"
+}
+
+type peerHealth struct {
+	LastSeen time.Time
+	State    PeerState
+	RTT      time.Duration
+}
+
+type LivenessTracker struct {
+	mu    sync.Mutex
+	h     host.Host
+	peers map[string]*peerHealth
+}
+
+var liveness *LivenessTracker
+
+func NewLivenessTracker(h host.Host) *LivenessTracker {
+	t := &LivenessTracker{h: h, peers: make(map[string]*peerHealth)}
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if ph, ok := t.peers[c.RemotePeer().String()]; ok {
+				ph.State = PeerOnline
+				ph.LastSeen = time.Now()
+			}
+		},
+		DisconnectedF: func(n network.Network, c network.Conn) {
+			if n.Connectedness(c.RemotePeer()) == network.Connected {
+				return // another connection is still open
+			}
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if ph, ok := t.peers[c.RemotePeer().String()]; ok && ph.State == PeerOnline {
+				ph.State = PeerUnreachable
+				ph.LastSeen = time.Now()
+			}
+		},
+	})
+	return t
+}
+
+// Seen records a sign of life from peerID and starts tracking it if needed
+func (t *LivenessTracker) Seen(peerID string) {
+	if t == nil || peerID == t.h.ID().String() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ph, ok := t.peers[peerID]
+	if !ok {
+		ph = &peerHealth{}
+		t.peers[peerID] = ph
+	}
+	ph.State = PeerOnline
+	ph.LastSeen = time.Now()
+}
+
+// State returns what we know about peerID (untracked peers count as unreachable)
+func (t *LivenessTracker) State(peerID string) peerHealth {
+	if t == nil {
+		return peerHealth{State: PeerOnline}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ph, ok := t.peers[peerID]; ok {
+		return *ph
+	}
+	return peerHealth{State: PeerUnreachable}
+}
+
+// Start runs heartbeats and expiry every heartbeatInterval
+func (t *LivenessTracker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			t.heartbeat(ctx)
+			t.expire()
+		}
+	}()
+}
+
+// heartbeat pings every tracked peer that isn't gone
+func (t *LivenessTracker) heartbeat(ctx context.Context) {
+	t.mu.Lock()
+	ids := make([]string, 0, len(t.peers))
+	for id, ph := range t.peers {
+		if ph.State != PeerGone {
+			ids = append(ids, id)
+		}
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		pid, err := peer.Decode(id)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(id string, pid peer.ID) {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			if t.h.Network().Connectedness(pid) != network.Connected {
+				knownPeersLock.Lock()
+				pi, ok := knownPeers[id]
+				knownPeersLock.Unlock()
+				if !ok || t.h.Connect(pingCtx, pi) != nil {
+					t.markUnreachable(id)
+					return
+				}
+			}
+			res := <-ping.Ping(pingCtx, t.h, pid)
+			if res.Error != nil {
+				t.markUnreachable(id)
+				return
+			}
+			t.mu.Lock()
+			if ph, ok := t.peers[id]; ok {
+				ph.State = PeerOnline
+				ph.LastSeen = time.Now()
+				ph.RTT = res.RTT
+			}
+			t.mu.Unlock()
+		}(id, pid)
+	}
+	wg.Wait()
+}
+
+func (t *LivenessTracker) markUnreachable(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ph, ok := t.peers[id]; ok && ph.State == PeerOnline {
+		ph.State = PeerUnreachable
+	}
+}
+
+// expire moves long unseen peers to gone, evicts long gone ones and drops stale listings
+func (t *LivenessTracker) expire() {
+	now := time.Now()
+	var gone []string
+	t.mu.Lock()
+	for id, ph := range t.peers {
+		switch {
+		case ph.State == PeerUnreachable && now.Sub(ph.LastSeen) > peerGoneAfter:
+			ph.State = PeerGone
+			gone = append(gone, id)
+		case ph.State == PeerGone && now.Sub(ph.LastSeen) > peerEvictAfter:
+			delete(t.peers, id) // Seen starts tracking it again if it ever comes back
+		}
+	}
+	t.mu.Unlock()
+
+	for _, id := range gone {
+		log.Printf("[liveness][expire] Peer %s unseen for %s, forgetting it", shortID(id), peerGoneAfter)
+		knownPeersLock.Lock()
+		delete(knownPeers, id)
+		knownPeersLock.Unlock()
+		for _, ws := range workspaces {
+			ws.forgetPeer(id)
+		}
+	}
+
+	for _, ws := range workspaces {
+		ws.filesLock.Lock()
+		for id, at := range ws.filesAt {
+			age := now.Sub(at)
+			var expired bool
+			if ttl := ws.filesTTL[id]; ttl > 0 {
+				expired = age > ttl // the peer re-announces well within its TTL, online or not
+			} else {
+				expired = age > announcementTTL && t.State(id).State != PeerOnline // older node, announces on demand only
+			}
+			if !expired {
+				continue
+			}
+			log.Printf("[liveness][expire] Listing of %s in %s is %s old and was not renewed, dropping it", shortID(id), ws.Name, age.Round(time.Second))
+			delete(ws.files, id)
+			delete(ws.filesDigest, id)
+			delete(ws.filesAt, id)
+			delete(ws.filesTTL, id)
+		}
+		ws.filesLock.Unlock()
+	}
+}
+
+// Print lists tracked peers with their state
+func (t *LivenessTracker) Print() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.peers))
+	for id := range t.peers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Println("👥 Peers:")
+	if len(ids) == 0 {
+		fmt.Println("   (none yet)")
+	}
+	for _, id := range ids {
+		ph := t.peers[id]
+		rtt := ""
+		if ph.RTT > 0 && ph.State == PeerOnline {
+			rtt = fmt.Sprintf(", rtt %s", ph.RTT.Round(time.Millisecond))
+		}
+		path := "-"
+		if pid, err := peer.Decode(id); err == nil {
+			path = connPath(t.h, pid)
+		}
+		fmt.Printf("   %s %s  %s (%s), last seen %s ago%s\n", stateIcon(ph.State), id, ph.State, path, time.Since(ph.LastSeen).Round(time.Second), rtt)
+	}
+}
+
+func stateIcon(s PeerState) string {
+	switch s {
+	case PeerOnline:
+		return "🟢"
+	case PeerUnreachable:
+		return "🟠"
+	}
+	return "⚫"
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+// TestLivenessEvictsGonePeers: unreachable peers turn gone, gone peers leave the tracker
+func TestLivenessEvictsGonePeers(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	tr := NewLivenessTracker(h)
+	now := time.Now()
+	tr.peers["fresh"] = &peerHealth{State: PeerUnreachable, LastSeen: now}
+	tr.peers["stale"] = &peerHealth{State: PeerUnreachable, LastSeen: now.Add(-peerGoneAfter - time.Minute)}
+	tr.peers["long-gone"] = &peerHealth{State: PeerGone, LastSeen: now.Add(-peerEvictAfter - time.Minute)}
+
+	tr.expire()
+	if got := tr.State("fresh").State; got != PeerUnreachable {
+		t.Errorf("fresh peer is %s", got)
+	}
+	if ph, ok := tr.peers["stale"]; !ok || ph.State != PeerGone {
+		t.Errorf("stale peer not marked gone: %+v", ph)
+	}
+	if _, ok := tr.peers["long-gone"]; ok {
+		t.Errorf("long gone peer still tracked")
+	}
+
+	tr.Seen("long-gone") // it came back
+	if got := tr.State("long-gone").State; got != PeerOnline {
+		t.Errorf("returning peer is %s", got)
+	}
+}
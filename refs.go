@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Named references on file and folder (snapshot) histories
+
+1. Tags: immutable, signed with the author's libp2p key, synced with the ledger [DONE]
+2. Branches: movable pointers with their own CRDT merge rule [DONE]
+3. tag / tags / branch / branches CLI commands [DONE]
+4. "<file>@<ref>" accepted by history commands and /file-transfer [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                             # MERGE RULES
+
+Tags     a tag name is written once. Unsigned or badly signed tags are dropped.
+         If two peers created the same name for different versions, every node
+         keeps the first one (lowest HLC, then author, then version ID), so all
+         ledgers converge; the other one is reported (log, tags) per workspace.
+Branches fast-forward first: if one side's target descends from the other's,
+         the descendant wins. Otherwise last writer (HLC, then author) wins.
+
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+// refSeparator splits "<file>@<ref>" (versions can't contain '@', file names rarely do)
+const refSeparator = "@"
+
+type Tag struct {
+	Name      string       `json:"name"`
+	VersionID string       `json:"version_id"`
+	Author    string       `json:"author"`
+	HLC       HLCTimestamp `json:"hlc"`
+	Signature []byte       `json:"signature"`
+}
+
+type Branch struct {
+	Name      string       `json:"name"`
+	VersionID string       `json:"version_id"`
+	Author    string       `json:"author"` // who moved it last
+	HLC       HLCTimestamp `json:"hlc"`
+}
+
+// signingPayload binds the tag to its file so it can't be replayed onto another file
+func (t Tag) signingPayload(fileName string) []byte {
+	return []byte(fmt.Sprintf("peerlink-tag|%s|%s|%s|%s|%d|%d",
+		fileName, t.Name, t.VersionID, t.Author, t.HLC.WallTime, t.HLC.Logical))
+}
+
+// verify checks the signature against the public key embedded in the author's peer ID
+func (t Tag) verify(fileName string) error {
+	author, err := peer.Decode(t.Author)
+	if err != nil {
+		return fmt.Errorf("[refs][verify] bad author %q: %w", t.Author, err)
+	}
+	pub, err := author.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("[refs][verify] cannot get public key of %s: %w", t.Author, err)
+	}
+	ok, err := pub.Verify(t.signingPayload(fileName), t.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("[refs][verify] invalid signature on tag %s", t.Name)
+	}
+	return nil
+}
+
+// tagConflicts holds, per workspace, the tags that lost a name to another version
+type tagConflicts struct {
+	mu      sync.Mutex
+	refused map[string][]Tag // "<file>@<tag>" → tags not adopted
+}
+
+// tagBefore is the order every node uses to pick the one tag of a name: lowest HLC, author, version ID
+func tagBefore(a, b Tag) bool {
+	if c := a.HLC.Compare(b.HLC); c != 0 {
+		return c < 0
+	}
+	if a.Author != b.Author {
+		return a.Author < b.Author
+	}
+	return a.VersionID < b.VersionID
+}
+
+// mergeTags applies the write-once rule the same way on every node; tags failing verification are dropped
+func mergeTags(fileName string, local, remote map[string]Tag) map[string]Tag {
+	out := make(map[string]Tag, len(local)+len(remote))
+	for _, side := range []map[string]Tag{local, remote} {
+		for name, t := range side {
+			if err := t.verify(fileName); err != nil {
+				log.Printf("[refs][mergeTags] Dropping tag %s on %s: %v", name, fileName, err)
+				continue
+			}
+			if existing, ok := out[name]; !ok || tagBefore(t, existing) {
+				out[name] = t
+			}
+		}
+	}
+	return out
+}
+
+// report records (and logs once) every tag of local or remote that lost its name in merged
+func (c *tagConflicts) report(fileName string, merged map[string]Tag, sides ...map[string]Tag) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, side := range sides {
+		for name, t := range side {
+			kept, ok := merged[name]
+			if !ok || kept.VersionID == t.VersionID || t.verify(fileName) != nil {
+				continue
+			}
+			key := fileName + refSeparator + name
+			if c.has(key, t) {
+				continue
+			}
+			if c.refused == nil {
+				c.refused = make(map[string][]Tag)
+			}
+			c.refused[key] = append(c.refused[key], t)
+			log.Printf("[refs][mergeTags] ⚠️ Tag %s on %s was created by %s for %s and by %s for %s, keeping the earlier %s",
+				name, fileName, shortID(t.Author), shortID(t.VersionID), shortID(kept.Author), shortID(kept.VersionID), shortID(kept.VersionID))
+		}
+	}
+}
+
+func (c *tagConflicts) has(key string, t Tag) bool {
+	for _, other := range c.refused[key] {
+		if other.VersionID == t.VersionID && other.Author == t.Author {
+			return true
+		}
+	}
+	return false
+}
+
+// lost returns the tags not adopted for fileName@name
+func (c *tagConflicts) lost(fileName, name string) []Tag {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Tag(nil), c.refused[fileName+refSeparator+name]...)
+}
+
+// mergeBranches applies fast-forward, then last-writer-wins
+func mergeBranches(merged FileMetadata, local, remote map[string]Branch) map[string]Branch {
+	out := make(map[string]Branch, len(local)+len(remote))
+	for name, b := range local {
+		out[name] = b
+	}
+	for name, theirs := range remote {
+		ours, ok := out[name]
+		if !ok || ours.VersionID == theirs.VersionID {
+			if !ok {
+				out[name] = theirs
+			}
+			continue
+		}
+		if _, ff := ancestorsOf(merged, []string{theirs.VersionID})[ours.VersionID]; ff {
+			out[name] = theirs
+			continue
+		}
+		if _, ff := ancestorsOf(merged, []string{ours.VersionID})[theirs.VersionID]; ff {
+			continue
+		}
+		if c := theirs.HLC.Compare(ours.HLC); c > 0 || (c == 0 && theirs.Author > ours.Author) {
+			out[name] = theirs
+		}
+	}
+	return out
+}
+
+// refsDigest summarizes a file's tags and branches so sync can tell if they differ
+func refsDigest(meta FileMetadata) string {
+	if len(meta.Tags) == 0 && len(meta.Branches) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(struct {
+		Tags     map[string]Tag    `json:"t"`
+		Branches map[string]Branch `json:"b"`
+	}{meta.Tags, meta.Branches})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// resolveRef turns a tag name, branch name or version ID prefix into a version ID
+func resolveRef(meta FileMetadata, ref string) (string, error) {
+	if t, ok := meta.Tags[ref]; ok {
+		return t.VersionID, nil
+	}
+	if b, ok := meta.Branches[ref]; ok {
+		return b.VersionID, nil
+	}
+	var found []string
+	for id := range meta.Versions {
+		if strings.HasPrefix(id, ref) {
+			found = append(found, id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("[refs][resolveRef] '%s' is not a tag, branch or version of %s", ref, meta.FileName)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("[refs][resolveRef] '%s' is ambiguous in %s", ref, meta.FileName)
+}
+
+// splitFileRef splits "<file>@<ref>"; ok is false when there is no ref part
+func splitFileRef(s string) (string, string, bool) {
+	i := strings.LastIndex(s, refSeparator)
+	if i <= 0 || i == len(s)-1 {
+		return s, "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func cmdTag(ws *Workspace, fileName, ref, name string) error {
+	privKey := node.Peerstore().PrivKey(node.ID())
+	if privKey == nil {
+		return fmt.Errorf("[refs][cmdTag] node has no private key to sign with")
+	}
+	var tagged FileVersion
+	_, err := ws.Store.Update("tag", func(tx *MetadataTxn) error {
+		meta, ok := tx.Get(fileName)
+		if !ok {
+			return fmt.Errorf("[refs][cmdTag] file '%s' is not in the ledger", fileName)
+		}
+		if _, exists := meta.Tags[name]; exists {
+			return fmt.Errorf("[refs][cmdTag] tag '%s' already exists on %s, tags are immutable", name, fileName)
+		}
+		versionID, err := resolveRef(meta, ref)
+		if err != nil {
+			return err
+		}
+		t := Tag{Name: name, VersionID: versionID, Author: node.ID().String(), HLC: hlcClock.Now()}
+		t.Signature, err = privKey.Sign(t.signingPayload(fileName))
+		if err != nil {
+			return fmt.Errorf("[refs][cmdTag] signing failed: %w", err)
+		}
+		if meta.Tags == nil {
+			meta.Tags = make(map[string]Tag)
+		}
+		meta.Tags[name] = t
+		tx.Put(meta)
+		tagged = meta.Versions[versionID]
+		fmt.Printf("🏷️  Tagged %s@%s → %s\n", fileName, name, shortID(versionID))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return pinVersion(ws, tagged)
+}
+
+// pinVersion keeps a tagged version's content through pruning; a snapshot pins every file of its tree
+func pinVersion(ws *Workspace, v FileVersion) error {
+	cids := []string{v.CID}
+	if v.Tree != nil {
+		cids = cids[:0]
+		for _, e := range v.Tree.Entries {
+			cids = append(cids, e.CID)
+		}
+	}
+	for _, cid := range cids {
+		if v.Deleted || cid == "" {
+			continue // a tombstone has no content
+		}
+		if err := ws.Objects.Pin(cid); err != nil {
+			return fmt.Errorf("[refs][pinVersion] cannot pin %s: %w", shortID(cid), err)
+		}
+	}
+	return nil
+}
+
+func cmdBranch(ws *Workspace, fileName, name, ref string) error {
+	_, err := ws.Store.Update("branch", func(tx *MetadataTxn) error {
+		meta, ok := tx.Get(fileName)
+		if !ok {
+			return fmt.Errorf("[refs][cmdBranch] file '%s' is not in the ledger", fileName)
+		}
+		var versionID string
+		if ref == "" {
+			head, ok := LatestHead(meta)
+			if !ok {
+				return fmt.Errorf("[refs][cmdBranch] %s has no versions", fileName)
+			}
+			versionID = head.VersionID
+		} else {
+			var err error
+			if versionID, err = resolveRef(meta, ref); err != nil {
+				return err
+			}
+		}
+		if _, isTag := meta.Tags[name]; isTag {
+			return fmt.Errorf("[refs][cmdBranch] '%s' is already a tag on %s", name, fileName)
+		}
+		if meta.Branches == nil {
+			meta.Branches = make(map[string]Branch)
+		}
+		meta.Branches[name] = Branch{Name: name, VersionID: versionID, Author: node.ID().String(), HLC: hlcClock.Now()}
+		tx.Put(meta)
+		fmt.Printf("🌿 Branch %s@%s → %s\n", fileName, name, shortID(versionID))
+		return nil
+	})
+	return err
+}
+
+// cmdListRefs prints tags (kind "tag") or branches (kind "branch") of one or all files
+func cmdListRefs(ws *Workspace, kind, fileName string) error {
+	var lines []string
+	for name, meta := range ws.Store.Snapshot() {
+		if fileName != "" && name != fileName {
+			continue
+		}
+		if kind == "tag" {
+			for _, t := range meta.Tags {
+				lines = append(lines, fmt.Sprintf("   %s@%-20s → %s  signed by %s", name, t.Name, shortID(t.VersionID), shortID(t.Author)))
+				for _, other := range ws.Store.tagConflicts.lost(name, t.Name) {
+					lines = append(lines, fmt.Sprintf("   %s@%-20s ⚠️ %s also tagged %s, not adopted", name, t.Name, shortID(other.Author), shortID(other.VersionID)))
+				}
+			}
+		} else {
+			for _, b := range meta.Branches {
+				lines = append(lines, fmt.Sprintf("   %s@%-20s → %s  moved by %s at %s", name, b.Name, shortID(b.VersionID), shortID(b.Author), b.HLC))
+			}
+		}
+	}
+	sort.Strings(lines)
+	if kind == "tag" {
+		fmt.Println("🏷️  Tags:")
+	} else {
+		fmt.Println("🌿 Branches:")
+	}
+	if len(lines) == 0 {
+		fmt.Println("   (none)")
+	}
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	return nil
+}
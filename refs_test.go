@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func signedTestTag(t *testing.T, fileName, name, versionID string, hlc HLCTimestamp) Tag {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := Tag{Name: name, VersionID: versionID, Author: id.String(), HLC: hlc}
+	if tag.Signature, err = priv.Sign(tag.signingPayload(fileName)); err != nil {
+		t.Fatal(err)
+	}
+	return tag
+}
+
+// tagOnly is a ledger entry of file carrying only tags
+func tagOnly(file string, tags ...Tag) map[string]FileMetadata {
+	meta := FileMetadata{FileName: file, Versions: map[string]FileVersion{}, Tags: map[string]Tag{}}
+	for _, t := range tags {
+		meta.Tags[t.Name] = t
+	}
+	return map[string]FileMetadata{file: meta}
+}
+
+// TestMergeTagsConverges: two nodes holding different tags of one name both end up with the
+// earlier one, whatever order they sync in, and each reports the other in its own workspace
+func TestMergeTagsConverges(t *testing.T) {
+	const file = "report.txt"
+	later := signedTestTag(t, file, "v1", "later", HLCTimestamp{WallTime: 200})
+	earlier := signedTestTag(t, file, "v1", "earlier", HLCTimestamp{WallTime: 100})
+	other := signedTestTag(t, file, "v2", "other", HLCTimestamp{WallTime: 100})
+
+	a, b, bystander := newTestWorkspace(t, "a"), newTestWorkspace(t, "b"), newTestWorkspace(t, "c")
+	for _, step := range []struct {
+		ws   *Workspace
+		tags []Tag
+	}{{a, []Tag{later}}, {b, []Tag{earlier, other}}, {bystander, []Tag{later}}} {
+		if _, err := step.ws.Store.MergeRemote("local", tagOnly(file, step.tags...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.Store.MergeRemote("b", tagOnly(file, earlier, other)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Store.MergeRemote("a", tagOnly(file, later)); err != nil {
+		t.Fatal(err)
+	}
+
+	ma, _ := a.Store.Get(file)
+	mb, _ := b.Store.Get(file)
+	if ma.Tags["v1"].VersionID != "earlier" || mb.Tags["v1"].VersionID != "earlier" {
+		t.Errorf("v1 is %s on a and %s on b, want the earlier tag on both", ma.Tags["v1"].VersionID, mb.Tags["v1"].VersionID)
+	}
+	if ma.Tags["v2"].VersionID != "other" {
+		t.Errorf("new tag v2 not adopted")
+	}
+	if refsDigest(ma) != refsDigest(mb) {
+		t.Errorf("refs digests differ after both merges")
+	}
+	for name, ws := range map[string]*Workspace{"a": a, "b": b} {
+		if lost := ws.Store.tagConflicts.lost(file, "v1"); len(lost) != 1 || lost[0].VersionID != "later" {
+			t.Errorf("%s: conflict not reported: %+v", name, lost)
+		}
+	}
+	if lost := bystander.Store.tagConflicts.lost(file, "v1"); len(lost) != 0 {
+		t.Errorf("conflict of another workspace reported: %+v", lost)
+	}
+}
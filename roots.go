@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+There used to be exactly one shared/ and one TransferredFiles/, relative to wherever
+the binary was started
+
+1. -home (or $PEERLINK_HOME) is where .peerlink/ and every relative folder live, so the
+   node can be started from any directory; shared and download folders are kept as
+   absolute paths [DONE]
+2. Any number of shared roots per workspace, each with a label, path, mode (rw/ro),
+   encryption requirement and ACL [DONE]
+3. Files are addressed as label:relative/path in announcements, the ledger and
+   transfers. The workspace's shared_dir is root "shared" and keeps plain names, so
+   existing ledgers and older nodes are unaffected [DONE]
+4. Downloads of label:path land in <download_dir>/label/path [DONE]
+5. roots in the CLI [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                        # .peerlink/workspaces.json
+
+ {"name": "design", "group": "design-team", "shared_dir": "/data/design", "sync": true,
+  "roots": [
+    {"label": "specs", "path": "/srv/specs", "mode": "ro"},
+    {"label": "hr", "path": "/srv/hr", "encrypt": true, "acl": ["12D3KooW..."]}
+  ]}
+
+──────────────────────────────────────────────────────────────────────────────
+                                # SETTINGS
+
+ mode     rw (default): sync and checkout write remote versions into the root
+          ro: we only publish it, nothing from peers is ever written there
+ encrypt  files are only served to requests made with -E
+ acl      peer IDs allowed to download from the root (files, folders, CIDs, text
+          snippets); empty = every member
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- The workspace stays the boundary for metadata: names, sizes and CIDs of every root
+  are announced and synced to all members, encrypt and acl guard the content
+- Content requested by CID is served if any file holding it may be served to the peer
+- A root syncs (folderSync.go) with the roots of the same label on other nodes, nodes
+  without that label only see the files in listings
+- Roots may not overlap, every root has its own .shareignore rules (shareIgnore.go)
+- A top level name of shared_dir like "word:rest" reads as the root "word", so such
+  files are listed but cannot be requested; keep them in a subfolder
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	primaryRootLabel = "shared"
+	rootReadWrite    = "rw"
+	rootReadOnly     = "ro"
+)
+
+var rootLabelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,31}$`)
+
+type SharedRoot struct {
+	Label   string   `json:"label"`
+	Path    string   `json:"path"`
+	Mode    string   `json:"mode,omitempty"`    // rootReadWrite (default) or rootReadOnly
+	Encrypt bool     `json:"encrypt,omitempty"` // only served over -E transfers
+	ACL     []string `json:"acl,omitempty"`     // peer IDs allowed to download, empty for every member
+
+	Ignore  *IgnoreRules `json:"-"`
+	primary bool
+}
+
+func (r *SharedRoot) ReadOnly() bool { return r.Mode == rootReadOnly }
+
+// Name is how a file of the root is addressed in listings, the ledger and transfers
+func (r *SharedRoot) Name(rel string) string {
+	if r.primary {
+		return rel
+	}
+	return r.Label + ":" + rel
+}
+
+// allows reports whether content of the root may be sent to peerID
+func (r *SharedRoot) allows(peerID string, encrypted bool) bool {
+	if r.Encrypt && !encrypted {
+		return false
+	}
+	if len(r.ACL) == 0 {
+		return true
+	}
+	for _, id := range r.ACL {
+		if id == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SharedRoot) restricted() bool {
+	return r.Encrypt || len(r.ACL) > 0
+}
+
+// checkRoots validates the roots of one workspace config
+func checkRoots(cfg WorkspaceConfig) error {
+	labels := map[string]bool{primaryRootLabel: true}
+	for i, r := range cfg.Roots {
+		switch {
+		case !rootLabelPattern.MatchString(r.Label):
+			return fmt.Errorf("workspace %q root %d: bad label %q (letters, digits, . _ -)", cfg.Name, i, r.Label)
+		case labels[r.Label]:
+			return fmt.Errorf("workspace %q: root label %q used twice (%q is the shared_dir)", cfg.Name, r.Label, primaryRootLabel)
+		case r.Path == "":
+			return fmt.Errorf("workspace %q root %q has no path", cfg.Name, r.Label)
+		case r.Mode != "" && r.Mode != rootReadWrite && r.Mode != rootReadOnly:
+			return fmt.Errorf("workspace %q root %q: mode must be %s or %s, not %q", cfg.Name, r.Label, rootReadWrite, rootReadOnly, r.Mode)
+		}
+		for _, id := range r.ACL {
+			if _, err := peer.Decode(id); err != nil {
+				return fmt.Errorf("workspace %q root %q: bad peer ID %q in acl", cfg.Name, r.Label, id)
+			}
+		}
+		labels[r.Label] = true
+	}
+	return nil
+}
+
+// openRoots makes every folder absolute, creates it and loads its ignore rules; the shared_dir comes first
+func (ws *Workspace) openRoots() error {
+	roots := append([]SharedRoot{{Label: primaryRootLabel, Path: ws.SharedDir, primary: true}}, ws.WorkspaceConfig.Roots...)
+	ws.Roots = make([]*SharedRoot, 0, len(roots))
+	for i := range roots {
+		r := roots[i]
+		abs, err := filepath.Abs(r.Path)
+		if err != nil {
+			return fmt.Errorf("[Roots][openRoots] %s: %w", r.Label, err)
+		}
+		r.Path = abs
+		if r.Mode == "" {
+			r.Mode = rootReadWrite
+		}
+		for _, other := range ws.Roots {
+			if within(other.Path, r.Path) || within(r.Path, other.Path) {
+				return fmt.Errorf("[Roots][openRoots] roots %q and %q of %s overlap", other.Label, r.Label, ws.Name)
+			}
+		}
+		if err := os.MkdirAll(r.Path, os.ModePerm); err != nil {
+			return fmt.Errorf("[Roots][openRoots] cannot create root %q: %w", r.Label, err)
+		}
+		r.Ignore = NewIgnoreRules(r.Path)
+		ws.Roots = append(ws.Roots, &r)
+	}
+	ws.SharedDir = ws.Roots[0].Path
+	return nil
+}
+
+// within reports whether p is dir or inside it
+func within(dir, p string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+// splitName finds the root a name belongs to: "label:rel" for other roots, anything else is the shared_dir's
+func (ws *Workspace) splitName(name string) (*SharedRoot, string, bool) {
+	if label, rel, ok := strings.Cut(name, ":"); ok && !strings.Contains(label, "/") {
+		for _, r := range ws.Roots {
+			if r.Label == label {
+				return r, rel, true
+			}
+		}
+		if label != primaryRootLabel && rootLabelPattern.MatchString(label) {
+			return nil, "", false // another node's root
+		}
+	}
+	return ws.Roots[0], name, true
+}
+
+// localPath is where a name lives on this node; ok=false for unknown roots and unclean paths
+func (ws *Workspace) localPath(name string) (string, *SharedRoot, bool) {
+	root, rel, ok := ws.splitName(name)
+	if !ok {
+		return "", nil, false
+	}
+	rel = strings.TrimSuffix(rel, "/")
+	if rel == "" {
+		return root.Path, root, true
+	}
+	if checkAnnouncedPath(rel) != nil {
+		return "", nil, false
+	}
+	return filepath.Join(root.Path, filepath.FromSlash(rel)), root, true
+}
+
+// sharedPath is localPath without the root, "" when the name has no place here
+func (ws *Workspace) sharedPath(name string) string {
+	p, _, _ := ws.localPath(name)
+	return p
+}
+
+// nameOf turns a path inside one of the roots into its name
+func (ws *Workspace) nameOf(p string) (string, *SharedRoot, bool) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", nil, false
+	}
+	for _, r := range ws.Roots {
+		if !within(r.Path, abs) {
+			continue
+		}
+		rel, err := filepath.Rel(r.Path, abs)
+		if err != nil || rel == "." {
+			return "", r, false
+		}
+		return r.Name(filepath.ToSlash(rel)), r, true
+	}
+	return "", nil, false
+}
+
+// ignoredName applies the ignore rules of the name's root
+func (ws *Workspace) ignoredName(name string, isDir bool) bool {
+	root, rel, ok := ws.splitName(name)
+	return ok && root.Ignore.Ignored(rel, isDir)
+}
+
+// walkShared calls fn for every file and folder of every root that is not ignored
+func (ws *Workspace) walkShared(fn func(root *SharedRoot, p, name string, info os.FileInfo) error) error {
+	for _, root := range ws.Roots {
+		err := filepath.Walk(root.Path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("[Roots][walkShared] Walk error: %v", err)
+				return nil
+			}
+			if p == root.Path {
+				return nil
+			}
+			rel, err := filepath.Rel(root.Path, p)
+			if err != nil {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			if root.Ignore.Ignored(rel, info.IsDir()) {
+				return skipIgnored(info.IsDir())
+			}
+			return fn(root, p, root.Name(rel), info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canServeCID reports whether content may go to peerID: some file of ours holding it must allow it
+func (ws *Workspace) canServeCID(cid, localPath, peerID string, encrypted bool) bool {
+	anyRestricted := false
+	for _, r := range ws.Roots {
+		anyRestricted = anyRestricted || r.restricted()
+	}
+	if !anyRestricted {
+		return true
+	}
+
+	denied := false
+	if _, root, ok := ws.nameOf(localPath); ok {
+		if root.allows(peerID, encrypted) {
+			return true
+		}
+		denied = true
+	}
+	for name, meta := range ws.Store.Snapshot() {
+		root, _, ok := ws.splitName(name)
+		if !ok {
+			continue // another node's root, nothing we guard
+		}
+		for _, v := range meta.Versions {
+			if v.CID != cid {
+				continue
+			}
+			if root.allows(peerID, encrypted) {
+				return true
+			}
+			denied = true
+		}
+	}
+	return !denied
+}
+
+// downloadPath maps a received name into the download folder, label:rel goes to label/rel
+func downloadPath(saveDir, name string) (string, error) {
+	if label, rel, ok := strings.Cut(name, ":"); ok && rootLabelPattern.MatchString(label) {
+		name = label + "/" + rel
+	}
+	if err := checkAnnouncedPath(name); err != nil {
+		return "", fmt.Errorf("[Roots][downloadPath] refusing %w", err)
+	}
+	return filepath.Join(saveDir, filepath.FromSlash(name)), nil
+}
+
+// resolveHome makes dir the working directory, so .peerlink/ and relative folders live there
+func resolveHome(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("[Roots][resolveHome] %w", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("[Roots][resolveHome] %w", err)
+	}
+	return nil
+}
+
+func cmdListRoots(ws *Workspace) error {
+	fmt.Printf("📂 Shared roots of %s:\n", ws.Name)
+	for _, r := range ws.Roots {
+		name := r.Label + ":"
+		if r.primary {
+			name += " (plain names)"
+		}
+		access := "every member"
+		if len(r.ACL) > 0 {
+			ids := make([]string, len(r.ACL))
+			for i, id := range r.ACL {
+				ids[i] = shortID(id)
+			}
+			access = strings.Join(ids, ", ")
+		}
+		enc := ""
+		if r.Encrypt {
+			enc = ", -E only"
+		}
+		fmt.Printf("   %-24s %s  %s, for %s%s\n", name, r.Path, r.Mode, access, enc)
+	}
+	fmt.Printf("   downloads → %s\n", ws.DownloadDir)
+	return nil
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,62 +13,75 @@ import (
 1. Every file inside shared/transferred folder must have associated metadata
 2. Full Git-like tracking support (CRDT + versions)
 3. .metadata file will act like a lightweight DLT ledger
-4. Ignore files like .shareignore can be added later (TODO)
+4. Ignore files: .shareignore with gitignore syntax (shareIgnore.go) [DONE]
+5. Ledger file carries a schema header and is migrated on load [DONE]
+6. JSON is one of two storage backends and the import/export format (metadataBackend.go) [DONE]
 
 ──────────────────────────────────────────────────────────────────────────────
                               # NOTES
 
-- fileMetadataMap = map[string]FileMetadata (global)
-- All operations (save/load) are based on this in-memory map
-- Future extensibility: Multiple folders, ignore patterns, partial syncs
+- a MetadataStore (one per workspace, see metadataStore.go) owns the in-memory ledger
+- save/load here only (de)serialize a map handed to them, locking is the store's job
+- Future extensibility: Multiple folders, partial syncs
 ──────────────────────────────────────────────────────────────────────────────
 */
 
-// Save current file metadata map into a metadata file
-func saveMetadataToFile(path string) error {
+// Save a file metadata map into a metadata file
+func saveMetadataToFile(path string, metaMap map[string]FileMetadata) error {
 	// Ensure directory exists
 	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
 	if err != nil {
 		return fmt.Errorf("[savingAndLoadingMetaData][saveMetaDataToFile]failed to create metadata folder: %w", err)
 	}
 
-	// Marshal the map into a nicely formatted JSON
-	data, err := json.MarshalIndent(fileMetadataMap, "", "  ")
+	// Marshal the map into a nicely formatted JSON with the schema header (see ledgerSchema.go)
+	data, err := encodeLedger(metaMap, true)
 	if err != nil {
 		return fmt.Errorf("[savingAndLoadingMetaData][saveMetaDataToFile] failed to marshal metadata: %w", err)
 	}
 
-	// Write the JSON to disk
-	err = os.WriteFile(path, data, 0644)
+	// Write the JSON next to the target and rename it over, so a crash never leaves half a ledger
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
 	if err != nil {
 		return fmt.Errorf("[savingAndLoadingMetaData][saveMetaDataToFile] failed to write metadata to file: %w", err)
 	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("[savingAndLoadingMetaData][saveMetaDataToFile] failed to replace metadata file: %w", err)
+	}
 
 	return nil
 }
 
 // Load file metadata map from a metadata file (if exists)
-func loadMetadataFromFile(path string) error {
+func loadMetadataFromFile(path string) (map[string]FileMetadata, error) {
+	metaMap := make(map[string]FileMetadata)
 	data, err := os.ReadFile(path)
 	if err != nil {
 		// If file not found, treat as empty metadata (not fatal error)
 		if os.IsNotExist(err) {
-			fileMetadataMap = make(map[string]FileMetadata)
-			return nil
+			return metaMap, nil
 		}
-		return fmt.Errorf("[savingAndLoadingMetaData[loadMetaDataFromFile]failed to read metadata file: %w", err)
+		return nil, fmt.Errorf("[savingAndLoadingMetaData[loadMetaDataFromFile]failed to read metadata file: %w", err)
+	}
+	if len(data) == 0 {
+		return metaMap, nil
 	}
 
-	// Decode JSON back into the global map
-	return json.Unmarshal(data, &fileMetadataMap)
+	// Decode (and migrate if it is an older schema) into a fresh map
+	env, err := decodeLedger(data)
+	if err != nil {
+		return nil, fmt.Errorf("[savingAndLoadingMetaData][loadMetaDataFromFile] failed to parse %s: %w", path, err)
+	}
+	return env.Files, nil
 }
 
 // (Optional Helper) Initialize .metadata file if missing
 func ensureMetadataFile(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		// No metadata exists, create a blank one
-		fileMetadataMap = make(map[string]FileMetadata)
-		return saveMetadataToFile(path)
+		return saveMetadataToFile(path, make(map[string]FileMetadata))
 	}
 	return nil // Metadata file already exists
 }
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Finding a file meant scrolling through showAvailableFiles and typing the exact path
+
+1. search <terms> publishes a query on the workspace's search topic: glob, substring,
+   extension, size range, CID prefix, content words (fulltext.go) [DONE]
+2. Every peer answers from its own listing (not from what it announced, so trimmed /
+   digest-only announcements don't matter) straight to the asker over /search-results/1.0.0 [DONE]
+3. Results are collected for searchTimeout, merged by name + content and ranked [DONE]
+4. get <n> downloads result n of the last search from one of the peers holding it [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # QUERY SYNTAX
+
+ search report             substring of the path (case insensitive)
+ search *.pdf docs/*       glob, on the whole path or the file name
+ search ext:pdf            extension
+ search size:>10M          size:<1K  size:1M-5M  (K/M/G are powers of 1024)
+ search cid:3c0501b3       content hash prefix
+ search text:budget        word in the content (find q3 budget = text:q3 text:budget, see fulltext.go)
+ terms combine with AND
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- Queries are signed and pass a validator like announcements (size, schema, own
+  token bucket), peers with no match stay silent
+- A result stream is only accepted for a query we have pending, and the answering
+  peer is taken from the connection, not from the message
+- Ranking: name match quality, then how many peers hold that content, then how many
+  of them are online
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	searchResultsProtocol = "/search-results/1.0.0"
+	legacySearchTopic     = "file-search"
+	searchTimeout         = 3 * time.Second
+	maxSearchQuerySize    = 4 << 10
+	defaultSearchLimit    = 50
+	maxSearchLimit        = 200
+	maxSearchResultsSize  = 4 << 20
+)
+
+type SearchQuery struct {
+	QueryID   string `json:"query_id"`
+	PeerID    string `json:"peer_id"`
+	Glob      string `json:"glob,omitempty"`
+	Substring string `json:"substring,omitempty"`
+	Ext       string `json:"ext,omitempty"`
+	MinSize   int64  `json:"min_size,omitempty"`
+	MaxSize   int64  `json:"max_size,omitempty"` // 0: no upper bound
+	CID       string `json:"cid,omitempty"`      // prefix
+	Text      string `json:"text,omitempty"`     // words that must occur in the content (fulltext.go)
+	Limit     int    `json:"limit,omitempty"`
+}
+
+type SearchResults struct {
+	QueryID   string             `json:"query_id"`
+	Matches   []AnnouncedEntry   `json:"matches"`
+	Truncated bool               `json:"truncated,omitempty"` // the peer had more than Limit matches
+	TextHits  map[string]TextHit `json:"text_hits,omitempty"` // name → score and snippet, text queries only
+}
+
+// searchHit is one file (name + content) with every peer that returned it
+type searchHit struct {
+	Entry     AnnouncedEntry
+	Peers     []string
+	Snippet   string
+	TextScore float64
+	score     int
+}
+
+// setupSearch joins the workspace's search topic and answers queries from our listing
+func setupSearch(ctx context.Context, ps *pubsub.PubSub, ws *Workspace) error {
+	var err error
+	if err := ps.RegisterTopicValidator(ws.SearchTopicName(), announceGuard.searchValidator(ws)); err != nil {
+		return fmt.Errorf("[Search][setupSearch] %w", err)
+	}
+	ws.searchTopic, err = ps.Join(ws.SearchTopicName())
+	if err != nil {
+		return fmt.Errorf("[Search][setupSearch] %w", err)
+	}
+	if err := ws.searchTopic.SetScoreParams(announceTopicScoreParams()); err != nil {
+		return fmt.Errorf("[Search][setupSearch] %w", err)
+	}
+	sub, err := ws.searchTopic.Subscribe()
+	if err != nil {
+		return fmt.Errorf("[Search][setupSearch] %w", err)
+	}
+
+	node.SetStreamHandler(ws.Protocol(searchResultsProtocol), ws.guarded(func(s network.Stream) {
+		handleSearchResults(ws, s)
+	}))
+	log.Printf("[Search][setupSearch] Joined search topic '%s' for workspace %s", ws.SearchTopicName(), ws.Name)
+
+	go func() {
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			if msg.GetFrom() == node.ID() {
+				continue
+			}
+			q, ok := msg.ValidatorData.(SearchQuery)
+			if !ok {
+				continue
+			}
+			go answerQuery(ws, msg.GetFrom(), q)
+		}
+	}()
+	return nil
+}
+
+func (g *AnnounceGuard) searchValidator(ws *Workspace) pubsub.ValidatorEx {
+	return func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		origin := msg.GetFrom()
+		q, err := parseSearchQuery(msg.Data, origin)
+		if err != nil {
+			g.penalize(origin, invalidAnnouncePenalty, err)
+			log.Printf("[Search][validate] Rejected query from %s in %s: %v", shortID(origin.String()), ws.Name, err)
+			return pubsub.ValidationReject
+		}
+		if origin != g.self && !g.allow(origin, ws.SearchTopicName()) {
+			log.Printf("[Search][validate] %s searches too often in %s, dropping", shortID(origin.String()), ws.Name)
+			return pubsub.ValidationIgnore
+		}
+		msg.ValidatorData = q
+		return pubsub.ValidationAccept
+	}
+}
+
+func parseSearchQuery(data []byte, origin peer.ID) (SearchQuery, error) {
+	var q SearchQuery
+	if len(data) > maxSearchQuerySize {
+		return q, fmt.Errorf("%d bytes, limit is %d", len(data), maxSearchQuerySize)
+	}
+	if err := json.Unmarshal(data, &q); err != nil {
+		return q, fmt.Errorf("bad JSON: %w", err)
+	}
+	if claimed, err := peer.Decode(q.PeerID); err != nil || claimed != origin {
+		return q, fmt.Errorf("peer_id %q is not the signer", shortID(q.PeerID))
+	}
+	if _, err := path.Match(q.Glob, ""); err != nil {
+		return q, fmt.Errorf("bad glob %q", q.Glob)
+	}
+	switch {
+	case q.QueryID == "" || len(q.QueryID) > 64:
+		return q, fmt.Errorf("bad query_id")
+	case q.Glob == "" && q.Substring == "" && q.Ext == "" && q.CID == "" && q.Text == "" && q.MinSize == 0 && q.MaxSize == 0:
+		return q, fmt.Errorf("empty query")
+	case len(q.Text) > 256:
+		return q, fmt.Errorf("text query too long")
+	case q.MinSize < 0 || q.MaxSize < 0 || (q.MaxSize > 0 && q.MaxSize < q.MinSize):
+		return q, fmt.Errorf("bad size range")
+	case q.Limit < 0 || q.Limit > maxSearchLimit:
+		return q, fmt.Errorf("limit %d out of range", q.Limit)
+	}
+	return q, nil
+}
+
+// filesOnly reports whether the query can only match files (folders have no size, extension, CID or text)
+func (q SearchQuery) filesOnly() bool {
+	return q.Ext != "" || q.CID != "" || q.Text != "" || q.MinSize > 0 || q.MaxSize > 0
+}
+
+func (q SearchQuery) Matches(e AnnouncedEntry) bool {
+	if e.Type == entryDir && q.filesOnly() {
+		return false
+	}
+	name := strings.TrimSuffix(e.Name, "/")
+	if q.Glob != "" {
+		whole, _ := path.Match(q.Glob, name)
+		base, _ := path.Match(q.Glob, path.Base(name))
+		if !whole && !base {
+			return false
+		}
+	}
+	if q.Substring != "" && !strings.Contains(strings.ToLower(name), strings.ToLower(q.Substring)) {
+		return false
+	}
+	if q.Ext != "" && !strings.EqualFold(path.Ext(name), "."+strings.TrimPrefix(q.Ext, ".")) {
+		return false
+	}
+	if q.CID != "" && !strings.HasPrefix(e.CID, strings.ToLower(q.CID)) {
+		return false
+	}
+	if e.Size < q.MinSize || (q.MaxSize > 0 && e.Size > q.MaxSize) {
+		return false
+	}
+	return true
+}
+
+// answerQuery sends our matches to the asker, nothing if there are none
+func answerQuery(ws *Workspace, from peer.ID, q SearchQuery) {
+	limit := q.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	ws.listingLock.Lock()
+	listing := ws.listing
+	ws.listingLock.Unlock()
+
+	res := SearchResults{QueryID: q.QueryID}
+	if q.Text != "" {
+		// content is more revealing than names: only for peers we know are in the workspace
+		if !ws.isMember(from.String()) {
+			log.Printf("[Search][answerQuery] Not answering text query of %s, not a known member of %s", shortID(from.String()), ws.Name)
+			return
+		}
+		// snippets travel in the clear, so encrypt roots and roots whose acl leaves out the asker stay out
+		readable := make([]AnnouncedEntry, 0, len(listing))
+		for _, e := range listing {
+			if root, _, ok := ws.splitName(e.Name); ok && root.allows(from.String(), false) {
+				readable = append(readable, e)
+			}
+		}
+		matches, scores := textHits(ws, q, readable)
+		if len(matches) > limit {
+			matches, res.Truncated = matches[:limit], true
+		}
+		res.Matches = matches
+		res.TextHits = make(map[string]TextHit, len(matches))
+		for _, e := range matches {
+			res.TextHits[e.Name] = TextHit{
+				Score:   scores[e.Name],
+				Snippet: snippet(ws.sharedPath(e.Name), q.Text),
+			}
+		}
+	} else {
+		for _, e := range listing {
+			if !q.Matches(e) {
+				continue
+			}
+			if len(res.Matches) == limit {
+				res.Truncated = true
+				break
+			}
+			res.Matches = append(res.Matches, e)
+		}
+	}
+	if len(res.Matches) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+	defer cancel()
+	s, err := ws.newStream(ctx, from, ws.Protocol(searchResultsProtocol))
+	if err != nil {
+		log.Printf("[Search][answerQuery] Cannot reach %s with %d match(es): %v", shortID(from.String()), len(res.Matches), err)
+		return
+	}
+	defer s.Close()
+	if err := json.NewEncoder(s).Encode(res); err != nil {
+		log.Printf("[Search][answerQuery] Failed to send results to %s: %v", shortID(from.String()), err)
+		_ = s.Reset()
+	}
+}
+
+func handleSearchResults(ws *Workspace, s network.Stream) {
+	defer s.Close()
+	from := s.Conn().RemotePeer().String()
+	var res SearchResults
+	if err := json.NewDecoder(io.LimitReader(s, maxSearchResultsSize)).Decode(&res); err != nil {
+		log.Printf("[Search][handleSearchResults] Bad results from %s: %v", shortID(from), err)
+		return
+	}
+	if err := checkAnnouncedEntries(res.Matches, maxSearchLimit); err != nil {
+		log.Printf("[Search][handleSearchResults] Rejected results from %s: %v", shortID(from), err)
+		return
+	}
+	for name, hit := range res.TextHits {
+		if !containsEntry(res.Matches, name) || len(hit.Snippet) > 4*maxSnippetLen || hit.Score < 0 || math.IsNaN(hit.Score) || math.IsInf(hit.Score, 0) {
+			log.Printf("[Search][handleSearchResults] Rejected results from %s: bad text hit for %q", shortID(from), name)
+			return
+		}
+	}
+	ws.searchLock.Lock()
+	pending, ok := ws.searches[res.QueryID]
+	if ok {
+		pending[from] = res
+	}
+	ws.searchLock.Unlock()
+	if !ok {
+		log.Printf("[Search][handleSearchResults] Results from %s for an unknown or finished query, dropped", shortID(from))
+	}
+}
+
+// runSearch publishes q, waits for answers and returns the ranked hits
+func runSearch(ws *Workspace, q SearchQuery) ([]searchHit, int, error) {
+	idBytes := make([]byte, 8)
+	_, _ = rand.Read(idBytes)
+	q.QueryID = hex.EncodeToString(idBytes)
+	q.PeerID = node.ID().String()
+
+	answers := make(map[string]SearchResults)
+	ws.searchLock.Lock()
+	ws.searches[q.QueryID] = answers
+	ws.searchLock.Unlock()
+	defer func() {
+		ws.searchLock.Lock()
+		delete(ws.searches, q.QueryID)
+		ws.searchLock.Unlock()
+	}()
+
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("[Search][runSearch] %w", err)
+	}
+	if err := ws.searchTopic.Publish(context.Background(), data); err != nil {
+		return nil, 0, fmt.Errorf("[Search][runSearch] publish failed: %w", err)
+	}
+	time.Sleep(searchTimeout)
+
+	ws.searchLock.Lock()
+	defer ws.searchLock.Unlock()
+	byKey := make(map[string]*searchHit)
+	for peerID, res := range answers {
+		for _, e := range res.Matches {
+			key := e.Name + "|" + e.CID
+			hit, ok := byKey[key]
+			if !ok {
+				hit = &searchHit{Entry: e}
+				byKey[key] = hit
+			}
+			hit.Peers = append(hit.Peers, peerID)
+			if th, ok := res.TextHits[e.Name]; ok {
+				hit.TextScore = math.Max(hit.TextScore, th.Score)
+				if hit.Snippet == "" {
+					hit.Snippet = th.Snippet
+				}
+			}
+		}
+	}
+	hits := make([]searchHit, 0, len(byKey))
+	for _, hit := range byKey {
+		sort.Strings(hit.Peers)
+		hit.score = rankHit(q, *hit)
+		hits = append(hits, *hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].Entry.Name < hits[j].Entry.Name
+	})
+	return hits, len(answers), nil
+}
+
+// rankHit favours exact file names, then content many (online) peers can serve
+func rankHit(q SearchQuery, hit searchHit) int {
+	base := strings.ToLower(path.Base(strings.TrimSuffix(hit.Entry.Name, "/")))
+	score := 0
+	switch sub := strings.ToLower(q.Substring); {
+	case sub != "" && base == sub:
+		score += 100
+	case sub != "" && strings.HasPrefix(base, sub):
+		score += 60
+	case sub != "" && strings.Contains(base, sub):
+		score += 40
+	}
+	if q.Glob != "" {
+		if ok, _ := path.Match(q.Glob, path.Base(hit.Entry.Name)); ok {
+			score += 20
+		}
+	}
+	score += int(math.Min(hit.TextScore, 10) * 10) // a peer's own tf-idf, capped so it can't drown the rest
+	for _, p := range hit.Peers {
+		score += 5
+		if liveness.State(p).State == PeerOnline {
+			score += 5
+		}
+	}
+	return score
+}
+
+// parseSearchTerms turns CLI terms into a query, see QUERY SYNTAX above
+func parseSearchTerms(terms []string) (SearchQuery, error) {
+	var q SearchQuery
+	var subs []string
+	for _, t := range terms {
+		switch {
+		case strings.HasPrefix(t, "ext:"):
+			q.Ext = strings.TrimPrefix(t, "ext:")
+		case strings.HasPrefix(t, "text:"):
+			q.Text = strings.TrimSpace(q.Text + " " + strings.TrimPrefix(t, "text:"))
+		case strings.HasPrefix(t, "cid:"):
+			q.CID = strings.TrimPrefix(t, "cid:")
+		case strings.HasPrefix(t, "size:"):
+			if err := parseSizeRange(strings.TrimPrefix(t, "size:"), &q); err != nil {
+				return q, err
+			}
+		case strings.ContainsAny(t, "*?["):
+			if _, err := path.Match(t, ""); err != nil {
+				return q, fmt.Errorf("bad glob %q", t)
+			}
+			q.Glob = t
+		default:
+			subs = append(subs, t)
+		}
+	}
+	q.Substring = strings.Join(subs, " ")
+	return q, nil
+}
+
+func parseSizeRange(spec string, q *SearchQuery) error {
+	var err error
+	switch {
+	case strings.HasPrefix(spec, ">"):
+		q.MinSize, err = parseSize(spec[1:])
+	case strings.HasPrefix(spec, "<"):
+		q.MaxSize, err = parseSize(spec[1:])
+	case strings.Contains(spec, "-"):
+		lo, hi, _ := strings.Cut(spec, "-")
+		if q.MinSize, err = parseSize(lo); err == nil {
+			q.MaxSize, err = parseSize(hi)
+		}
+	default:
+		return fmt.Errorf("size:%s, use size:>N, size:<N or size:N-M", spec)
+	}
+	return err
+}
+
+// parseSize reads 512, 10K, 1.5M, 2G
+func parseSize(s string) (int64, error) {
+	mult := 1.0
+	if n := len(s); n > 0 {
+		switch strings.ToUpper(s[n-1:]) {
+		case "K":
+			mult, s = 1<<10, s[:n-1]
+		case "M":
+			mult, s = 1<<20, s[:n-1]
+		case "G":
+			mult, s = 1<<30, s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return int64(v * mult), nil
+}
+
+func cmdSearch(ws *Workspace, terms []string) error {
+	q, err := parseSearchTerms(terms)
+	if err != nil {
+		return fmt.Errorf("[Search][cmdSearch] %w", err)
+	}
+	fmt.Printf("🔎 Searching %s for %q...\n", ws.Name, strings.Join(terms, " "))
+	hits, answered, err := runSearch(ws, q)
+	if err != nil {
+		return err
+	}
+	ws.searchLock.Lock()
+	ws.lastSearch = hits
+	ws.searchLock.Unlock()
+
+	if len(hits) == 0 {
+		fmt.Println("   no matches")
+		return nil
+	}
+	fmt.Printf("   %d result(s) from %d peer(s), 'get <n>' downloads one:\n", len(hits), answered)
+	for i, hit := range hits {
+		e := hit.Entry
+		holders := make([]string, len(hit.Peers))
+		for j, p := range hit.Peers {
+			holders[j] = shortID(p)
+		}
+		if e.Type == entryDir {
+			fmt.Printf("%4d. 📁 %s  ← %s\n", i+1, e.Name, strings.Join(holders, ", "))
+			continue
+		}
+		fmt.Printf("%4d. 📄 %s  %s  %s  ← %s\n", i+1, e.Name, humanSize(e.Size), shortID(e.CID), strings.Join(holders, ", "))
+		if hit.Snippet != "" {
+			fmt.Printf("        “%s”\n", hit.Snippet)
+		}
+	}
+	return nil
+}
+
+// cmdGetResult downloads result n of the last search, trying online holders first
+func cmdGetResult(ws *Workspace, arg string) error {
+	n, err := strconv.Atoi(arg)
+	ws.searchLock.Lock()
+	hits := ws.lastSearch
+	ws.searchLock.Unlock()
+	if err != nil || n < 1 || n > len(hits) {
+		return fmt.Errorf("[Search][cmdGetResult] no result %q (last search had %d)", arg, len(hits))
+	}
+	hit := hits[n-1]
+	peers := append([]string(nil), hit.Peers...)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return liveness.State(peers[i]).State == PeerOnline && liveness.State(peers[j]).State != PeerOnline
+	})
+	for _, p := range peers {
+		id, err := peer.Decode(p)
+		if err != nil {
+			continue
+		}
+		knownPeersLock.Lock()
+		info, ok := knownPeers[p]
+		knownPeersLock.Unlock()
+		if !ok {
+			info = peer.AddrInfo{ID: id}
+		}
+		log.Printf("[CLI] 📥 Requesting '%s' from peer %s...", hit.Entry.Name, p)
+		if err := requestFileFromPeer(ws, info, hit.Entry.Name); err != nil {
+			log.Printf("[CLI] ❌ File request failed: %v", err)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("[Search][cmdGetResult] none of the %d peer(s) holding '%s' could send it", len(peers), hit.Entry.Name)
+}
+
+func containsEntry(entries []AnnouncedEntry, name string) bool {
+	for _, e := range entries {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
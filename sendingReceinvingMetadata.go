@@ -16,13 +16,22 @@ import (
 3. Edge case handling:
   - Large metadata support (buffered reading) [DONE]
   - Handle folders inside root if needed later (already possible) [DONE]
+4. Payload carries the ledger schema header, newer schemas are refused clearly [DONE]
+   - only on /hello/1.1.0: /hello/1.0.0 keeps the bare map pre-header peers can read
 
 ──────────────────────────────────────────────────────────────────────────────
 */
 
-// SendMetadataMap serializes and sends a map of FileMetadata over an io.Writer (stream)
-func SendMetadataMap(w io.Writer, metaMap map[string]FileMetadata) error {
-	data, err := json.Marshal(metaMap)
+// SendMetadataMap serializes and sends a map of FileMetadata over an io.Writer (stream);
+// withHeader wraps it in the schema header (/hello/1.1.0), without it goes the bare map (/hello/1.0.0)
+func SendMetadataMap(w io.Writer, metaMap map[string]FileMetadata, withHeader bool) error {
+	var data []byte
+	var err error
+	if withHeader {
+		data, err = encodeLedger(metaMap, false)
+	} else {
+		data, err = json.Marshal(metaMap)
+	}
 	if err != nil {
 		return fmt.Errorf("[sendingReceivingMetadata][SendMetadataMap] JSON marshal error: %w", err)
 	}
@@ -47,11 +56,11 @@ func ReceiveMetadataMap(r io.Reader) (map[string]FileMetadata, error) {
 		return nil, fmt.Errorf("[sendingReceivingMetadata][ReceiveMetadataMap] Stream read error: %w", err)
 	}
 
-	var metaMap map[string]FileMetadata
-	err = json.Unmarshal(raw, &metaMap)
+	// accepts the schema header as well as the bare map older peers send
+	env, err := decodeLedger(raw)
 	if err != nil {
-		return nil, fmt.Errorf("[sendingReceivingMetadata][ReceiveMetadataMap] JSON unmarshal error: %w", err)
+		return nil, fmt.Errorf("[sendingReceivingMetadata][ReceiveMetadataMap] %w", err)
 	}
 
-	return metaMap, nil
+	return env.Files, nil
 }
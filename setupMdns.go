@@ -18,25 +18,34 @@ import (
 2 Automatically connecting to discovered peers (through a hello handshake) [DONE]
 3 Starting CRDT metadata sync after discovery [DONE]
 4 store peer info into known peers (declared in main.go) [done ]
+5 Same pipeline (handlePeerFound) for peers found through the DHT, see dhtDiscovery.go [DONE]
+6 One mDNS service per workspace, tagged with the workspace ID (see workspace.go) [DONE]
 
 
 */
 
 // Service tag used for peer discovery think of it like a room if some other person dont have this tag they wont be able to enter the network
-// have to inplement a public key so that a group cant discover another group (in a DLT way)
+// it is the default group; other workspaces advertise a tag derived from their secret (Workspace.MdnsTag)
 const mdnsServiceTag = "p2p-office-mdns-sync"
 
 type mdnsNotifee struct {
-	h host.Host
+	h  host.Host
+	ws *Workspace
 }
 
 // Called when a peer is discovered via mDNS
 func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
-	log.Printf("[setupMDNS][HandlePeerFound] Found peer ID: %s", pi.ID.String())
+	handlePeerFound(n.h, n.ws, pi, "mDNS")
+}
+
+// handlePeerFound connects to a peer discovered for ws, stores it in knownPeers and starts
+// the workspace's metadata sync. via says which discovery found it ("mDNS", "DHT").
+func handlePeerFound(h host.Host, ws *Workspace, pi peer.AddrInfo, via string) {
+	log.Printf("[setupMDNS][HandlePeerFound] Found peer ID: %s (via %s, workspace %s)", pi.ID.String(), via, ws.Name)
 	log.Printf("[setupMDNS][HandlePeerFound] Addrs: %v", pi.Addrs)
 
 	// Try connecting to peer
-	if err := n.h.Connect(context.Background(), pi); err != nil {
+	if err := h.Connect(context.Background(), pi); err != nil {
 		log.Printf("[setupMDNS][HandlePeerFound] Failed to connect to %s: %v", pi.ID.String(), err)
 		return
 	}
@@ -56,17 +65,18 @@ func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
 	knownPeersLock.Lock()
 	knownPeers[pi.ID.String()] = pi
 	knownPeersLock.Unlock()
+	liveness.Seen(pi.ID.String())
 	log.Printf("[setupMDNS][HandlePeerFound] Stored AddrInfo for peer %s", pi.ID.String())
 
 	// 🔁 Start CRDT metadata sync
-	go runSourceNode(pi, "")
+	go runSourceNode(ws, pi, "")
 }
 
-// Starts mDNS discovery service
-func startMdnsDiscovery(h host.Host) error {
-	log.Printf("[setupMDNS][startMdnsDiscovery] Starting mDNS with tag '%s'", mdnsServiceTag)
+// Starts the mDNS discovery service of one workspace
+func startMdnsDiscovery(h host.Host, ws *Workspace) error {
+	log.Printf("[setupMDNS][startMdnsDiscovery] Starting mDNS for %s with tag '%s'", ws.Name, ws.MdnsTag())
 
-	service := mdns.NewMdnsService(h, mdnsServiceTag, &mdnsNotifee{h: h})
+	service := mdns.NewMdnsService(h, ws.MdnsTag(), &mdnsNotifee{h: h, ws: ws})
 	err := service.Start()
 	if err != nil {
 		log.Printf("[setupMDNS][startMdnsDiscovery] Failed to start mDNS: %v", err)
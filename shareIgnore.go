@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Everything under shared/ used to be announced, versioned and served, swap files,
+.git folders and secrets included
+
+1. .shareignore files with gitignore syntax, in shared/ and any folder below it [DONE]
+   (every shared root has its own, see roots.go)
+2. Built-in rules for VCS folders and editor leftovers, which a .shareignore can
+   re-include with !pattern [DONE]
+3. Protected rules for common secrets and half written downloads, which nothing can
+   re-include: .shareignore files arrive from peers through sync, a synced "!.env"
+   must not make this node publish its own secrets [DONE]
+4. Applied everywhere shared/ is walked or served: announcements (buildLocalListing),
+   versioning (scanSharedFolder), folder and CID transfers (fileTransfer.go), two-way
+   sync (folderSync.go) and send subscriptions [DONE]
+5. status [path] in the CLI shows what is ignored and which rule decided it [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                              # SYNTAX (as gitignore)
+
+ # comment              blank lines and comments are skipped, \# and \! escape
+ *.swp                  no slash: matches the name at any depth below the file's folder
+ /build                 leading or middle slash: relative to the .shareignore's folder
+ logs/                  trailing slash: folders only
+ docs/**                ** spans folders (also leading and in the middle),
+                        * ? [a-z] stay inside one path element
+ !keep.swp              negation: re-include what an earlier rule ignored
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- Protected rules are checked before anything else and always win
+- Rules are read built-in first, then from shared/ down to the file's folder; the last
+  matching rule wins, so deeper files override shallower ones
+- Once a folder is ignored nothing inside it can be re-included (git does the same,
+  it is what lets walkers skip the whole folder)
+- .shareignore files are shared like any other file, synced peers use the same rules
+- A file that becomes ignored is dropped from the sync state, it is not deleted on peers
+- Parsed files are cached and re-read when their size or mtime changes
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const shareIgnoreFile = ".shareignore"
+
+var builtinIgnoreRules = []string{
+	".git/", ".hg/", ".svn/",
+	"*.swp", "*.swo", "*~", ".#*", "#*#", ".DS_Store", "Thumbs.db",
+}
+
+// protectedIgnoreRules cannot be negated by any .shareignore
+var protectedIgnoreRules = []string{
+	".peerlink-*", // half written downloads (writeFileAtomic)
+	".env", ".env.*", "*.pem", "*.key", "id_rsa", "id_ed25519", ".netrc",
+}
+
+type ignoreRule struct {
+	Pattern string // as written
+	Source  string // .shareignore path relative to the root, "built-in" or "protected" for defaults
+	Line    int
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// IgnoreDecision says whether a path is ignored and by which rule (nil when none matched)
+type IgnoreDecision struct {
+	Ignored bool
+	Rule    *ignoreRule
+}
+
+type parsedIgnoreFile struct {
+	size  int64
+	mtime time.Time
+	rules []ignoreRule
+}
+
+// IgnoreRules answers ignore questions for one folder tree
+type IgnoreRules struct {
+	root      string
+	protected []ignoreRule
+	builtin   []ignoreRule
+
+	mu    sync.Mutex
+	files map[string]parsedIgnoreFile // folder (slash separated, "" for root) → its .shareignore
+}
+
+func NewIgnoreRules(root string) *IgnoreRules {
+	ir := &IgnoreRules{root: root, files: make(map[string]parsedIgnoreFile)}
+	for i, p := range builtinIgnoreRules {
+		if rule, ok, _ := parseIgnoreLine(p, "built-in", i+1); ok {
+			ir.builtin = append(ir.builtin, rule)
+		}
+	}
+	for i, p := range protectedIgnoreRules {
+		if rule, ok, _ := parseIgnoreLine(p, "protected", i+1); ok {
+			ir.protected = append(ir.protected, rule)
+		}
+	}
+	return ir
+}
+
+// parseIgnoreLine turns one line into a rule, ok=false for blanks and comments
+func parseIgnoreLine(line, source string, n int) (ignoreRule, bool, error) {
+	rule := ignoreRule{Source: source, Line: n}
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimSuffix(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+	rule.Pattern = line
+	if strings.HasPrefix(line, "!") {
+		rule.negate, line = true, line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false, nil
+	}
+	if !strings.Contains(line, "/") {
+		line = "**/" + line // a bare name matches at any depth
+	}
+	re, err := regexp.Compile(ignoreRegexp(strings.TrimPrefix(line, "/")))
+	if err != nil {
+		return rule, false, fmt.Errorf("%s:%d: bad pattern %q", source, n, rule.Pattern)
+	}
+	rule.re = re
+	return rule, true, nil
+}
+
+// ignoreRegexp translates a gitignore glob into an anchored regexp over slash separated paths
+func ignoreRegexp(p string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(p); i++ {
+		atStart := i == 0 || p[i-1] == '/'
+		switch c := p[i]; {
+		case atStart && strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case atStart && p[i:] == "**":
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(p):
+			i++
+			b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// rulesIn returns the parsed .shareignore of a folder, re-reading it when it changed
+func (ir *IgnoreRules) rulesIn(dir string) []ignoreRule {
+	file := filepath.Join(ir.root, filepath.FromSlash(dir), shareIgnoreFile)
+	info, err := os.Stat(file)
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	if err != nil {
+		delete(ir.files, dir)
+		return nil
+	}
+	if cached, ok := ir.files[dir]; ok && cached.size == info.Size() && cached.mtime.Equal(info.ModTime()) {
+		return cached.rules
+	}
+
+	parsed := parsedIgnoreFile{size: info.Size(), mtime: info.ModTime()}
+	source := path.Join(dir, shareIgnoreFile)
+	if f, err := os.Open(file); err == nil {
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			rule, ok, err := parseIgnoreLine(sc.Text(), source, n)
+			if err != nil {
+				log.Printf("[ShareIgnore][rulesIn] %v, line skipped", err)
+			}
+			if ok {
+				parsed.rules = append(parsed.rules, rule)
+			}
+		}
+		f.Close()
+	}
+	ir.files[dir] = parsed
+	return parsed.rules
+}
+
+// decide applies every rule in scope of name; a protected match is final, otherwise the last match wins
+func (ir *IgnoreRules) decide(name string, isDir bool) IgnoreDecision {
+	for i := range ir.protected {
+		r := &ir.protected[i]
+		if (!r.dirOnly || isDir) && r.re.MatchString(name) {
+			return IgnoreDecision{Ignored: true, Rule: r}
+		}
+	}
+	var dec IgnoreDecision
+	check := func(rules []ignoreRule, rel string) {
+		for i := range rules {
+			r := &rules[i]
+			if (!r.dirOnly || isDir) && r.re.MatchString(rel) {
+				dec = IgnoreDecision{Ignored: !r.negate, Rule: r}
+			}
+		}
+	}
+	check(ir.builtin, name)
+	check(ir.rulesIn(""), name)
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		check(ir.rulesIn(dir), strings.Join(parts[i:], "/"))
+	}
+	return dec
+}
+
+// Explain tells whether name (slash separated, relative to the root) is ignored and why
+func (ir *IgnoreRules) Explain(name string, isDir bool) IgnoreDecision {
+	name = strings.Trim(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return IgnoreDecision{}
+	}
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if dec := ir.decide(strings.Join(parts[:i], "/"), true); dec.Ignored {
+			return dec // inside an ignored folder
+		}
+	}
+	return ir.decide(name, isDir)
+}
+
+func (ir *IgnoreRules) Ignored(name string, isDir bool) bool {
+	return ir.Explain(name, isDir).Ignored
+}
+
+func (r *ignoreRule) String() string {
+	return fmt.Sprintf("%s (%s:%d)", r.Pattern, r.Source, r.Line)
+}
+
+// ignored reports whether a path inside a shared root (as walkers see it) is excluded from sharing
+func (ws *Workspace) ignored(p string, isDir bool) bool {
+	_, root, ok := ws.nameOf(p)
+	if !ok {
+		return false
+	}
+	rel, err := filepath.Rel(root.Path, p)
+	if err != nil {
+		return false
+	}
+	return root.Ignore.Ignored(filepath.ToSlash(rel), isDir)
+}
+
+// skipIgnored is what a filepath.Walk callback returns for an ignored entry
+func skipIgnored(isDir bool) error {
+	if isDir {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// cmdStatus lists what the ignore rules keep out of the shared roots, or explains one path
+func cmdStatus(ws *Workspace, target string) error {
+	if target != "" {
+		p, root, ok := ws.localPath(filepath.ToSlash(filepath.Clean(target)))
+		if !ok {
+			return fmt.Errorf("[ShareIgnore][cmdStatus] %s is not a path inside a shared root (see 'roots')", target)
+		}
+		rel, _ := filepath.Rel(root.Path, p)
+		name := filepath.ToSlash(rel)
+		info, err := os.Stat(p)
+		isDir := err == nil && info.IsDir()
+		dec := root.Ignore.Explain(name, isDir)
+		switch {
+		case dec.Ignored:
+			fmt.Printf("✗ %s is ignored by %s\n", root.Name(name), dec.Rule)
+		case dec.Rule != nil:
+			fmt.Printf("✓ %s is shared, re-included by %s\n", root.Name(name), dec.Rule)
+		default:
+			fmt.Printf("✓ %s is shared, no rule matches\n", root.Name(name))
+		}
+		return nil
+	}
+
+	for _, root := range ws.Roots {
+		if err := printRootStatus(ws, root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printRootStatus(ws *Workspace, root *SharedRoot) error {
+	var shared int
+	var ignored []string
+	var ignoreFiles []string
+	err := filepath.Walk(root.Path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == root.Path {
+			return nil
+		}
+		rel, _ := filepath.Rel(root.Path, p)
+		name := filepath.ToSlash(rel)
+		if dec := root.Ignore.Explain(name, info.IsDir()); dec.Ignored {
+			if info.IsDir() {
+				name += "/"
+			}
+			ignored = append(ignored, fmt.Sprintf("%-40s %s", root.Name(name), dec.Rule))
+			return skipIgnored(info.IsDir())
+		}
+		if info.Name() == shareIgnoreFile {
+			ignoreFiles = append(ignoreFiles, name)
+		}
+		if !info.IsDir() {
+			shared++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[ShareIgnore][cmdStatus] %w", err)
+	}
+
+	sort.Strings(ignored)
+	fmt.Printf("📋 %s, root %s (%s): %d file(s) shared, %d path(s) ignored\n", ws.Name, root.Label, root.Path, shared, len(ignored))
+	fmt.Printf("   rules: protected (%d), built-in (%d)", len(root.Ignore.protected), len(root.Ignore.builtin))
+	for _, f := range ignoreFiles {
+		fmt.Printf(", %s", f)
+	}
+	fmt.Println()
+	for _, line := range ignored {
+		fmt.Printf("   ✗ %s\n", line)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Whole-folder snapshots modeled on git commits → trees → blobs
+
+1. Tree objects mapping relative paths to file version IDs (+ their CIDs) [DONE]
+2. Snapshot versions pointing to a tree, stored in the CRDT like any other version [DONE]
+3. snapshot <folder> <name>   capture the current state of a folder [DONE]
+4. snapshots [folder]         list snapshots [DONE]
+5. getsnapshot <name|id>      download every file of a snapshot atomically [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- A snapshot's ledger key is the folder path with a trailing slash ("docs/", "./" for
+  the whole shared folder), the same convention announcements use for folders
+- Snapshot version CID = tree ID = sha256 of the tree's JSON, so the version hash
+  covers the whole folder state
+- Consecutive snapshots of the same folder are chained through ParentIDs,
+  so `log docs/` shows the folder's release history
+- A received snapshot version is only stored if its tree hashes to its CID (MergeRemote),
+  getsnapshot checks it again before downloading anything
+- getsnapshot writes into a staging folder and renames it into place only when every
+  file has arrived and matched its CID; a failed download leaves nothing behind
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+// TreeEntry is one file inside a snapshot
+type TreeEntry struct {
+	VersionID string `json:"version_id"`
+	CID       string `json:"cid"`
+}
+
+// TreeObject maps relative paths (inside the snapshot folder) to file versions
+type TreeObject struct {
+	Entries map[string]TreeEntry `json:"entries"`
+}
+
+// TreeID is the content address of the tree (json.Marshal sorts map keys, so it is canonical)
+func (t TreeObject) TreeID() string {
+	data, _ := json.Marshal(t)
+	return contentCID(data)
+}
+
+// verifiedTrees drops versions whose tree does not hash to their CID, and tree-less versions
+// under a snapshot key, so a peer can't pass off a tree under another snapshot's ID
+func verifiedTrees(name string, meta FileMetadata) FileMetadata {
+	out := meta
+	for id, v := range meta.Versions {
+		ok := v.Tree == nil && !isSnapshotKey(name)
+		if v.Tree != nil {
+			ok = v.Tree.TreeID() == v.CID
+		}
+		if ok {
+			continue
+		}
+		if len(out.Versions) == len(meta.Versions) {
+			out.Versions = make(map[string]FileVersion, len(meta.Versions))
+			for k, kept := range meta.Versions {
+				out.Versions[k] = kept
+			}
+		}
+		delete(out.Versions, id)
+		log.Printf("[snapshots][verifiedTrees] ⚠️ Dropping version %s of %s: its tree does not match CID %s", shortID(id), name, shortID(v.CID))
+	}
+	return out
+}
+
+func isSnapshotKey(name string) bool {
+	return strings.HasSuffix(name, "/")
+}
+
+// snapshotKey normalizes a folder argument into its ledger key
+func snapshotKey(folder string) string {
+	folder = strings.Trim(filepath.ToSlash(filepath.Clean(folder)), "/")
+	if folder == "" || folder == "." {
+		return "./"
+	}
+	return folder + "/"
+}
+
+// buildTree collects the latest head of every tracked file under the folder
+func buildTree(metaMap map[string]FileMetadata, key string) TreeObject {
+	prefix := key
+	if key == "./" {
+		prefix = ""
+	}
+	tree := TreeObject{Entries: make(map[string]TreeEntry)}
+	for name, meta := range metaMap {
+		if isSnapshotKey(name) || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		head, ok := LatestHead(meta)
+		if !ok || head.Deleted {
+			continue
+		}
+		tree.Entries[strings.TrimPrefix(name, prefix)] = TreeEntry{VersionID: head.VersionID, CID: head.CID}
+	}
+	return tree
+}
+
+// cmdSnapshot records the folder's current state as a new snapshot version
+func cmdSnapshot(ws *Workspace, folder, name string) error {
+	hostname, _ := os.Hostname()
+	// make sure local edits are versioned before we freeze them
+	if _, err := scanSharedFolder(ws, "snapshot", hostname); err != nil {
+		return err
+	}
+
+	key := snapshotKey(folder)
+	if _, _, err := findSnapshot(ws, name); err == nil {
+		return fmt.Errorf("[snapshots][cmdSnapshot] a snapshot named '%s' already exists", name)
+	}
+
+	_, err := ws.Store.Update("snapshot", func(tx *MetadataTxn) error {
+		files := make(map[string]FileMetadata)
+		for _, n := range tx.Names() {
+			if meta, ok := tx.Get(n); ok {
+				files[n] = meta
+			}
+		}
+		tree := buildTree(files, key)
+		if len(tree.Entries) == 0 {
+			return fmt.Errorf("[snapshots][cmdSnapshot] no tracked files under '%s'", folder)
+		}
+
+		meta, ok := tx.Get(key)
+		if !ok {
+			meta = FileMetadata{FileName: key, Versions: make(map[string]FileVersion), Heads: []string{}}
+		}
+		snap := NewFileVersion(node.ID().String(), name, tree.TreeID(), meta.Heads)
+		snap.Tree = &tree
+		meta.AddVersion(snap)
+		tx.Put(meta)
+		fmt.Printf("📸 Snapshot '%s' of %s: %d file(s), id %s\n", name, key, len(tree.Entries), shortID(snap.VersionID))
+		return nil
+	})
+	return err
+}
+
+// findSnapshot resolves a snapshot by name (its message) or version ID prefix
+func findSnapshot(ws *Workspace, ref string) (string, FileVersion, error) {
+	var matches []FileVersion
+	var keys []string
+	for key, meta := range ws.Store.Snapshot() {
+		if !isSnapshotKey(key) {
+			continue
+		}
+		for id, v := range meta.Versions {
+			if v.Tree != nil && (v.Message == ref || strings.HasPrefix(id, ref)) {
+				matches = append(matches, v)
+				keys = append(keys, key)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", FileVersion{}, fmt.Errorf("[snapshots][findSnapshot] no snapshot matches '%s'", ref)
+	case 1:
+		return keys[0], matches[0], nil
+	}
+	return "", FileVersion{}, fmt.Errorf("[snapshots][findSnapshot] '%s' is ambiguous (%d snapshots match)", ref, len(matches))
+}
+
+// cmdListSnapshots prints snapshots, optionally of one folder, newest first
+func cmdListSnapshots(ws *Workspace, folder string) error {
+	type row struct {
+		key string
+		v   FileVersion
+	}
+	var rows []row
+	for key, meta := range ws.Store.Snapshot() {
+		if !isSnapshotKey(key) || (folder != "" && key != snapshotKey(folder)) {
+			continue
+		}
+		for _, v := range meta.Versions {
+			if v.Tree != nil {
+				rows = append(rows, row{key, v})
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return compareVersions(rows[i].v, rows[j].v) > 0 })
+
+	fmt.Println("📸 Snapshots:")
+	if len(rows) == 0 {
+		fmt.Println("   (none)")
+	}
+	for _, r := range rows {
+		fmt.Printf("   %s  %-20s %-12s %3d file(s)  %s by %s\n", shortID(r.v.VersionID), r.v.Message, r.key,
+			len(r.v.Tree.Entries), r.v.Timestamp.Format("2006-01-02 15:04"), shortID(r.v.Author))
+	}
+	return nil
+}
+
+// cmdGetSnapshot downloads every file of the snapshot into <download dir>/<folder>@<name>
+func cmdGetSnapshot(ws *Workspace, ref string) error {
+	key, snap, err := findSnapshot(ws, ref)
+	if err != nil {
+		return err
+	}
+	if snap.Tree == nil || snap.Tree.TreeID() != snap.CID {
+		return fmt.Errorf("[snapshots][cmdGetSnapshot] snapshot %s: tree does not match its ID %s, not downloading", shortID(snap.VersionID), shortID(snap.CID))
+	}
+
+	folderName := strings.TrimSuffix(key, "/")
+	if folderName == "." {
+		folderName = "shared"
+	}
+	finalDir := filepath.Join(ws.DownloadDir, strings.ReplaceAll(folderName+"@"+snap.Message, "/", "_"))
+	if _, err := os.Stat(finalDir); err == nil {
+		return fmt.Errorf("[snapshots][cmdGetSnapshot] %s already exists", finalDir)
+	}
+
+	stagingDir := filepath.Join(ws.DownloadDir, ".staging-"+shortID(snap.VersionID))
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	paths := make([]string, 0, len(snap.Tree.Entries))
+	for p := range snap.Tree.Entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, rel := range paths {
+		entry := snap.Tree.Entries[rel]
+		clean := path.Clean("/" + rel)[1:] // never let a tree entry escape the staging folder
+		content, err := contentForVersion(ws, FileVersion{VersionID: entry.VersionID, CID: entry.CID})
+		if err != nil {
+			return fmt.Errorf("[snapshots][cmdGetSnapshot] '%s' unavailable, snapshot not downloaded: %w", rel, err)
+		}
+		if contentCID(content) != entry.CID {
+			return fmt.Errorf("[snapshots][cmdGetSnapshot] '%s' does not match its CID, snapshot not downloaded", rel)
+		}
+		target := filepath.Join(stagingDir, filepath.FromSlash(clean))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+		log.Printf("[snapshots][cmdGetSnapshot] Staged %s", rel)
+	}
+
+	if err := os.Rename(stagingDir, finalDir); err != nil {
+		return fmt.Errorf("[snapshots][cmdGetSnapshot] could not move snapshot into place: %w", err)
+	}
+	fmt.Printf("✅ Snapshot '%s' (%d file(s)) saved under %s\n", snap.Message, len(paths), finalDir)
+	return nil
+}
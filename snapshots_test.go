@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// TestMergeRemoteVerifiesTrees: a snapshot whose tree does not hash to its CID is never stored
+func TestMergeRemoteVerifiesTrees(t *testing.T) {
+	ws := newTestWorkspace(t, "snapshots")
+	tree := TreeObject{Entries: map[string]TreeEntry{"a.txt": {VersionID: "v-a", CID: "c-a"}}}
+	good := NewFileVersion("peer", "release", tree.TreeID(), nil)
+	good.Tree = &tree
+
+	forgedTree := TreeObject{Entries: map[string]TreeEntry{"a.txt": {VersionID: "v-evil", CID: "c-evil"}}}
+	forged := NewFileVersion("peer", "release-2", tree.TreeID(), []string{good.VersionID})
+	forged.Tree = &forgedTree
+	treeless := NewFileVersion("peer", "release-3", "c-a", []string{good.VersionID})
+
+	remote := FileMetadata{FileName: "docs/", Versions: map[string]FileVersion{}}
+	for _, v := range []FileVersion{good, forged, treeless} {
+		remote.AddVersion(v)
+	}
+	if _, err := ws.Store.MergeRemote("peer", map[string]FileMetadata{"docs/": remote}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, _ := ws.Store.Get("docs/")
+	if _, ok := meta.Versions[good.VersionID]; !ok {
+		t.Errorf("valid snapshot dropped")
+	}
+	if _, ok := meta.Versions[forged.VersionID]; ok {
+		t.Errorf("snapshot with a forged tree stored")
+	}
+	if _, ok := meta.Versions[treeless.VersionID]; ok {
+		t.Errorf("snapshot without a tree stored")
+	}
+	if len(remote.Versions) != 3 {
+		t.Errorf("caller's map modified, %d versions left", len(remote.Versions))
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"log"
 	"os"
 	"strings"
-	"time"
 )
 
 /*
@@ -18,11 +17,173 @@ import (
 3 Request a file from a discovered peer[DONE]
 4 Trigger a re-announcement[DONE]
 5 Exit cleanly on cancellation[DONE]
+6 Print ledger updates pushed by each workspace's MetadataStore subscriptions[DONE]
+7 History commands: log, show, diff, checkout (see historyCommands.go)[DONE]
+8 Folder snapshots: snapshot, snapshots, getsnapshot (see snapshots.go)[DONE]
+9 Tags and branches, "<file>@<ref>" downloads (see refs.go)[DONE]
+10 gc / gc apply for ledger history (see ledgerGC.go)[DONE]
+11 workspaces / use <name>, everything else acts on the current workspace (see workspace.go)[DONE]
+12 search <terms> across the workspace's peers, get <n> downloads a result (see search.go)[DONE]
+13 find <words> searches file contents through the peers' full-text indexes (see fulltext.go)[DONE]
+14 subscribe / unsubscribe / subscriptions mirror matching files automatically (see subscriptions.go)[DONE]
+15 sync / sync now for workspaces whose shared folder is kept in two-way sync (see folderSync.go)[DONE]
+16 status [path] shows what .shareignore rules keep out of shared/ and why (see shareIgnore.go)[DONE]
+17 roots lists the shared roots with their mode, encryption and ACL; files are label:path (see roots.go)[DONE]
 */
 
+const cliHelp = `Commands:
+  <file>                      download a file/folder announced by a peer (label:path for other roots)
+  (empty)                     re-announce local files
+  search <terms>              search peers' files: words, *.glob, ext:pdf, size:>10M, cid:<prefix>
+  find <words>                search the content of peers' text files (same as search text:<word> ...)
+  get <n>                     download result n of the last search
+  subscribe <pattern> <dir> [receive|send|both] [from <peer>]
+                              keep files matching pattern mirrored in dir (default receive)
+  unsubscribe <id>            remove a subscription
+  subscriptions               list subscriptions and files held back by local edits
+  roots                       shared roots of the workspace with mode, encryption and acl
+  status [path]               ignored paths in the shared roots and the rule that ignores them
+  sync [now]                  two-way sync status of the shared roots, "now" pulls peers' ledgers and syncs
+  log <file>                  version history with graph
+  show <version>              details of one version (ID prefix is enough)
+  diff <v1> <v2>              line diff between two versions
+  checkout <file> <version>   restore an old version into shared/ as a new head
+  snapshot <folder> <name>    capture a folder ("." for everything) as a named snapshot
+  snapshots [folder]          list snapshots
+  getsnapshot <name|id>       download all files of a snapshot at once
+  tag <file> <version> <name> create an immutable signed tag
+  tags [file]                 list tags
+  branch <file> <name> [ver]  create or move a branch (default: latest head)
+  branches [file]             list branches
+  <file>@<tag|branch>         download a specific version, also works for log/show/diff
+  gc [apply]                  show (or drop) ledger history older than the GC horizon
+  export-ledger <file>        write the ledger as JSON
+  import-ledger <file>        merge a JSON ledger into ours
+  authored [peer]             versions written by a peer (default: us)
+  export-git <file>           write the history as a git fast-import stream
+  import-git <repo|stream>    seed the ledger from a git repository
+  peers                       peers with state / last seen, static peers and penalized announcers
+  peer add <multiaddr|id>     dial a peer now and keep reconnecting to it
+  peer remove <multiaddr|id>  stop reconnecting to a static peer
+  workspaces                  list workspaces, * marks the current one
+  use <workspace>             switch the current workspace
+  help                        this text`
+
+// runCLICommand handles the built-in commands on ws, returns false if input is a file to download
+func runCLICommand(ws *Workspace, input string) bool {
+	args := strings.Fields(input)
+	var err error
+	switch {
+	case args[0] == "help":
+		fmt.Println(cliHelp)
+	case args[0] == "search" && len(args) >= 2:
+		err = cmdSearch(ws, args[1:])
+	case args[0] == "find" && len(args) >= 2:
+		err = cmdFind(ws, args[1:])
+	case args[0] == "get" && len(args) == 2:
+		err = cmdGetResult(ws, args[1])
+	case args[0] == "subscribe" && len(args) >= 3:
+		err = cmdSubscribe(ws, args[1:])
+	case args[0] == "unsubscribe" && len(args) == 2:
+		err = cmdUnsubscribe(ws, args[1])
+	case args[0] == "subscriptions" && len(args) == 1:
+		err = cmdListSubscriptions(ws)
+	case args[0] == "roots" && len(args) == 1:
+		err = cmdListRoots(ws)
+	case args[0] == "status" && len(args) <= 2:
+		err = cmdStatus(ws, strings.Join(args[1:], ""))
+	case args[0] == "sync" && len(args) == 1:
+		err = cmdSyncStatus(ws)
+	case args[0] == "sync" && len(args) == 2 && args[1] == "now":
+		err = cmdSyncNow(ws)
+	case args[0] == "log" && len(args) == 2:
+		err = cmdLog(ws, args[1])
+	case args[0] == "show" && len(args) == 2:
+		err = cmdShow(ws, args[1])
+	case args[0] == "diff" && len(args) == 3:
+		err = cmdDiff(ws, args[1], args[2])
+	case args[0] == "checkout" && len(args) == 3:
+		err = cmdCheckout(ws, args[1], args[2])
+	case args[0] == "snapshot" && len(args) == 3:
+		err = cmdSnapshot(ws, args[1], args[2])
+	case args[0] == "snapshots" && len(args) <= 2:
+		err = cmdListSnapshots(ws, strings.Join(args[1:], ""))
+	case args[0] == "getsnapshot" && len(args) == 2:
+		err = cmdGetSnapshot(ws, args[1])
+	case args[0] == "tag" && len(args) == 4:
+		err = cmdTag(ws, args[1], args[2], args[3])
+	case args[0] == "tags" && len(args) <= 2:
+		err = cmdListRefs(ws, "tag", strings.Join(args[1:], ""))
+	case args[0] == "branch" && (len(args) == 3 || len(args) == 4):
+		err = cmdBranch(ws, args[1], args[2], strings.Join(args[3:], ""))
+	case args[0] == "branches" && len(args) <= 2:
+		err = cmdListRefs(ws, "branch", strings.Join(args[1:], ""))
+	case args[0] == "gc" && len(args) == 1:
+		err = cmdGC(ws, false)
+	case args[0] == "gc" && len(args) == 2 && args[1] == "apply":
+		err = cmdGC(ws, true)
+	case args[0] == "export-ledger" && len(args) == 2:
+		err = cmdExportLedger(ws, args[1])
+	case args[0] == "import-ledger" && len(args) == 2:
+		err = cmdImportLedger(ws, args[1])
+	case args[0] == "authored" && len(args) <= 2:
+		err = cmdAuthored(ws, strings.Join(args[1:], ""))
+	case args[0] == "export-git" && len(args) == 2:
+		err = cmdExportGit(ws, args[1])
+	case args[0] == "import-git" && len(args) == 2:
+		err = cmdImportGit(ws, args[1])
+	case args[0] == "peers" && len(args) == 1:
+		liveness.Print()
+		staticPeers.Print()
+		announceGuard.Print()
+	case args[0] == "peer" && len(args) == 3 && args[1] == "add":
+		err = staticPeers.Add(args[2])
+	case args[0] == "peer" && len(args) == 3 && args[1] == "remove":
+		err = staticPeers.Remove(args[2])
+	case args[0] == "workspaces" && len(args) == 1:
+		err = cmdListWorkspaces()
+	case args[0] == "use" && len(args) == 2:
+		err = cmdUseWorkspace(args[1])
+	default:
+		return false
+	}
+	if err != nil {
+		printLock.Lock()
+		log.Printf("[CLI] ❌ %s failed: %v", args[0], err)
+		printLock.Unlock()
+	}
+	return true
+}
+
+// watchMetadataChanges tells the user when a sync brought in new versions of ws
+func watchMetadataChanges(ctx context.Context, ws *Workspace) {
+	changes, cancel := ws.Store.Subscribe(16)
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case change, ok := <-changes:
+				if !ok {
+					return
+				}
+				if change.Source == "startup" {
+					continue
+				}
+				printLock.Lock()
+				log.Printf("[CLI] 🔄 Metadata of %s updated by %s for: %v", ws.Name, change.Source, change.Files)
+				printLock.Unlock()
+			}
+		}
+	}()
+}
+
 func startInteractiveCLI(ctx context.Context) {
+	for _, ws := range workspaces {
+		watchMetadataChanges(ctx, ws)
+	}
 	go func() {
-		time.Sleep(16 * time.Second)
 		reader := bufio.NewReader(os.Stdin)
 
 		for {
@@ -31,10 +192,11 @@ func startInteractiveCLI(ctx context.Context) {
 				log.Println("[CLI] 🚪 Exiting interactive CLI due to context cancel.")
 				return
 			default:
+				ws := activeWorkspace
 				printLock.Lock()
-				showAvailableFiles()
-				fmt.Println("📁 Enter file name to download, '' to re-announce (leave input empty and press Enter), or press Ctrl+C to exit:")
-				fmt.Print("> ")
+				showAvailableFiles(ws)
+				fmt.Println("📁 Enter file name to download, '' to re-announce (leave input empty and press Enter), 'help' for history commands, or press Ctrl+C to exit:")
+				fmt.Printf("[%s]> ", ws.Name)
 				printLock.Unlock()
 
 				inputRaw, err := reader.ReadString('\n')
@@ -51,20 +213,31 @@ func startInteractiveCLI(ctx context.Context) {
 					printLock.Lock()
 					log.Println("[CLI] 🔁 Manual refresh triggered (empty input).")
 					printLock.Unlock()
-					announceLocalFiles(node.ID().String())
+					announceLocalFiles(ws)
+					continue
+				}
+
+				if runCLICommand(ws, input) {
 					continue
 				}
 
 				fileRequested := input
+				// "<file>@<ref>" is offered by whoever announces <file>
+				announcedName, _, _ := splitFileRef(fileRequested)
 				found := false
+				offlineOffers := 0
 
-				knownFilesLock.Lock()
-				for peerID, fileList := range knownFiles {
+				ws.filesLock.Lock()
+				for peerID, entries := range ws.files {
 					if peerID == node.ID().String() {
 						continue
 					}
-					for _, file := range fileList {
-						if file == fileRequested {
+					for _, entry := range entries {
+						if file := entry.Name; file == fileRequested || file == announcedName {
+							if liveness.State(peerID).State != PeerOnline {
+								offlineOffers++ // would just fail on connect
+								break
+							}
 							knownPeersLock.Lock()
 							peerInfo, ok := knownPeers[peerID]
 							knownPeersLock.Unlock()
@@ -74,7 +247,7 @@ func startInteractiveCLI(ctx context.Context) {
 								log.Printf("[CLI] 📥 Requesting file '%s' from peer %s...", fileRequested, peerID)
 								printLock.Unlock()
 
-								err := requestFileFromPeer(peerInfo, fileRequested)
+								err := requestFileFromPeer(ws, peerInfo, fileRequested)
 								if err != nil {
 									printLock.Lock()
 									log.Printf("[CLI] ❌ File request failed: %v", err)
@@ -89,11 +262,15 @@ func startInteractiveCLI(ctx context.Context) {
 						break
 					}
 				}
-				knownFilesLock.Unlock()
+				ws.filesLock.Unlock()
 
 				if !found {
 					printLock.Lock()
-					log.Printf("[CLI] ⚠️ File '%s' not found in known announcements.", fileRequested)
+					if offlineOffers > 0 {
+						log.Printf("[CLI] ⚠️ File '%s' is only offered by %d peer(s) that are not online right now.", fileRequested, offlineOffers)
+					} else {
+						log.Printf("[CLI] ⚠️ File '%s' not found in known announcements.", fileRequested)
+					}
 					printLock.Unlock()
 				}
 			}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+For networks where multicast is blocked and mDNS never fires
+
+1. Config file with static peers and DHT bootstrap peers (-peers-config) [DONE]
+2. Dial static peers at startup, keep reconnecting with exponential backoff [DONE]
+3. Connected static peers go through handlePeerFound like mDNS ones, once per workspace
+   (a peer outside a workspace simply refuses its protocols) [DONE]
+4. peers / peer add <addr|id> / peer remove <addr|id> at runtime, saved to the file [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                           # .peerlink/peers.json
+
+ {
+   "peers":     ["/ip4/10.1.2.3/tcp/4001/p2p/12D3KooW...", "12D3KooW..."],
+   "bootstrap": ["/dns4/relay.example.com/tcp/4001/p2p/12D3KooW..."],
+   "relays":    ["/dns4/relay.example.com/tcp/4001/p2p/12D3KooW..."]
+ }
+
+- A bare peer ID is resolved through the peerstore, then the DHT (if -dht is on)
+- bootstrap entries are added to -bootstrap for the DHT (dhtDiscovery.go)
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	defaultPeersConfigPath = ".peerlink/peers.json"
+	staticPeerMinBackoff   = 5 * time.Second
+	staticPeerMaxBackoff   = 5 * time.Minute
+	staticPeerCheckEvery   = 5 * time.Second
+)
+
+type PeersConfig struct {
+	Peers     []string `json:"peers"`
+	Bootstrap []string `json:"bootstrap"`
+	Relays    []string `json:"relays,omitempty"` // circuit relay v2 nodes (natTraversal.go)
+}
+
+type staticPeer struct {
+	spec        string // as written in the config
+	id          peer.ID
+	addrs       peer.AddrInfo
+	backoff     time.Duration
+	nextAttempt time.Time
+	lastErr     error
+	connected   bool
+	dialing     bool
+}
+
+type StaticPeerManager struct {
+	mu     sync.Mutex
+	h      host.Host
+	path   string
+	config PeersConfig
+	peers  map[string]*staticPeer // key: spec
+	router routing.PeerRouting    // set once the DHT runs, resolves bare peer IDs
+	ctx    context.Context        // set by Start, lets Add dial without waiting for the next tick
+}
+
+var staticPeers *StaticPeerManager
+
+func loadPeersConfig(path string) (PeersConfig, error) {
+	var cfg PeersConfig
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("[staticPeers][loadPeersConfig] %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("[staticPeers][loadPeersConfig] bad %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// parseStaticPeer accepts a /p2p multiaddr or a bare peer ID
+func parseStaticPeer(spec string) (*staticPeer, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "/") {
+		info, err := peer.AddrInfoFromString(spec)
+		if err != nil {
+			return nil, fmt.Errorf("[staticPeers][parseStaticPeer] bad multiaddr %q (it needs a /p2p/<id> part): %w", spec, err)
+		}
+		return &staticPeer{spec: spec, id: info.ID, addrs: *info}, nil
+	}
+	id, err := peer.Decode(spec)
+	if err != nil {
+		return nil, fmt.Errorf("[staticPeers][parseStaticPeer] %q is neither a multiaddr nor a peer ID: %w", spec, err)
+	}
+	return &staticPeer{spec: spec, id: id, addrs: peer.AddrInfo{ID: id}}, nil
+}
+
+func NewStaticPeerManager(h host.Host, path string) (*StaticPeerManager, error) {
+	cfg, err := loadPeersConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &StaticPeerManager{h: h, path: path, config: cfg, peers: make(map[string]*staticPeer)}
+	for _, spec := range cfg.Peers {
+		sp, err := parseStaticPeer(spec)
+		if err != nil {
+			log.Printf("[staticPeers][NewStaticPeerManager] Skipping: %v", err)
+			continue
+		}
+		m.peers[sp.spec] = sp
+	}
+
+	// redial quickly when a static peer drops
+	h.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(_ network.Network, c network.Conn) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			for _, sp := range m.peers {
+				if sp.id == c.RemotePeer() && sp.connected {
+					sp.connected = false
+					sp.backoff = 0
+					sp.nextAttempt = time.Now().Add(staticPeerMinBackoff)
+				}
+			}
+		},
+	})
+	return m, nil
+}
+
+// BootstrapPeers returns the bootstrap entries of the config file
+func (m *StaticPeerManager) BootstrapPeers() []peer.AddrInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peers, err := parseBootstrapPeers(strings.Join(m.config.Bootstrap, ","))
+	if err != nil {
+		log.Printf("[staticPeers][BootstrapPeers] %v", err)
+	}
+	return peers
+}
+
+// SetRouter lets bare peer IDs be resolved through the DHT
+func (m *StaticPeerManager) SetRouter(r routing.PeerRouting) {
+	m.mu.Lock()
+	m.router = r
+	m.mu.Unlock()
+}
+
+// Start dials every static peer now and keeps them connected
+func (m *StaticPeerManager) Start(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(staticPeerCheckEvery)
+		defer ticker.Stop()
+		for {
+			m.dialDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *StaticPeerManager) dialDue(ctx context.Context) {
+	now := time.Now()
+	m.mu.Lock()
+	var due []*staticPeer
+	for _, sp := range m.peers {
+		if m.h.Network().Connectedness(sp.id) == network.Connected {
+			continue
+		}
+		if !sp.dialing && !sp.nextAttempt.After(now) {
+			sp.dialing = true
+			due = append(due, sp)
+		}
+	}
+	router := m.router
+	m.mu.Unlock()
+
+	for _, sp := range due {
+		go m.dial(ctx, sp, router)
+	}
+}
+
+func (m *StaticPeerManager) dial(ctx context.Context, sp *staticPeer, router routing.PeerRouting) {
+	pi := sp.addrs
+	if len(pi.Addrs) == 0 {
+		pi.Addrs = m.h.Peerstore().Addrs(sp.id)
+	}
+	if len(pi.Addrs) == 0 && router != nil {
+		findCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		found, err := router.FindPeer(findCtx, sp.id)
+		cancel()
+		if err == nil {
+			pi = found
+		}
+	}
+
+	var err error
+	if len(pi.Addrs) == 0 {
+		err = fmt.Errorf("no known address for %s", shortID(sp.id.String()))
+	} else {
+		dialCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+		err = m.h.Connect(dialCtx, pi)
+		cancel()
+	}
+
+	m.mu.Lock()
+	sp.dialing = false
+	if _, still := m.peers[sp.spec]; !still {
+		m.mu.Unlock()
+		return // removed while we were dialing
+	}
+	if err != nil {
+		sp.backoff *= 2
+		if sp.backoff < staticPeerMinBackoff {
+			sp.backoff = staticPeerMinBackoff
+		}
+		if sp.backoff > staticPeerMaxBackoff {
+			sp.backoff = staticPeerMaxBackoff
+		}
+		jitter := time.Duration(rand.Int63n(int64(sp.backoff / 4)))
+		sp.nextAttempt = time.Now().Add(sp.backoff + jitter)
+		sp.lastErr = err
+		m.mu.Unlock()
+		log.Printf("[staticPeers][dial] %s unreachable, retrying in %s: %v", sp.spec, sp.backoff, err)
+		return
+	}
+	sp.backoff = 0
+	sp.lastErr = nil
+	sp.connected = true
+	m.mu.Unlock()
+
+	log.Printf("[staticPeers][dial] Connected to static peer %s", sp.spec)
+	for _, ws := range workspaces {
+		handlePeerFound(m.h, ws, pi, "static")
+	}
+}
+
+func (m *StaticPeerManager) saveLocked() error {
+	specs := make([]string, 0, len(m.peers))
+	for spec := range m.peers {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+	m.config.Peers = specs
+	if err := os.MkdirAll(filepath.Dir(m.path), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0644)
+}
+
+// Add registers a static peer, saves the config and dials it right away (once Start ran)
+func (m *StaticPeerManager) Add(spec string) error {
+	sp, err := parseStaticPeer(spec)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if _, exists := m.peers[sp.spec]; exists {
+		m.mu.Unlock()
+		return fmt.Errorf("[staticPeers][Add] %s is already a static peer", sp.spec)
+	}
+	m.peers[sp.spec] = sp
+	err = m.saveLocked()
+	ctx := m.ctx
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("[staticPeers][Add] saving %s failed: %w", m.path, err)
+	}
+	fmt.Printf("➕ Static peer %s added\n", sp.spec)
+	if ctx != nil {
+		m.dialDue(ctx) // a new peer has no backoff yet, so it is due now
+	}
+	return nil
+}
+
+// Remove forgets a static peer (by spec or peer ID); an open connection is left alone
+func (m *StaticPeerManager) Remove(spec string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for key, sp := range m.peers {
+		if key == spec || sp.id.String() == spec {
+			delete(m.peers, key)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return fmt.Errorf("[staticPeers][Remove] %s is not a static peer", spec)
+	}
+	if err := m.saveLocked(); err != nil {
+		return fmt.Errorf("[staticPeers][Remove] saving %s failed: %w", m.path, err)
+	}
+	fmt.Printf("➖ Static peer %s removed\n", spec)
+	return nil
+}
+
+// Print lists static peers with their connection state
+func (m *StaticPeerManager) Print() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	specs := make([]string, 0, len(m.peers))
+	for spec := range m.peers {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+	fmt.Printf("📌 Static peers (%s):\n", m.path)
+	if len(specs) == 0 {
+		fmt.Println("   (none)")
+	}
+	for _, spec := range specs {
+		sp := m.peers[spec]
+		switch {
+		case m.h.Network().Connectedness(sp.id) == network.Connected:
+			fmt.Printf("   🟢 %s\n", spec)
+		case sp.lastErr != nil:
+			fmt.Printf("   🔴 %s  next try in %s (%v)\n", spec, time.Until(sp.nextAttempt).Round(time.Second), sp.lastErr)
+		default:
+			fmt.Printf("   ⚪ %s  dialing\n", spec)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// TestStaticPeerAddDials: a peer added at runtime is dialed before the next check tick
+func TestStaticPeerAddDials(t *testing.T) {
+	a, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	m, err := NewStaticPeerManager(a, filepath.Join(t.TempDir(), "peers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx)
+	time.Sleep(100 * time.Millisecond) // let the first (empty) check run
+
+	if err := m.Add(fmt.Sprintf("%s/p2p/%s", b.Addrs()[0], b.ID())); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(staticPeerCheckEvery / 2)
+	for a.Network().Connectedness(b.ID()) != network.Connected {
+		if time.Now().After(deadline) {
+			t.Fatalf("added peer not dialed within %s", staticPeerCheckEvery/2)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
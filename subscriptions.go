@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Every download used to be a manual CLI action
+
+1. Subscription rules: remote pattern → local folder, direction, optionally one peer only,
+   persisted per workspace [DONE]
+2. receive: when a listing or a ledger sync shows new content for a matching file, fetch
+   it (by CID, from any member) into the local folder [DONE]
+3. send: local edits in the folder are copied into shared/ and recorded in the ledger, so
+   peers see a new head [DONE]
+4. both: the two above; if both sides changed, the remote copy lands next to ours as
+   <name>.sync-conflict-<cid><ext> [DONE]
+5. subscribe / unsubscribe / subscriptions in the CLI [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # PATTERNS
+
+ reports/*.pdf     path.Match on the remote path (relative to the peer's shared folder)
+ reports/**        everything below reports/
+ **                everything
+
+ The literal directories in front of the first wildcard are dropped locally:
+ "subscribe reports/*.pdf TransferredFiles/reports" puts reports/q3.pdf at
+ TransferredFiles/reports/q3.pdf
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- Which content a file should have: the latest ledger head if the ledger tracks it,
+  else the newest announced copy; with "from" only that peer's announcement counts
+- Synced remembers, per file, the CID both sides agreed on last time. A local file with
+  another CID was edited here: receive never overwrites it, send/both publish it
+- Deletions are not mirrored, a file that disappears remotely stays here
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	subscriptionsFile    = ".peerlink/subscriptions.json"
+	subscriptionInterval = 30 * time.Second
+)
+
+const (
+	subReceive = "receive"
+	subSend    = "send"
+	subBoth    = "both"
+)
+
+type Subscription struct {
+	ID        int               `json:"id"`
+	Pattern   string            `json:"pattern"`
+	Target    string            `json:"target"`
+	Direction string            `json:"direction"`
+	From      string            `json:"from,omitempty"`   // peer ID, empty for any member
+	Synced    map[string]string `json:"synced,omitempty"` // remote name → CID last mirrored either way
+	conflicts map[string]bool   // local edits receive left alone
+}
+
+type SubscriptionSet struct {
+	mu    sync.Mutex
+	path  string
+	subs  []*Subscription
+	nextI int
+	kick  chan struct{}
+}
+
+func loadSubscriptions(path string) (*SubscriptionSet, error) {
+	set := &SubscriptionSet{path: path, kick: make(chan struct{}, 1), nextI: 1}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[Subscriptions][loadSubscriptions] %w", err)
+	}
+	if err := json.Unmarshal(data, &set.subs); err != nil {
+		return nil, fmt.Errorf("[Subscriptions][loadSubscriptions] bad %s: %w", path, err)
+	}
+	for _, s := range set.subs {
+		if s.Synced == nil {
+			s.Synced = make(map[string]string)
+		}
+		s.conflicts = make(map[string]bool)
+		if s.ID >= set.nextI {
+			set.nextI = s.ID + 1
+		}
+	}
+	return set, nil
+}
+
+func (set *SubscriptionSet) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(set.path), os.ModePerm); err != nil {
+		return fmt.Errorf("[Subscriptions][save] %w", err)
+	}
+	data, err := json.MarshalIndent(set.subs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[Subscriptions][save] %w", err)
+	}
+	if err := os.WriteFile(set.path, data, 0644); err != nil {
+		return fmt.Errorf("[Subscriptions][save] %w", err)
+	}
+	return nil
+}
+
+// Kick asks the worker for a pass soon, cheap to call from listeners
+func (set *SubscriptionSet) Kick() {
+	select {
+	case set.kick <- struct{}{}:
+	default:
+	}
+}
+
+func validatePattern(pattern string) error {
+	if pattern == "**" {
+		return nil
+	}
+	p := strings.TrimSuffix(pattern, "/**")
+	if strings.Contains(p, "**") {
+		return fmt.Errorf("'**' is only allowed as the last path element")
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return fmt.Errorf("bad pattern %q", pattern)
+	}
+	return checkAnnouncedPath(strings.NewReplacer("*", "x", "?", "x", "[", "x", "]", "x").Replace(p))
+}
+
+func (s *Subscription) matches(name string) bool {
+	if s.Pattern == "**" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(s.Pattern, "**"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	ok, _ := path.Match(s.Pattern, name)
+	return ok
+}
+
+// base is the literal directory prefix of the pattern ("reports/" for reports/*.pdf)
+func (s *Subscription) base() string {
+	parts := strings.Split(s.Pattern, "/")
+	var lit []string
+	for _, p := range parts[:len(parts)-1] {
+		if strings.ContainsAny(p, "*?[") {
+			break
+		}
+		lit = append(lit, p)
+	}
+	if len(lit) == 0 {
+		if label, _, ok := strings.Cut(s.Pattern, ":"); ok && rootLabelPattern.MatchString(label) {
+			return label + ":" // "specs:*.md" mirrors the top of root specs (roots.go)
+		}
+		return ""
+	}
+	return strings.Join(lit, "/") + "/"
+}
+
+func (s *Subscription) localPath(name string) string {
+	return filepath.Join(s.Target, filepath.FromSlash(strings.TrimPrefix(name, s.base())))
+}
+
+func (s *Subscription) receives() bool { return s.Direction == subReceive || s.Direction == subBoth }
+func (s *Subscription) sends() bool    { return s.Direction == subSend || s.Direction == subBoth }
+
+// startSubscriptions runs the workspace's rules whenever listings or the ledger change, and periodically
+func startSubscriptions(ctx context.Context, ws *Workspace) {
+	changes, cancel := ws.Store.Subscribe(16)
+	go func() {
+		defer cancel()
+		tick := time.NewTicker(subscriptionInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+			case <-ws.Subs.kick:
+			case <-tick.C:
+			}
+			runSubscriptions(ws)
+		}
+	}()
+	ws.Subs.Kick()
+}
+
+func runSubscriptions(ws *Workspace) {
+	ws.Subs.mu.Lock()
+	subs := append([]*Subscription(nil), ws.Subs.subs...)
+	ws.Subs.mu.Unlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	sent := false
+	for _, s := range subs {
+		if s.receives() {
+			receiveSubscription(ws, s)
+		}
+		if s.sends() {
+			sent = sendSubscription(ws, s) || sent
+		}
+	}
+
+	ws.Subs.mu.Lock()
+	if err := ws.Subs.saveLocked(); err != nil {
+		log.Printf("[Subscriptions][run] %v", err)
+	}
+	ws.Subs.mu.Unlock()
+
+	if sent {
+		hostname, _ := os.Hostname()
+		if _, err := scanSharedFolder(ws, "subscription", hostname); err != nil {
+			log.Printf("[Subscriptions][run] Ledger update failed: %v", err)
+		}
+	}
+}
+
+// contentOffer is the content a mirrored file should have and a peer known to hold it ("" for any member)
+type contentOffer struct {
+	cid  string
+	peer string
+}
+
+// wantedContent picks, per matching remote file, the content it should have
+func wantedContent(ws *Workspace, s *Subscription) map[string]contentOffer {
+	want := make(map[string]contentOffer)
+	newest := make(map[string]int64)
+
+	ws.filesLock.Lock()
+	for peerID, entries := range ws.files {
+		if s.From != "" && peerID != s.From {
+			continue
+		}
+		for _, e := range entries {
+			if e.Type != entryFile || e.CID == "" || !s.matches(e.Name) {
+				continue
+			}
+			if _, seen := want[e.Name]; !seen || e.ModTime > newest[e.Name] {
+				want[e.Name] = contentOffer{cid: e.CID, peer: peerID}
+				newest[e.Name] = e.ModTime
+			}
+		}
+	}
+	ws.filesLock.Unlock()
+	if s.From != "" {
+		return want
+	}
+
+	for name, meta := range ws.Store.Snapshot() {
+		if !s.matches(name) || checkAnnouncedPath(name) != nil {
+			continue
+		}
+		head, ok := LatestHead(meta)
+		if !ok || head.Tree != nil || head.CID == "" {
+			continue
+		}
+		if want[name].cid != head.CID {
+			want[name] = contentOffer{cid: head.CID} // any member may have it
+		}
+	}
+	return want
+}
+
+func receiveSubscription(ws *Workspace, s *Subscription) {
+	for name, offer := range wantedContent(ws, s) {
+		cid := offer.cid
+		ws.Subs.mu.Lock()
+		last := s.Synced[name]
+		ws.Subs.mu.Unlock()
+		if cid == last {
+			continue
+		}
+		dest := s.localPath(name)
+		local, err := computeFileCID(dest)
+		switch {
+		case err == nil && local == cid:
+			ws.Subs.mu.Lock()
+			s.Synced[name] = cid // already there (we sent it, or fetched by hand)
+			ws.Subs.mu.Unlock()
+			continue
+		case err == nil && local != last:
+			if s.Direction == subReceive {
+				ws.Subs.mu.Lock()
+				if !s.conflicts[name] {
+					log.Printf("[Subscriptions][receive] #%d: '%s' was edited here, not overwriting it with %s", s.ID, dest, shortID(cid))
+				}
+				s.conflicts[name] = true
+				ws.Subs.mu.Unlock()
+				continue
+			}
+			// both sides changed: keep ours, put theirs next to it
+			ext := filepath.Ext(dest)
+			dest = strings.TrimSuffix(dest, ext) + ".sync-conflict-" + shortID(cid) + ext
+			if have, err := computeFileCID(dest); err == nil && have == cid {
+				continue
+			}
+		}
+
+		src, err := fetchContentByCID(ws, cid, offer.peer)
+		if err != nil {
+			log.Printf("[Subscriptions][receive] #%d: cannot fetch '%s': %v", s.ID, name, err)
+			continue
+		}
+		if err := copyFileAtomic(dest, src); err != nil {
+			log.Printf("[Subscriptions][receive] #%d: %v", s.ID, err)
+			continue
+		}
+		ws.Subs.mu.Lock()
+		delete(s.conflicts, name)
+		if dest == s.localPath(name) {
+			s.Synced[name] = cid
+		}
+		ws.Subs.mu.Unlock()
+		log.Printf("[Subscriptions][receive] #%d: '%s' → %s (%s)", s.ID, name, dest, shortID(cid))
+	}
+}
+
+// sendSubscription copies files edited in the target folder into their shared root, reports whether it copied any
+func sendSubscription(ws *Workspace, s *Subscription) bool {
+	copied := false
+	_ = filepath.Walk(s.Target, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.Contains(info.Name(), ".sync-conflict-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Target, p)
+		if err != nil {
+			return nil
+		}
+		name := s.base() + filepath.ToSlash(rel)
+		if !s.matches(name) || ws.ignoredName(name, false) {
+			return nil
+		}
+		shared, root, ok := ws.localPath(name)
+		if !ok || root.ReadOnly() {
+			return nil // no such root here, or one peers' changes never go into
+		}
+		cid, err := ws.cachedFileCID(p, info)
+		if err != nil {
+			return nil
+		}
+		ws.Subs.mu.Lock()
+		unchanged := s.Synced[name] == cid
+		ws.Subs.mu.Unlock()
+		if unchanged {
+			return nil
+		}
+
+		if p != shared {
+			if current, err := computeFileCID(shared); err != nil || current != cid {
+				data, err := os.ReadFile(p)
+				if err != nil {
+					log.Printf("[Subscriptions][send] #%d: %v", s.ID, err)
+					return nil
+				}
+				if err := writeFileAtomic(shared, data); err != nil {
+					log.Printf("[Subscriptions][send] #%d: %v", s.ID, err)
+					return nil
+				}
+				copied = true
+				log.Printf("[Subscriptions][send] #%d: '%s' → %s (%s)", s.ID, p, name, shortID(cid))
+			}
+		}
+		ws.Subs.mu.Lock()
+		s.Synced[name] = cid
+		delete(s.conflicts, name)
+		ws.Subs.mu.Unlock()
+		return nil
+	})
+	return copied
+}
+
+// writeFileAtomic replaces dest only once the whole content is on disk
+func writeFileAtomic(dest string, data []byte) error {
+	return writeAtomic(dest, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// copyFileAtomic is writeFileAtomic with the content streamed from src
+func copyFileAtomic(dest, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", src, err)
+	}
+	defer in.Close()
+	return writeAtomic(dest, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+func writeAtomic(dest string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return fmt.Errorf("cannot create %s: %w", filepath.Dir(dest), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".peerlink-*")
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %w", dest, err)
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot write %s: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot write %s: %w", dest, err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot write %s: %w", dest, err)
+	}
+	return nil
+}
+
+// cmdSubscribe: subscribe <pattern> <folder> [receive|send|both] [from <peer>]
+func cmdSubscribe(ws *Workspace, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("[Subscriptions][cmdSubscribe] usage: subscribe <pattern> <folder> [receive|send|both] [from <peer>]")
+	}
+	s := &Subscription{Pattern: args[0], Target: filepath.Clean(args[1]), Direction: subReceive,
+		Synced: make(map[string]string), conflicts: make(map[string]bool)}
+	rest := args[2:]
+	if len(rest) > 0 && rest[0] != "from" {
+		s.Direction, rest = rest[0], rest[1:]
+	}
+	if len(rest) == 2 && rest[0] == "from" {
+		s.From, rest = rest[1], nil
+	}
+	switch {
+	case len(rest) > 0:
+		return fmt.Errorf("[Subscriptions][cmdSubscribe] unexpected %q", strings.Join(rest, " "))
+	case s.Direction != subReceive && s.Direction != subSend && s.Direction != subBoth:
+		return fmt.Errorf("[Subscriptions][cmdSubscribe] direction must be receive, send or both, not %q", s.Direction)
+	}
+	if err := validatePattern(s.Pattern); err != nil {
+		return fmt.Errorf("[Subscriptions][cmdSubscribe] %w", err)
+	}
+	if abs, err := filepath.Abs(s.Target); err == nil {
+		for _, root := range ws.Roots {
+			if within(root.Path, abs) {
+				return fmt.Errorf("[Subscriptions][cmdSubscribe] %s is inside the shared root %q, pick a folder outside it", s.Target, root.Label)
+			}
+		}
+	}
+
+	ws.Subs.mu.Lock()
+	s.ID = ws.Subs.nextI
+	ws.Subs.nextI++
+	ws.Subs.subs = append(ws.Subs.subs, s)
+	err := ws.Subs.saveLocked()
+	ws.Subs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	log.Printf("[CLI] 🔔 Subscription #%d: %s %s ⇄ %s", s.ID, s.Direction, s.Pattern, s.Target)
+	ws.Subs.Kick()
+	return nil
+}
+
+func cmdUnsubscribe(ws *Workspace, arg string) error {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		return fmt.Errorf("[Subscriptions][cmdUnsubscribe] bad id %q", arg)
+	}
+	ws.Subs.mu.Lock()
+	defer ws.Subs.mu.Unlock()
+	for i, s := range ws.Subs.subs {
+		if s.ID == id {
+			ws.Subs.subs = append(ws.Subs.subs[:i], ws.Subs.subs[i+1:]...)
+			log.Printf("[CLI] 🔕 Removed subscription #%d (%s), mirrored files stay where they are", id, s.Pattern)
+			return ws.Subs.saveLocked()
+		}
+	}
+	return fmt.Errorf("[Subscriptions][cmdUnsubscribe] no subscription #%d (see 'subscriptions')", id)
+}
+
+func cmdListSubscriptions(ws *Workspace) error {
+	ws.Subs.mu.Lock()
+	defer ws.Subs.mu.Unlock()
+	fmt.Printf("🔔 Subscriptions in %s:\n", ws.Name)
+	if len(ws.Subs.subs) == 0 {
+		fmt.Println("   (none, see 'subscribe')")
+		return nil
+	}
+	for _, s := range ws.Subs.subs {
+		from := "any member"
+		if s.From != "" {
+			from = shortID(s.From)
+		}
+		fmt.Printf("   #%d  %-7s %s ⇄ %s  from %s, %d file(s) in sync\n", s.ID, s.Direction, s.Pattern, s.Target, from, len(s.Synced))
+		var held []string
+		for name := range s.conflicts {
+			held = append(held, name)
+		}
+		sort.Strings(held)
+		for _, name := range held {
+			fmt.Printf("        ⚠️ %s edited locally, not overwritten\n", name)
+		}
+	}
+	return nil
+}
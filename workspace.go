@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+Several isolated groups (workspaces) on one node
+
+1. Workspaces listed in -workspaces (.peerlink/workspaces.json); without the file the
+   node runs a single "default" workspace exactly like before [DONE]
+2. Each workspace has its own mDNS tag / DHT rendezvous, pubsub topic, shared and
+   download folder, encryption key, ledger, object store, GC acks, subscriptions and
+   sync state [DONE]
+3. Stream protocols are namespaced per workspace, a peer can only sync with or
+   download from the workspaces both sides joined, after proving it holds the
+   secret (workspaceAuth.go) [DONE]
+4. workspaces / use <name> in the CLI, every other command acts on the current one [DONE]
+5. Extra shared roots with their own label, mode, encryption and ACL (roots.go) [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                        # .peerlink/workspaces.json
+
+ [
+   {"name": "default", "group": "p2p-office-mdns-sync"},
+   {"name": "design", "group": "design-team", "secret": "long random passphrase",
+    "shared_dir": "/data/design", "download_dir": "/data/design-in", "sync": true,
+    "roots": [{"label": "specs", "path": "/srv/specs", "mode": "ro"}]}
+ ]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- The workspace ID is a hash of its secret (the group if there is no secret). Protocols,
+  topic and mDNS tag only carry the ID, the group name and secret never leave the node
+- "default" keeps the old layout so older nodes still talk to it: shared/,
+  TransferredFiles/, sync-metadata.json, .peerlink/{ledger.db,objects,gc-acks.json,subscriptions.json,sync-state.json},
+  /hello/2.0.0, /file-transfer/1.0.0, topics "file-presence" and "file-search", mDNS tag = group
+- Any other workspace lives under .peerlink/workspaces/<name>/ and speaks
+  /peerlink/ws/<id>/hello/2.0.0 etc., its folders default to workspaces/<name>/{shared,TransferredFiles}
+- knownPeers and liveness are node wide (one address book), but listings, members,
+  GC acks and file requests never cross workspaces
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	defaultWorkspacesPath = ".peerlink/workspaces.json"
+	defaultWorkspaceName  = "default"
+	fileTransferProtocol  = "/file-transfer/1.0.0"
+	legacyPresenceTopic   = "file-presence"
+)
+
+type WorkspaceConfig struct {
+	Name        string       `json:"name"`
+	Group       string       `json:"group"`
+	Secret      string       `json:"secret,omitempty"` // shared by all members, derives ID and key (default: group)
+	SharedDir   string       `json:"shared_dir,omitempty"`
+	DownloadDir string       `json:"download_dir,omitempty"`
+	Sync        bool         `json:"sync,omitempty"`  // two-way sync of SharedDir with the members (folderSync.go)
+	Roots       []SharedRoot `json:"roots,omitempty"` // shared folders besides SharedDir, addressed as label:path (roots.go)
+}
+
+type Workspace struct {
+	WorkspaceConfig
+	ID       string // hex hash of the secret, names protocols / topic / mDNS tag
+	Key      []byte // AES-256 key for -E transfers
+	authKey  []byte // proves membership to other members (workspaceAuth.go)
+	StateDir string // ledger, objects and acks (unused by the legacy default workspace)
+	legacy   bool
+
+	Store   *MetadataStore
+	Objects *ObjectStore
+	Acks    *syncAckBook
+	Text    *TextIndex       // full-text index of SharedDir (fulltext.go)
+	Subs    *SubscriptionSet // mirroring rules (subscriptions.go)
+	Syncer  *FolderSync      // two-way sync state of SharedDir (folderSync.go)
+	Roots   []*SharedRoot    // SharedDir first, then the configured roots (roots.go)
+
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+	auth  memberAuth // peers that proved they hold the secret
+
+	searchTopic *pubsub.Topic
+	searchLock  sync.Mutex
+	searches    map[string]map[string]SearchResults // pending query ID → answering peer → results
+	lastSearch  []searchHit                         // what get <n> picks from
+
+	filesLock   sync.Mutex
+	files       map[string][]AnnouncedEntry // peerID → offered files/folders
+	filesDigest map[string]string           // peerID → digest of that listing
+	filesAt     map[string]time.Time        // peerID → when its listing arrived
+	filesTTL    map[string]time.Duration    // peerID → how long the listing stays valid (0: older node)
+
+	announceLock  sync.Mutex // one announcement at a time, deltas are computed against ws.listing
+	lastFullAt    time.Time
+	lastRequestAt time.Time
+
+	listingLock   sync.Mutex
+	listing       []AnnouncedEntry // what we announced last, served over /listing/1.0.0
+	listingDigest string
+	cids          map[string]cachedCID // path → CID, valid while size and mtime match
+
+	peersLock sync.Mutex
+	peers     map[string]bool // member peer ID → completed a /hello with us
+}
+
+var (
+	workspaces      []*Workspace
+	activeWorkspace *Workspace // what CLI commands act on (use <name>)
+)
+
+// loadWorkspaces reads the workspace list; a missing file means one default workspace
+func loadWorkspaces(path, defaultGroup string) ([]WorkspaceConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []WorkspaceConfig{{Name: defaultWorkspaceName, Group: defaultGroup}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[workspace][loadWorkspaces] %w", err)
+	}
+	var cfgs []WorkspaceConfig
+	if err := json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("[workspace][loadWorkspaces] bad %s: %w", path, err)
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("[workspace][loadWorkspaces] %s lists no workspace", path)
+	}
+
+	names := make(map[string]bool)
+	ids := make(map[string]string)
+	for i, cfg := range cfgs {
+		if cfg.Name == "" || cfg.Name != filepath.Base(cfg.Name) || strings.ContainsAny(cfg.Name, ` :@/\`) {
+			return nil, fmt.Errorf("[workspace][loadWorkspaces] workspace %d: bad name %q", i, cfg.Name)
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("[workspace][loadWorkspaces] workspace %q listed twice", cfg.Name)
+		}
+		names[cfg.Name] = true
+		if err := checkRoots(cfg); err != nil {
+			return nil, fmt.Errorf("[workspace][loadWorkspaces] %w", err)
+		}
+		if cfg.Group == "" {
+			if cfg.Name != defaultWorkspaceName {
+				return nil, fmt.Errorf("[workspace][loadWorkspaces] workspace %q has no group", cfg.Name)
+			}
+			cfgs[i].Group = defaultGroup
+		}
+		id := workspaceID(cfgs[i].secret())
+		if other, dup := ids[id]; dup {
+			return nil, fmt.Errorf("[workspace][loadWorkspaces] %q and %q share a group/secret, they would not be isolated", other, cfg.Name)
+		}
+		ids[id] = cfg.Name
+	}
+	return cfgs, nil
+}
+
+func (c WorkspaceConfig) secret() string {
+	if c.Secret != "" {
+		return c.Secret
+	}
+	return c.Group
+}
+
+func workspaceID(secret string) string {
+	sum := sha256.Sum256([]byte("peerlink-workspace|" + secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+func workspaceKey(secret string) []byte {
+	sum := sha256.Sum256([]byte("peerlink-workspace-key|" + secret))
+	return sum[:]
+}
+
+// openWorkspace creates the workspace's folders and opens its ledger and object store
+func openWorkspace(cfg WorkspaceConfig, storeKind string, retention RetentionPolicy) (*Workspace, error) {
+	ws := &Workspace{
+		WorkspaceConfig: cfg,
+		ID:              workspaceID(cfg.secret()),
+		Key:             workspaceKey(cfg.secret()),
+		authKey:         workspaceAuthKey(cfg.secret()),
+		auth:            newMemberAuth(),
+		legacy:          cfg.Name == defaultWorkspaceName,
+		files:           make(map[string][]AnnouncedEntry),
+		filesDigest:     make(map[string]string),
+		cids:            make(map[string]cachedCID),
+		searches:        make(map[string]map[string]SearchResults),
+		Text:            NewTextIndex(),
+		filesAt:         make(map[string]time.Time),
+		filesTTL:        make(map[string]time.Duration),
+		peers:           make(map[string]bool),
+	}
+	ws.StateDir = filepath.Join(".peerlink", "workspaces", cfg.Name)
+	if ws.SharedDir == "" {
+		ws.SharedDir = ws.contentPath("shared")
+	}
+	if ws.DownloadDir == "" {
+		ws.DownloadDir = ws.contentPath("TransferredFiles")
+	}
+	if !ws.legacy {
+		if err := os.MkdirAll(ws.StateDir, os.ModePerm); err != nil {
+			return nil, fmt.Errorf("[workspace][openWorkspace] %s: %w", cfg.Name, err)
+		}
+	}
+	if err := ws.openRoots(); err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(ws.DownloadDir); err == nil {
+		ws.DownloadDir = abs
+	}
+
+	var err error
+	ws.Objects, err = NewObjectStore(ws.path(objectStoreDir, "objects"), retention)
+	if err != nil {
+		return nil, err
+	}
+	ws.Acks = loadSyncAcks(ws.path(gcAcksFile, "gc-acks.json"))
+	ws.Subs, err = loadSubscriptions(ws.path(subscriptionsFile, "subscriptions.json"))
+	if err != nil {
+		return nil, err
+	}
+	ws.Syncer, err = loadFolderSync(ws.path(syncStateFile, "sync-state.json"))
+	if err != nil {
+		return nil, err
+	}
+	backend, err := openMetadataBackend(storeKind, ws.path(metadataFilePath, "sync-metadata.json"), ws.path(boltLedgerPath, "ledger.db"))
+	if err != nil {
+		return nil, err
+	}
+	ws.Store = NewMetadataStore(backend)
+	if err := ws.Store.Load(); err != nil {
+		ws.Store.Close()
+		return nil, fmt.Errorf("[workspace][openWorkspace] %s: %w", cfg.Name, err)
+	}
+	return ws, nil
+}
+
+// path picks the pre-workspace location for the default workspace, StateDir/name otherwise
+func (ws *Workspace) path(legacy, name string) string {
+	if ws.legacy {
+		return legacy
+	}
+	return filepath.Join(ws.StateDir, name)
+}
+
+// contentPath is the default location of a user facing folder, kept out of .peerlink
+func (ws *Workspace) contentPath(name string) string {
+	if ws.legacy {
+		return name
+	}
+	return filepath.Join("workspaces", ws.Name, name)
+}
+
+func (ws *Workspace) Close() error {
+	return ws.Store.Close()
+}
+
+// Protocol namespaces a stream protocol to this workspace
+func (ws *Workspace) Protocol(base protocol.ID) protocol.ID {
+	if ws.legacy {
+		return base
+	}
+	return protocol.ID("/peerlink/ws/"+ws.ID) + base
+}
+
+func (ws *Workspace) TopicName() string {
+	if ws.legacy {
+		return legacyPresenceTopic
+	}
+	return "peerlink/" + ws.ID + "/file-presence"
+}
+
+func (ws *Workspace) SearchTopicName() string {
+	if ws.legacy {
+		return legacySearchTopic
+	}
+	return "peerlink/" + ws.ID + "/search"
+}
+
+func (ws *Workspace) MdnsTag() string {
+	if ws.legacy {
+		return ws.Group
+	}
+	return "peerlink-" + ws.ID
+}
+
+// RendezvousGroup is what the DHT rendezvous namespace is derived from
+func (ws *Workspace) RendezvousGroup() string {
+	return ws.secret()
+}
+
+// notePeer records peerID as a member of the workspace; synced marks a completed /hello.
+// Callers have verified the peer first (workspaceAuth.go).
+// It reports whether this is the first completed sync with that peer.
+func (ws *Workspace) notePeer(peerID string, synced bool) bool {
+	ws.peersLock.Lock()
+	defer ws.peersLock.Unlock()
+	first := synced && !ws.peers[peerID]
+	ws.peers[peerID] = ws.peers[peerID] || synced
+	return first
+}
+
+func (ws *Workspace) forgetPeer(peerID string) {
+	ws.peersLock.Lock()
+	delete(ws.peers, peerID)
+	ws.peersLock.Unlock()
+	ws.forgetAuth(peerID)
+}
+
+func (ws *Workspace) isMember(peerID string) bool {
+	ws.peersLock.Lock()
+	defer ws.peersLock.Unlock()
+	_, ok := ws.peers[peerID]
+	return ok
+}
+
+// Members returns the peer IDs seen in this workspace, sorted
+func (ws *Workspace) Members() []string {
+	ws.peersLock.Lock()
+	defer ws.peersLock.Unlock()
+	ids := make([]string, 0, len(ws.peers))
+	for id := range ws.peers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func workspaceByName(name string) (*Workspace, bool) {
+	for _, ws := range workspaces {
+		if ws.Name == name {
+			return ws, true
+		}
+	}
+	return nil, false
+}
+
+func cmdListWorkspaces() error {
+	fmt.Println("🗂️  Workspaces:")
+	for _, ws := range workspaces {
+		marker := " "
+		if ws == activeWorkspace {
+			marker = "*"
+		}
+		docs, terms := ws.Text.Size()
+		mode := "→ downloads " + ws.DownloadDir
+		if ws.Sync {
+			mode = "⇄ synced, downloads " + ws.DownloadDir
+		}
+		shared := ws.SharedDir
+		if extra := len(ws.Roots) - 1; extra > 0 {
+			shared += fmt.Sprintf(" +%d root(s)", extra)
+		}
+		fmt.Printf(" %s %-12s id %s  %d member(s)  shared %s %s  (%d text files / %d words indexed)\n",
+			marker, ws.Name, ws.ID, len(ws.Members()), shared, mode, docs, terms)
+	}
+	return nil
+}
+
+func cmdUseWorkspace(name string) error {
+	ws, ok := workspaceByName(name)
+	if !ok {
+		return fmt.Errorf("[workspace][cmdUseWorkspace] no workspace named '%s' (see 'workspaces')", name)
+	}
+	activeWorkspace = ws
+	log.Printf("[CLI] 🗂️  Now working in '%s' (%s)", ws.Name, ws.SharedDir)
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+/*
+──────────────────────────────────────────────────────────────────────────────
+                               # OBJECTIVES
+
+The workspace ID is in every protocol ID (identify advertises them), so knowing it
+must not be enough to sync, download or count as a member
+
+1. Mutual challenge-response over /member-auth/1.0.0 proving both sides hold the
+   workspace secret, without sending it [DONE]
+2. Every workspace stream handler (hello, file-transfer, listing, search results) is
+   guarded, every stream we open authenticates first (ws.newStream) [DONE]
+3. Only authenticated peers become members (notePeer); announcements of anyone else
+   are ignored until the handshake succeeds [DONE]
+
+──────────────────────────────────────────────────────────────────────────────
+                                # HANDSHAKE
+
+ A → B   nonceA
+ B → A   nonceB, HMAC(authKey, "responder" | ws | nonceA | nonceB | B | A)
+ A → B   HMAC(authKey, "initiator" | ws | nonceA | nonceB | A | B)
+ B → A   1 (accepted)
+
+──────────────────────────────────────────────────────────────────────────────
+                                # NOTES
+
+- authKey is derived from the secret apart from the -E key and the ID
+- Peer IDs are bound to the connection by libp2p's secure channel, so a verified peer
+  stays verified until it is forgotten (peerLiveness.go)
+- A failed handshake is remembered for authRetryAfter, so a non-member publishing on
+  the topic does not cause a handshake per message. That includes peers that can't be
+  dialed or don't speak the auth protocol (older nodes), not only wrong proofs
+- "default" without a "secret" stays open: its group is the public mDNS tag and older
+  nodes do not speak the handshake. Give it a secret to close it
+- Announcements are still published on the topic in the clear, a non-member who
+  subscribes by ID can read names and sizes but can fetch nothing
+──────────────────────────────────────────────────────────────────────────────
+*/
+
+const (
+	memberAuthProtocol = "/member-auth/1.0.0"
+	authNonceSize      = 32
+	authTimeout        = 10 * time.Second
+	authRetryAfter     = time.Minute
+)
+
+var errNotMember = errors.New("peer does not hold the workspace secret")
+
+type authCall struct {
+	done chan struct{}
+	err  error
+}
+
+// authFailure is a failed handshake, answered from memory until authRetryAfter passed
+type authFailure struct {
+	at  time.Time
+	err error
+}
+
+// memberAuth remembers which peers proved they hold the secret
+type memberAuth struct {
+	mu      sync.Mutex
+	ok      map[string]bool
+	failed  map[string]authFailure
+	pending map[string]*authCall
+}
+
+func newMemberAuth() memberAuth {
+	return memberAuth{ok: make(map[string]bool), failed: make(map[string]authFailure), pending: make(map[string]*authCall)}
+}
+
+func workspaceAuthKey(secret string) []byte {
+	sum := sha256.Sum256([]byte("peerlink-workspace-auth|" + secret))
+	return sum[:]
+}
+
+// needsAuth is false only for the legacy default workspace without an explicit secret
+func (ws *Workspace) needsAuth() bool {
+	return !ws.legacy || ws.Secret != ""
+}
+
+func (ws *Workspace) authenticated(peerID string) bool {
+	if !ws.needsAuth() {
+		return true
+	}
+	ws.auth.mu.Lock()
+	defer ws.auth.mu.Unlock()
+	return ws.auth.ok[peerID]
+}
+
+func (ws *Workspace) forgetAuth(peerID string) {
+	ws.auth.mu.Lock()
+	delete(ws.auth.ok, peerID)
+	delete(ws.auth.failed, peerID)
+	ws.auth.mu.Unlock()
+}
+
+func (ws *Workspace) authMAC(role string, nonceA, nonceB []byte, prover, verifier peer.ID) []byte {
+	mac := hmac.New(sha256.New, ws.authKey)
+	fmt.Fprintf(mac, "peerlink-auth|%s|%s|", role, ws.ID)
+	mac.Write(nonceA)
+	mac.Write(nonceB)
+	mac.Write([]byte(prover))
+	mac.Write([]byte(verifier))
+	return mac.Sum(nil)
+}
+
+// authenticate runs the handshake with p unless it already passed; concurrent callers share one run
+func (ws *Workspace) authenticate(ctx context.Context, p peer.ID) error {
+	id := p.String()
+	if ws.authenticated(id) {
+		return nil
+	}
+	ws.auth.mu.Lock()
+	if f, ok := ws.auth.failed[id]; ok && time.Since(f.at) < authRetryAfter {
+		ws.auth.mu.Unlock()
+		return fmt.Errorf("%w (retry in %s)", f.err, (authRetryAfter - time.Since(f.at)).Round(time.Second))
+	}
+	if call, ok := ws.auth.pending[id]; ok {
+		ws.auth.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &authCall{done: make(chan struct{})}
+	ws.auth.pending[id] = call
+	ws.auth.mu.Unlock()
+
+	call.err = ws.runAuthHandshake(ctx, p)
+
+	ws.auth.mu.Lock()
+	delete(ws.auth.pending, id)
+	if call.err != nil && ctx.Err() == nil { // our caller giving up says nothing about the peer
+		ws.auth.failed[id] = authFailure{at: time.Now(), err: call.err}
+	}
+	ws.auth.mu.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// runAuthHandshake is the initiator's side of the handshake
+func (ws *Workspace) runAuthHandshake(ctx context.Context, p peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, authTimeout)
+	defer cancel()
+	s, err := node.NewStream(ctx, p, ws.Protocol(memberAuthProtocol))
+	if err != nil {
+		return fmt.Errorf("[WorkspaceAuth][authenticate] %s: %w", shortID(p.String()), err)
+	}
+	defer s.Close()
+	_ = s.SetDeadline(time.Now().Add(authTimeout))
+
+	nonceA := make([]byte, authNonceSize)
+	if _, err := rand.Read(nonceA); err != nil {
+		return fmt.Errorf("[WorkspaceAuth][authenticate] %w", err)
+	}
+	if _, err := s.Write(nonceA); err != nil {
+		return fmt.Errorf("[WorkspaceAuth][authenticate] %s: %w", shortID(p.String()), err)
+	}
+	reply := make([]byte, authNonceSize+sha256.Size)
+	if _, err := io.ReadFull(s, reply); err != nil {
+		return fmt.Errorf("[WorkspaceAuth][authenticate] %s: %w: no proof (%v)", shortID(p.String()), errNotMember, err)
+	}
+	nonceB, proof := reply[:authNonceSize], reply[authNonceSize:]
+	if !hmac.Equal(proof, ws.authMAC("responder", nonceA, nonceB, p, node.ID())) {
+		_ = s.Reset()
+		return fmt.Errorf("[WorkspaceAuth][authenticate] %s: %w", shortID(p.String()), errNotMember)
+	}
+	if _, err := s.Write(ws.authMAC("initiator", nonceA, nonceB, node.ID(), p)); err != nil {
+		return fmt.Errorf("[WorkspaceAuth][authenticate] %s: %w", shortID(p.String()), err)
+	}
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(s, ack); err != nil || ack[0] != 1 {
+		return fmt.Errorf("[WorkspaceAuth][authenticate] %s did not accept our proof", shortID(p.String()))
+	}
+	ws.admit(p.String())
+	return nil
+}
+
+// registerAuthHandler answers handshakes started by other members
+func registerAuthHandler(h host.Host, ws *Workspace) {
+	if !ws.needsAuth() {
+		return
+	}
+	h.SetStreamHandler(ws.Protocol(memberAuthProtocol), func(s network.Stream) {
+		defer s.Close()
+		_ = s.SetDeadline(time.Now().Add(authTimeout))
+		remote := s.Conn().RemotePeer()
+
+		nonceA := make([]byte, authNonceSize)
+		if _, err := io.ReadFull(s, nonceA); err != nil {
+			_ = s.Reset()
+			return
+		}
+		nonceB := make([]byte, authNonceSize)
+		if _, err := rand.Read(nonceB); err != nil {
+			_ = s.Reset()
+			return
+		}
+		if _, err := s.Write(append(nonceB, ws.authMAC("responder", nonceA, nonceB, h.ID(), remote)...)); err != nil {
+			return
+		}
+		proof := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(s, proof); err != nil {
+			return
+		}
+		if !hmac.Equal(proof, ws.authMAC("initiator", nonceA, nonceB, remote, h.ID())) {
+			log.Printf("[WorkspaceAuth][/member-auth] %s failed the handshake for %s", shortID(remote.String()), ws.Name)
+			_ = s.Reset()
+			return
+		}
+		ws.admit(remote.String())
+		_, _ = s.Write([]byte{1})
+	})
+	log.Printf("[Stream] Handler registered for %s", ws.Protocol(memberAuthProtocol))
+}
+
+// admit marks a peer as a verified member
+func (ws *Workspace) admit(peerID string) {
+	ws.auth.mu.Lock()
+	first := !ws.auth.ok[peerID]
+	ws.auth.ok[peerID] = true
+	delete(ws.auth.failed, peerID)
+	ws.auth.mu.Unlock()
+	ws.notePeer(peerID, false)
+	if first {
+		log.Printf("[WorkspaceAuth] %s proved membership of %s", shortID(peerID), ws.Name)
+	}
+}
+
+// guarded wraps a workspace stream handler so it only ever serves verified members
+func (ws *Workspace) guarded(handler network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		remote := s.Conn().RemotePeer()
+		if err := ws.authenticate(context.Background(), remote); err != nil {
+			log.Printf("[WorkspaceAuth] Refusing %s from %s: %v", s.Protocol(), shortID(remote.String()), err)
+			_ = s.Reset()
+			return
+		}
+		handler(s)
+	}
+}
+
+// newStream opens a workspace protocol stream to p once p proved it is a member
+func (ws *Workspace) newStream(ctx context.Context, p peer.ID, protos ...protocol.ID) (network.Stream, error) {
+	if err := ws.authenticate(ctx, p); err != nil {
+		return nil, err
+	}
+	return node.NewStream(ctx, p, protos...)
+}
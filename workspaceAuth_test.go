@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// TestAuthFailureCached: a peer without the auth protocol is not asked again on every message
+func TestAuthFailureCached(t *testing.T) {
+	a, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	saved := node
+	node = a
+	defer func() { node = saved }()
+	if err := a.Connect(context.Background(), *host.InfoFromHost(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := newTestWorkspace(t, "auth")
+	first := ws.authenticate(context.Background(), b.ID())
+	if first == nil {
+		t.Fatal("peer without the auth protocol authenticated")
+	}
+	b.Close() // any new attempt would now fail differently
+	again := ws.authenticate(context.Background(), b.ID())
+	if again == nil || !strings.Contains(again.Error(), "retry in") || !strings.Contains(again.Error(), first.Error()) {
+		t.Errorf("second attempt not answered from the cache: %v", again)
+	}
+}